@@ -0,0 +1,163 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// addrHistoryBucket lists the transactions touching every address, see addrHistoryKey
+	addrHistoryBucket = "addrhistory"
+	// addrHistoryTipKey stores, inside the meta bucket, the hash of the last block of the address history index. It lets an index that was disabled for a while catch up when it is enabled again.
+	addrHistoryTipKey = "a"
+)
+
+// errStop ends a ForEach early, it is never returned to callers
+const errStop = Error("stop iteration")
+
+// Flags of an address history entry
+const (
+	// historyFunding marks a transaction paying to the address
+	historyFunding byte = 1 << 0
+	// historySpending marks a transaction spending outputs of the address
+	historySpending byte = 1 << 1
+)
+
+// AddressTx is a transaction of an address history. A transaction sending change back to the address both funds and spends it.
+type AddressTx struct {
+	TxID     []byte
+	Height   int64
+	Funding  bool
+	Spending bool
+}
+
+// AddressHistory returns the transactions paying to or spending from address, oldest first, skipping the first offset ones and returning at most limit of them. A limit of 0 returns them all. It fails with ErrNoAddressIndex unless the blockchain was opened WithAddressIndex.
+func (bc *Blockchain) AddressHistory(address []byte, offset, limit int) ([]*AddressTx, error) {
+	if !bc.addrIndex {
+		return nil, ErrNoAddressIndex
+	}
+	if offset < 0 || limit < 0 {
+		return nil, ErrBadRequest
+	}
+
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+	prefix := append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+
+	var history []*AddressTx
+	err = bc.view(func(tx StoreTx) error {
+		return tx.ForEachPrefix([]byte(addrHistoryBucket), prefix, func(k, v []byte) error {
+			if offset > 0 {
+				offset--
+				return nil
+			}
+			if limit > 0 && len(history) == limit {
+				return errStop
+			}
+			if len(k) < len(prefix)+8 || len(v) != 1 {
+				return ErrMalformedEncoding
+			}
+
+			history = append(history, &AddressTx{
+				TxID:     append([]byte{}, k[len(prefix)+8:]...),
+				Height:   int64(binary.BigEndian.Uint64(k[len(prefix):])),
+				Funding:  v[0]&historyFunding != 0,
+				Spending: v[0]&historySpending != 0,
+			})
+			return nil
+		})
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// addrHistoryKey is the address history key of a transaction: the length of the pubkey hash, the pubkey hash, the big endian height of the block and the transaction ID, so the history of an address is in block order
+func addrHistoryKey(pubKeyHash []byte, height int64, txID []byte) []byte {
+	key := append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+	key = append(key, IntToByte(height)...)
+	return append(key, txID...)
+}
+
+// blockHistory returns the address history entries of a block, keyed by addrHistoryKey
+func blockHistory(block *Block) (map[string]byte, error) {
+	entries := make(map[string]byte)
+	for _, t := range block.Transactions {
+		for _, out := range t.Outputs {
+			entries[string(addrHistoryKey(out.PubKeyHash, block.Height, t.ID))] |= historyFunding
+		}
+		if t.IsCoinbase() {
+			continue
+		}
+		for _, in := range t.Inputs {
+			pubKeyHash, err := hashPubKey(in.PubKey)
+			if err != nil {
+				return nil, err
+			}
+			entries[string(addrHistoryKey(pubKeyHash, block.Height, t.ID))] |= historySpending
+		}
+	}
+	return entries, nil
+}
+
+// connectHistory adds the transactions of a block connected to the chain to the address history index
+func connectHistory(tx StoreTx, block *Block) error {
+	entries, err := blockHistory(block)
+	if err != nil {
+		return err
+	}
+	for key, flags := range entries {
+		if err := tx.Put([]byte(addrHistoryBucket), []byte(key), []byte{flags}); err != nil {
+			return err
+		}
+	}
+	return tx.Put([]byte(metaBucket), []byte(addrHistoryTipKey), block.Hash)
+}
+
+// disconnectHistory removes the transactions of the tip block from the address history index when it is disconnected from the chain
+func disconnectHistory(tx StoreTx, block *Block) error {
+	entries, err := blockHistory(block)
+	if err != nil {
+		return err
+	}
+	for key := range entries {
+		if err := tx.Delete([]byte(addrHistoryBucket), []byte(key)); err != nil {
+			return err
+		}
+	}
+	return tx.Put([]byte(metaBucket), []byte(addrHistoryTipKey), block.PrevBlockHash)
+}
+
+// syncAddressHistory brings the address history index up to the tip, building it from the genesis block when it doesn't exist yet. An index left behind on a branch the chain moved away from, while the index was disabled, is rebuilt from scratch. It needs the blocks it indexes so it fails with ErrBlockPruned when they were pruned.
+func syncAddressHistory(store Store) error {
+	return store.Update(func(tx StoreTx) error {
+		indexed := tx.Get([]byte(metaBucket), []byte(addrHistoryTipKey))
+
+		var blocks []*Block
+		for hash := tx.Tip(); !bytes.Equal(hash, indexed); {
+			block, err := tx.Block(hash)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+			if len(block.PrevBlockHash) == 0 {
+				if err := tx.DeleteBucket([]byte(addrHistoryBucket)); err != nil {
+					return err
+				}
+				break
+			}
+			hash = block.PrevBlockHash
+		}
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if err := connectHistory(tx, blocks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package hoji
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// checkHistory fails unless history holds the transactions txIDs, in that order, with the given flags
+func checkHistory(t *testing.T, history []*AddressTx, txIDs [][]byte, funding, spending []bool) {
+	t.Helper()
+
+	if len(history) != len(txIDs) {
+		t.Fatalf("history has %d transactions, want %d", len(history), len(txIDs))
+	}
+	for i, entry := range history {
+		if !bytes.Equal(entry.TxID, txIDs[i]) || entry.Funding != funding[i] || entry.Spending != spending[i] {
+			t.Fatalf("entry %d is %x funding %v spending %v, want %x funding %v spending %v", i, entry.TxID, entry.Funding, entry.Spending, txIDs[i], funding[i], spending[i])
+		}
+	}
+}
+
+func TestAddressHistory(t *testing.T) {
+	c := newTestChain(t, nil, WithAddressIndex(true))
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+
+	payment := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(4, payee), NewTxOutput(reward.Value-4, c.miner))
+	first := c.mine(t, payment)
+	// the payee pays the miner and sends the change back to itself
+	change := c.spend(t, payee, []OutPoint{{TxID: payment.ID, Index: 0}}, NewTxOutput(1, c.miner), NewTxOutput(3, payee))
+	second := c.mine(t, change)
+	c.mine(t)
+
+	history, err := c.AddressHistory(payee, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, history, [][]byte{payment.ID, change.ID}, []bool{true, true}, []bool{false, true})
+	if history[0].Height != first.Height || history[1].Height != second.Height {
+		t.Fatalf("transactions at heights %d and %d, want %d and %d", history[0].Height, history[1].Height, first.Height, second.Height)
+	}
+
+	// pages of the miner's history, its rewards and both transactions, follow each other
+	all, err := c.AddressHistory(c.miner, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 5 {
+		t.Fatalf("miner has %d transactions, want its rewards and both transactions", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Height < all[i-1].Height {
+			t.Fatalf("transaction %d at height %d comes after one at height %d", i, all[i].Height, all[i-1].Height)
+		}
+	}
+	for offset := 0; offset <= len(all); offset++ {
+		page, err := c.AddressHistory(c.miner, offset, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := all[offset:min(offset+2, len(all))]
+		if len(page) != len(want) {
+			t.Fatalf("page at %d has %d transactions, want %d", offset, len(page), len(want))
+		}
+		for i := range page {
+			if !bytes.Equal(page[i].TxID, want[i].TxID) {
+				t.Fatalf("page at %d holds %x, want %x", offset, page[i].TxID, want[i].TxID)
+			}
+		}
+	}
+
+	if _, err := c.AddressHistory(payee, -1, 0); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("negative offset: got %v, want ErrBadRequest", err)
+	}
+	if _, err := c.AddressHistory([]byte("1"), 0, 0); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("short address: got %v, want ErrBadRequest", err)
+	}
+
+	// the invalidated block's transaction leaves the history
+	if err := c.InvalidateBlock(second.Hash); err != nil {
+		t.Fatal(err)
+	}
+	history, err = c.AddressHistory(payee, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, history, [][]byte{payment.ID}, []bool{true}, []bool{false})
+}
+
+func TestAddressHistoryCatchUp(t *testing.T) {
+	c := newTestChain(t, nil)
+	if _, err := c.AddressHistory(c.miner, 0, 0); !errors.Is(err, ErrNoAddressIndex) {
+		t.Fatalf("got %v, want ErrNoAddressIndex", err)
+	}
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	payment := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(4, payee), NewTxOutput(reward.Value-4, c.miner))
+	c.mine(t, payment)
+
+	// the index is built from the blocks mined without it
+	opts := c.opts
+	c.opts = append(opts, WithAddressIndex(true))
+	c.reopen(t)
+	history, err := c.AddressHistory(payee, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, history, [][]byte{payment.ID}, []bool{true}, []bool{false})
+
+	// and catches up with the blocks mined while it was disabled again
+	c.opts = opts
+	c.reopen(t)
+	spend := c.spend(t, payee, []OutPoint{{TxID: payment.ID, Index: 0}}, NewTxOutput(4, c.miner))
+	c.mine(t, spend)
+	c.opts = append(opts, WithAddressIndex(true))
+	c.reopen(t)
+	history, err = c.AddressHistory(payee, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, history, [][]byte{payment.ID, spend.ID}, []bool{true, false}, []bool{false, true})
+}
@@ -2,25 +2,44 @@ package hoji
 
 import (
 	"bytes"
-	"encoding/gob"
 	"time"
 )
 
 // Block is the data structure that holds the blockchain's data.In bitcoin the block holds an array on transactions. Their block size limit is 1mb.
 type Block struct {
+	Version       uint32
 	Timestamp     int64
 	Transactions  []*Transaction
 	PrevBlockHash []byte
 	Hash          []byte
 	Nonce         int
+	// Bits is the difficulty the block was mined at: the number of leading zero bits of its hash
+	Bits uint32
+	// Height is the block's distance from the genesis block. Like Hash it isn't encoded, the store fills it in.
+	Height int64
 }
 
-//NewBlock creates and returns a new block for the blockchain
-func NewBlock(tx []*Transaction, PrevBlockHash []byte) *Block {
+// BlockHeader holds everything a block's proof of work commits to. The merkle root stands in for the transactions so a header, together with a merkle proof, is enough to show a transaction is in a block.
+type BlockHeader struct {
+	Version       uint32
+	Timestamp     int64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Nonce         int
+	Bits          uint32
+	Hash          []byte
+	// Height is filled in by the store, like Block.Height
+	Height int64
+}
+
+//NewBlock creates and mines a new block for the blockchain at the given difficulty
+func NewBlock(tx []*Transaction, PrevBlockHash []byte, bits uint32) *Block {
 	b := &Block{
+		Version:       blockVersion,
 		Timestamp:     time.Now().Unix(),
 		Transactions:  tx,
 		PrevBlockHash: PrevBlockHash,
+		Bits:          bits,
 	}
 	// We need the other properties of the Block to be set to generate a hash. That's why we have a special method for it that we call after setting the value for the other Block struct properties
 	b.SetHash()
@@ -28,11 +47,6 @@ func NewBlock(tx []*Transaction, PrevBlockHash []byte) *Block {
 	return b
 }
 
-//NewGenesisBlock creates and returns a new genesis block for the blockchain. The genesis block is the first block created in the blockchain. Since a block needs a previous block to be created we much create the first block "artificially"
-func NewGenesisBlock(coinbaseTx *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbaseTx}, []byte{})
-}
-
 //SetHash creates the hash(I like to think of it as the block's ID) for a block.
 // NOTE: should I just return a new block? It is more computationally expensive but makes for better code debugging imo.
 func (b *Block) SetHash() {
@@ -43,8 +57,18 @@ func (b *Block) SetHash() {
 	b.Nonce = nonce
 }
 
-//HashTransactions will hash the blocks transaction struct slice and return it. It does this by concatinating all the transaction ids and then sha256 hasing them.
+//HashTransactions will hash the blocks transaction struct slice and return it. It does this by building a merkle tree out of the encoded transactions and returning its root.
 func (b *Block) HashTransactions() ([]byte, error) {
+	mTree, err := b.MerkleTree()
+	if err != nil {
+		return nil, err
+	}
+
+	return mTree.RootNode.Data, nil
+}
+
+//MerkleTree builds the merkle tree of the block's transactions, leaf i is the encoding of transaction i
+func (b *Block) MerkleTree() (*MerkleTree, error) {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
@@ -54,25 +78,76 @@ func (b *Block) HashTransactions() ([]byte, error) {
 		}
 		transactions = append(transactions, b)
 	}
-	mTree := NewMerkleTree(transactions)
 
-	return mTree.RootNode.Data, nil
+	return NewMerkleTree(transactions), nil
 }
 
-//Bytes transforms a Block struct to a byte array
+//Header returns the block's header
+func (b *Block) Header() (*BlockHeader, error) {
+	merkleRoot, err := b.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockHeader{
+		Version:       b.Version,
+		Timestamp:     b.Timestamp,
+		PrevBlockHash: b.PrevBlockHash,
+		MerkleRoot:    merkleRoot,
+		Nonce:         b.Nonce,
+		Bits:          b.Bits,
+		Hash:          b.Hash,
+		Height:        b.Height,
+	}, nil
+}
+
+//Bytes transforms a BlockHeader into its wire format
+func (h *BlockHeader) Bytes() ([]byte, error) {
+	result := new(bytes.Buffer)
+	if err := writeBlockHeader(result, h); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+//BytesToBlockHeader decodes a BlockHeader and computes its hash
+func BytesToBlockHeader(v []byte) (*BlockHeader, error) {
+	var h *BlockHeader
+	if err := decodeAll(v, func(r *bytes.Reader) error {
+		var err error
+		h, err = readBlockHeader(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+//Bytes transforms a Block struct to a byte array using the wire format described in encoding.go
 func (b *Block) Bytes() ([]byte, error) {
 	result := new(bytes.Buffer)
-	if err := gob.NewEncoder(result).Encode(b); err != nil {
+	if err := writeBlock(result, b); err != nil {
 		return nil, err
 	}
 	return result.Bytes(), nil
 }
 
-//BytesToBlock tranforms a byte array into a Block struct
+//BytesToBlock tranforms a byte array into a Block struct. The block hash isn't part of the encoding so it is recomputed from the block's proof of work data.
 func BytesToBlock(v []byte) (*Block, error) {
-	b := new(Block)
-	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(b); err != nil {
+	var b *Block
+	if err := decodeAll(v, func(r *bytes.Reader) error {
+		var err error
+		b, err = readBlock(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	hash, err := NewPOW(b).hash(b.Nonce)
+	if err != nil {
 		return nil, err
 	}
+	b.Hash = hash
+
 	return b, nil
 }
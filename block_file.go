@@ -0,0 +1,252 @@
+package hoji
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+)
+
+// maxBlockFileRecord caps the size of a block read from a block file
+const maxBlockFileRecord = 32 << 20
+
+// ExportBlocks writes the blocks from height from to height to, both included, to w in the block file format described in encoding.go. A negative to exports up to the tip. progress, if not nil, is called after every block with the number of blocks written so far and the block's height. It fails with ErrBlockPruned when a block was pruned.
+func (bc *Blockchain) ExportBlocks(w io.Writer, from, to int64, progress func(blocks int, height int64)) (int, error) {
+	exported := 0
+	bci := bc.RangeIterator(context.Background(), from, to)
+	for {
+		block, err := bci.Next()
+		if err == ErrNoMoreBlocks {
+			return exported, nil
+		}
+		if err != nil {
+			return exported, err
+		}
+
+		if err := writeBlockRecord(w, bc.params.Magic, block); err != nil {
+			return exported, err
+		}
+		exported++
+		if progress != nil {
+			progress(exported, block.Height)
+		}
+	}
+}
+
+// ImportBlocks reads a block file written by ExportBlocks and adds its blocks to the chain, oldest first. A block extending the tip goes through the same checks as a mined block, proof of work, coinbase, signatures and values, and updates the UTXO set. A block that is only missing its transactions, on a node that was pruned or bootstrapped from a UTXO snapshot, gets them back after they are checked against its header and filter; a pruned node keeps the blocks it would prune again. Blocks the node already has are skipped. It returns the number of blocks added, progress, if not nil, is called after each of them.
+func (bc *Blockchain) ImportBlocks(r io.Reader, progress func(blocks int, height int64)) (int, error) {
+	imported := 0
+	for {
+		block, err := readBlockRecord(r, bc.params.Magic)
+		if err == io.EOF {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, err
+		}
+
+		added, err := bc.importBlock(block)
+		if err != nil {
+			return imported, &BadBlockError{Hash: block.Hash, Height: block.Height, Err: err}
+		}
+		if !added {
+			continue
+		}
+		imported++
+		if progress != nil {
+			progress(imported, block.Height)
+		}
+	}
+}
+
+// importBlock adds a block read from a block file, added is false when the node already had it
+func (bc *Blockchain) importBlock(block *Block) (added bool, err error) {
+	var known, pruned bool
+	if err := bc.view(func(tx StoreTx) error {
+		header, err := tx.Header(block.Hash)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		known = true
+		block.Height = header.Height
+
+		_, err = tx.Block(block.Hash)
+		if err == ErrBlockPruned {
+			pruned = true
+			return nil
+		}
+		return err
+	}); err != nil {
+		return false, err
+	}
+	if pruned && bc.prune == 0 {
+		if err := bc.restoreBlock(block); err != nil {
+			return false, err
+		}
+		bc.log.chain.Debug("pruned block restored", "height", block.Height, hashAttr("hash", block.Hash))
+		return true, nil
+	}
+	if known {
+		return false, nil
+	}
+
+	if err := bc.checkBlock(block); err != nil {
+		return false, err
+	}
+	if err := bc.update(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Tip(), block.PrevBlockHash) {
+			return ErrStaleTip
+		}
+		return bc.connectBlock(tx, block)
+	}); err != nil {
+		return false, err
+	}
+	bc.log.chain.Info("block connected", "height", block.Height, hashAttr("hash", block.Hash), "txs", len(block.Transactions))
+	return true, nil
+}
+
+// checkBlock validates a block extending the tip like MineBlock validates the blocks it mines, the proof of work included
+func (bc *Blockchain) checkBlock(block *Block) error {
+	if err := bc.view(func(tx StoreTx) error {
+		prev, err := tx.Header(tx.Tip())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(block.PrevBlockHash, prev.Hash) {
+			return ErrHeaderNotConnected
+		}
+		block.Height = prev.Height + 1
+
+		bits, err := bc.nextBits(tx, prev)
+		if err != nil {
+			return err
+		}
+		if block.Bits != bits || !NewPOW(block).Validate() {
+			return ErrInvalidHeader
+		}
+		return bc.checkTransactions(block)
+	}); err != nil {
+		return err
+	}
+
+	for _, t := range block.Transactions {
+		if err := bc.checkTransaction(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBlock puts back the transactions of a pruned block. The block hash commits to them so the stored header vouches for them, the filter recomputed from them has to match the stored one when there is one.
+func (bc *Blockchain) restoreBlock(block *Block) error {
+	if err := bc.checkTransactions(block); err != nil {
+		return err
+	}
+
+	return bc.update(func(tx StoreTx) error {
+		stored := append([]byte{}, tx.Get([]byte(cfheadersBucket), block.Hash)...)
+		if err := tx.PutBlock(block); err != nil {
+			return err
+		}
+		if err := putBlockFilter(tx, block); err != nil {
+			return err
+		}
+		if len(stored) > 0 && !bytes.Equal(stored, tx.Get([]byte(cfheadersBucket), block.Hash)) {
+			return ErrFilterMismatch
+		}
+
+		if block.Height == pruneHeight(tx) {
+			return restorePruneHeight(tx)
+		}
+		return nil
+	})
+}
+
+// restorePruneHeight clears the prune height once every block up to it has its transactions back
+func restorePruneHeight(tx StoreTx) error {
+	header, err := tx.Header(tx.Tip())
+	if err != nil {
+		return err
+	}
+	for len(header.PrevBlockHash) > 0 {
+		if header.Height <= pruneHeight(tx) {
+			if _, err := tx.Block(header.Hash); err == ErrBlockPruned {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if header, err = tx.Header(header.PrevBlockHash); err != nil {
+			return err
+		}
+	}
+	return tx.Delete([]byte(metaBucket), []byte(pruneHeightKey))
+}
+
+// checkTransactions checks the block's coinbase and that no transaction appears twice
+func (bc *Blockchain) checkTransactions(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ErrInvalidCoinbase
+	}
+	if err := bc.checkCoinbase(block.Transactions, block.Height); err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool)
+	for _, t := range block.Transactions {
+		id := hex.EncodeToString(t.ID)
+		if ids[id] {
+			return ErrDuplicateTx
+		}
+		ids[id] = true
+	}
+	return nil
+}
+
+// writeBlockRecord writes one block of a block file: the network magic, the size of the block and the block
+func writeBlockRecord(w io.Writer, magic [4]byte, block *Block) error {
+	blockBytes, err := block.Bytes()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(magic[:]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(blockBytes))); err != nil {
+		return err
+	}
+	_, err = w.Write(blockBytes)
+	return err
+}
+
+// readBlockRecord reads one block of a block file, io.EOF marks the end of the file
+func readBlockRecord(r io.Reader, magic [4]byte) (*Block, error) {
+	var m [4]byte
+	if _, err := io.ReadFull(r, m[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidBlockFile
+		}
+		return nil, err
+	}
+	if m != magic {
+		return nil, ErrInvalidBlockFile
+	}
+
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, ErrInvalidBlockFile
+	}
+	if size > maxBlockFileRecord {
+		return nil, ErrInvalidBlockFile
+	}
+	blockBytes := make([]byte, size)
+	if _, err := io.ReadFull(r, blockBytes); err != nil {
+		return nil, ErrInvalidBlockFile
+	}
+
+	return BytesToBlock(blockBytes)
+}
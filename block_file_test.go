@@ -0,0 +1,50 @@
+package hoji
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestImportRefusesInflation(t *testing.T) {
+	source := newTestChain(t, nil)
+	payee := source.newAddress(t)
+	spent := source.spendable(t, source.miner)[0]
+
+	for _, test := range []struct {
+		name   string
+		values []int
+		want   error
+	}{
+		{"more than the inputs", []int{spent.Value + 1}, ErrValueExceedsInputs},
+		{"negative output", []int{spent.Value + 5, -5}, ErrInvalidValue},
+		{"zero output", []int{spent.Value, 0}, ErrInvalidValue},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var outputs []*TxOutput
+			for _, value := range test.values {
+				outputs = append(outputs, NewTxOutput(value, payee))
+			}
+			tx := source.spend(t, source.miner, []OutPoint{spent.OutPoint}, outputs...)
+
+			if _, err := source.MineBlock([]*Transaction{tx}); !errors.Is(err, test.want) {
+				t.Fatalf("mining: got %v, want %v", err, test.want)
+			}
+
+			// a block mined around the checks is refused by a node importing it
+			tip := source.Tip()
+			block := source.newBlock(t, tx)
+
+			var file bytes.Buffer
+			if err := writeBlockRecord(&file, source.params.Magic, block); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := source.ImportBlocks(&file, nil); !errors.Is(err, test.want) {
+				t.Fatalf("importing: got %v, want %v", err, test.want)
+			}
+			if !bytes.Equal(source.Tip(), tip) {
+				t.Fatal("inflating block connected")
+			}
+		})
+	}
+}
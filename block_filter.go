@@ -0,0 +1,213 @@
+package hoji
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+const (
+	cfiltersBucket  = "cfilters"
+	cfheadersBucket = "cfheaders"
+)
+
+// Peer message commands for compact block filters
+const (
+	CmdGetCFilter = "getcfilter"
+	CmdCFilter    = "cfilter"
+)
+
+// MsgGetCFilter asks a full node for the compact filter of a block
+type MsgGetCFilter struct {
+	BlockHash []byte
+}
+
+// MsgCFilter answers getcfilter with the block's filter and its filter header
+type MsgCFilter struct {
+	BlockHash    []byte
+	Filter       []byte
+	FilterHeader []byte
+}
+
+// NewBlockFilter builds the compact filter of a block over the PubKeyHash of every output and the outpoint spent by every input. It is keyed by the first 16 bytes of the block hash.
+func NewBlockFilter(b *Block) (*GCSFilter, error) {
+	var items [][]byte
+	for _, tx := range b.Transactions {
+		for _, out := range tx.Outputs {
+			if len(out.PubKeyHash) > 0 {
+				items = append(items, out.PubKeyHash)
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			outPoint, err := in.PrevOut.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, outPoint)
+		}
+	}
+
+	return NewGCSFilter(BlockFilterKey(b.Hash), items), nil
+}
+
+// BlockFilterKey returns the SipHash key of the filter of the block with the given hash
+func BlockFilterKey(blockHash []byte) [16]byte {
+	var key [16]byte
+	copy(key[:], blockHash)
+	return key
+}
+
+// NextFilterHeader chains a filter to the header of the previous block's filter: sha256d(sha256d(filter) | prevHeader). The genesis block's filter is chained to 32 zero bytes.
+func NextFilterHeader(filter []byte, prevHeader []byte) []byte {
+	filterHash := doubleSHA256(filter)
+	return doubleSHA256(append(filterHash, prevHeader...))
+}
+
+// BlockFilter returns the compact filter of the block with the given hash
+func (bc *Blockchain) BlockFilter(blockHash []byte) (*GCSFilter, error) {
+	var filter *GCSFilter
+
+	err := bc.view(func(tx StoreTx) error {
+		v := tx.Get([]byte(cfiltersBucket), blockHash)
+		if v == nil {
+			return ErrNotFound
+		}
+
+		var err error
+		filter, err = BytesToGCSFilter(BlockFilterKey(blockHash), append([]byte{}, v...))
+		return err
+	})
+
+	return filter, err
+}
+
+// FilterHeader returns the filter header of the block with the given hash
+func (bc *Blockchain) FilterHeader(blockHash []byte) ([]byte, error) {
+	var header []byte
+
+	err := bc.view(func(tx StoreTx) error {
+		header = append([]byte{}, tx.Get([]byte(cfheadersBucket), blockHash)...)
+		if len(header) == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+
+	return header, err
+}
+
+// HandleGetCFilter answers a getcfilter message
+func (bc *Blockchain) HandleGetCFilter(msg *MsgGetCFilter) (*MsgCFilter, error) {
+	filter, err := bc.BlockFilter(msg.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	header, err := bc.FilterHeader(msg.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	filterBytes, err := filter.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MsgCFilter{
+		BlockHash:    msg.BlockHash,
+		Filter:       filterBytes,
+		FilterHeader: header,
+	}, nil
+}
+
+// putBlockFilter computes and stores the filter and filter header of block. The filter header of its parent has to be stored already.
+func putBlockFilter(tx StoreTx, block *Block) error {
+	prevHeader := make([]byte, sha256.Size)
+	if len(block.PrevBlockHash) > 0 {
+		prevHeader = tx.Get([]byte(cfheadersBucket), block.PrevBlockHash)
+		if prevHeader == nil {
+			return ErrNotFound
+		}
+	}
+
+	filter, err := NewBlockFilter(block)
+	if err != nil {
+		return err
+	}
+	filterBytes, err := filter.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Put([]byte(cfiltersBucket), block.Hash, filterBytes); err != nil {
+		return err
+	}
+	return tx.Put([]byte(cfheadersBucket), block.Hash, NextFilterHeader(filterBytes, prevHeader))
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	hash := sha256.Sum256(first[:])
+	return hash[:]
+}
+
+// Bytes encodes the message: bytes block hash
+func (m *MsgGetCFilter) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarBytes(&buff, m.BlockHash); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgGetCFilter decodes a getcfilter message
+func BytesToMsgGetCFilter(data []byte) (*MsgGetCFilter, error) {
+	m := new(MsgGetCFilter)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		m.BlockHash, err = readVarBytes(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Bytes encodes the message: bytes block hash | bytes filter | bytes filter header
+func (m *MsgCFilter) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	for _, field := range [][]byte{m.BlockHash, m.Filter, m.FilterHeader} {
+		if err := writeVarBytes(&buff, field); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgCFilter decodes a cfilter message
+func BytesToMsgCFilter(data []byte) (*MsgCFilter, error) {
+	m := new(MsgCFilter)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		for _, field := range []*[]byte{&m.BlockHash, &m.Filter, &m.FilterHeader} {
+			var err error
+			if *field, err = readVarBytes(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// GCSFilter returns the decoded filter carried by the message
+func (m *MsgCFilter) GCSFilter() (*GCSFilter, error) {
+	return BytesToGCSFilter(BlockFilterKey(m.BlockHash), m.Filter)
+}
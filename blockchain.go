@@ -2,12 +2,12 @@ package hoji
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
-	"errors"
-	"os"
-
-	"github.com/boltdb/bolt"
+	"fmt"
+	"sync"
+	"time"
 )
 
 const (
@@ -16,77 +16,295 @@ const (
 	dbFile       = "hoji.db"
 )
 
-// Blockchain is
+// Blockchain is safe for concurrent use. Reads run in parallel, writes are serialized and a block, its UTXO changes and its indexes become visible at once.
 type Blockchain struct {
-	DB  *bolt.DB
-	tip []byte
+	store Store
+	// mu is held for reading by store views and for writing by updates and cache flushes, it also guards tip
+	mu     sync.RWMutex
+	tip    []byte
+	params *ChainParams
+	// opts are passed on to the wallets used by the blockchain
+	opts []Option
+	// prune is the number of recent blocks whose transactions are kept, 0 keeps them all
+	prune int64
+	// addrIndex is set when the address history index is maintained
+	addrIndex bool
+	// log holds the logger of every subsystem, they discard everything unless WithLogger was given
+	log *loggers
+
+	cache *utxoCache
+	// stop ends the periodic cache flushes, done is closed once they stopped
+	stop, done chan struct{}
 }
 
 // NewBlockchain creates and returns an instance of the Blockchain struct
-func NewBlockchain() (*Blockchain, error) {
-	if !dbExists() {
-		if err := CreateBlockchain([]byte("genesis address")); err != nil {
-			return nil, err
-		}
+func NewBlockchain(opts ...Option) (*Blockchain, error) {
+	o := newOptions(opts)
+	params, err := o.chainParams()
+	if err != nil {
+		return nil, err
+	}
+	if o.prune < 0 || o.prune > 0 && o.prune < MinPruneDepth {
+		return nil, ErrInvalidPruneDepth
 	}
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, owned, err := o.openStore()
 	if err != nil {
 		return nil, err
 	}
+	// a store passed with WithStore stays open for the caller to close
+	fail := func(err error) (*Blockchain, error) {
+		if owned {
+			store.Close()
+		}
+		return nil, err
+	}
+
+	log := o.loggers()
+	migrated, err := migrateSchema(store, params, log.chain)
+	if err != nil {
+		return fail(err)
+	}
 
 	var tip []byte
-	if err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte(lastHashKey))
+	if err := store.View(func(tx StoreTx) error {
+		tip = tx.Tip()
 		return nil
 	}); err != nil {
-		return nil, err
+		return fail(err)
 	}
 
-	return &Blockchain{db, tip}, nil
+	if tip == nil {
+		if tip, err = writeGenesis(store, params); err != nil {
+			return fail(err)
+		}
+	}
+	if err := checkGenesis(store, params); err != nil {
+		return fail(err)
+	}
+	rebuild, err := recoverUTXO(store, log.utxo)
+	if err != nil {
+		return fail(err)
+	}
+	if o.addrIndex {
+		if err := syncAddressHistory(store); err != nil {
+			return fail(err)
+		}
+	}
+
+	bc := &Blockchain{
+		store:     store,
+		tip:       tip,
+		params:    params,
+		opts:      opts,
+		prune:     o.prune,
+		addrIndex: o.addrIndex,
+		log:       log,
+		cache:     newUTXOCache(o.cacheSize),
+	}
+	if migrated || rebuild {
+		if err := CreateUTXOSet(bc); err != nil {
+			return fail(err)
+		}
+	}
+	if bc.prune > 0 {
+		if err := bc.update(func(tx StoreTx) error {
+			return pruneBlocks(tx, bc.prune)
+		}); err != nil {
+			return fail(err)
+		}
+	}
+	if o.flushInterval > 0 {
+		bc.stop, bc.done = make(chan struct{}), make(chan struct{})
+		go bc.flushLoop(o.flushInterval, bc.stop, bc.done)
+	}
+
+	return bc, nil
 }
 
-//CreateBlockchain is
-func CreateBlockchain(address []byte) error {
-	db, err := bolt.Open(dbFile, 0600, nil)
+//CreateBlockchain starts a new blockchain: it writes the network's genesis block and mines the first block on top of it, paying the reward to address. It fails with ErrBlockchainExists if the chain is longer than its genesis block.
+func CreateBlockchain(address []byte, opts ...Option) error {
+	bc, err := NewBlockchain(opts...)
 	if err != nil {
 		return err
 	}
-	transaction, err := NewCoinbaseTx(address, []byte("yolo dolo"))
+	if newOptions(opts).store == nil {
+		defer bc.Close()
+	} else {
+		defer bc.shutdown()
+	}
+
+	if !bytes.Equal(bc.Tip(), bc.params.GenesisHash) {
+		return ErrBlockchainExists
+	}
+	coinbase, err := bc.NewCoinbaseTx(address, nil)
 	if err != nil {
 		return err
 	}
-	gensisBlock := NewGenesisBlock(transaction)
-	tip := gensisBlock.Hash
-	if err := db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucket([]byte(blocksBucket))
-		if err != nil {
+	_, err = bc.MineBlock([]*Transaction{coinbase})
+	return err
+}
+
+// writeGenesis writes the network's genesis block, its filter and the UTXO set to an empty store in a single update
+func writeGenesis(store Store, params *ChainParams) ([]byte, error) {
+	gensisBlock, err := params.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Update(func(tx StoreTx) error {
+		if tx.Tip() != nil {
+			return ErrBlockchainExists
+		}
+		if err := tx.PutBlock(gensisBlock); err != nil {
 			return err
 		}
-		blockBytes, err := gensisBlock.Bytes()
-		if err != nil {
+		if err := putBlockFilter(tx, gensisBlock); err != nil {
+			return err
+		}
+		if err := tx.ResetUTXO(); err != nil {
+			return err
+		}
+		if err := updateUTXO(tx, gensisBlock); err != nil {
 			return err
 		}
 
-		if err := b.Put(gensisBlock.Hash, blockBytes); err != nil {
+		return tx.SetTip(gensisBlock.Hash)
+	}); err != nil {
+		return nil, err
+	}
+
+	return gensisBlock.Hash, nil
+}
+
+// checkGenesis makes sure the store holds a chain of the network, starting with its genesis block, and records the network in the meta bucket of databases that don't have it yet
+func checkGenesis(store Store, params *ChainParams) error {
+	return store.Update(func(tx StoreTx) error {
+		network := tx.Get([]byte(metaBucket), []byte(networkKey))
+		if network != nil && string(network) != params.Name {
+			return fmt.Errorf("%w: it is a %s database", ErrNetworkMismatch, network)
+		}
+
+		genesis, err := tx.Block(params.GenesisHash)
+		if err == ErrNotFound || err == nil && (genesis.Height != 0 || len(genesis.PrevBlockHash) != 0) {
+			return ErrGenesisMismatch
+		}
+		if err != nil || network != nil {
 			return err
 		}
+		return tx.Put([]byte(metaBucket), []byte(networkKey), []byte(params.Name))
+	})
+}
+
+// indexBlocks fills in the height, header and filter of the blocks that don't have them, which are all the blocks of a database created before those indexes existed
+func indexBlocks(tx StoreTx) (rebuildUTXO bool, err error) {
+	var missing []*Block
+	for hash := tx.Tip(); len(hash) > 0; {
+		if tx.Get([]byte(heightsBucket), hash) != nil && tx.Get([]byte(blockHeadersBucket), hash) != nil && tx.Get([]byte(cfheadersBucket), hash) != nil {
+			break
+		}
+		block, err := tx.Block(hash)
+		if err != nil {
+			return false, err
+		}
+		missing = append(missing, block)
+		hash = block.PrevBlockHash
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := tx.PutBlock(missing[i]); err != nil {
+			return false, err
+		}
+		if err := putBlockFilter(tx, missing[i]); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Close writes the UTXO cache and closes the blockchain's store
+func (bc *Blockchain) Close() error {
+	err := bc.shutdown()
+	if closeErr := bc.store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// shutdown stops the periodic cache flushes and writes the cache, it leaves the store open
+func (bc *Blockchain) shutdown() error {
+	if bc.stop != nil {
+		close(bc.stop)
+		<-bc.done
+		bc.stop = nil
+	}
+	return bc.Flush()
+}
 
-		return b.Put([]byte(lastHashKey), gensisBlock.Hash)
+// Tip returns the hash of the last block of the chain
+func (bc *Blockchain) Tip() []byte {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return append([]byte{}, bc.tip...)
+}
+
+// Params returns the parameters of the blockchain's network
+func (bc *Blockchain) Params() *ChainParams {
+	return bc.params
+}
+
+// NewCoinbaseTx creates the coinbase transaction of the next block, paying the network's subsidy at that height to the address to. The height is prepended to data so that coinbase transactions paying the same address get different IDs.
+func (bc *Blockchain) NewCoinbaseTx(to, data []byte) (*Transaction, error) {
+	var height int64
+	if err := bc.view(func(tx StoreTx) error {
+		tip, err := tx.Header(tx.Tip())
+		if err != nil {
+			return err
+		}
+		height = tip.Height + 1
+		return nil
 	}); err != nil {
-		return err
+		return nil, err
 	}
 
-	return CreateUTXOSet(&Blockchain{db, tip})
+	return NewCoinbaseTx(to, append(IntToByte(height), data...), bc.params.Subsidy(height))
 }
 
-//ListUTXO finds all unspent transaction outputs
-func (bc *Blockchain) ListUTXO() (map[string]TxOutputs, error) {
-	utxo := make(map[string]TxOutputs)
+// nextBits returns the difficulty of the block following prev. It only reads headers so it works on pruned blocks.
+func (bc *Blockchain) nextBits(tx StoreTx, prev *BlockHeader) (uint32, error) {
+	return bc.params.nextBits(prev.Bits, prev.Height, prev.Timestamp, func() (int64, error) {
+		header := prev
+		for i := int64(1); i < bc.params.RetargetInterval; i++ {
+			var err error
+			if header, err = tx.Header(header.PrevBlockHash); err != nil {
+				return 0, err
+			}
+		}
+		return header.Timestamp, nil
+	})
+}
+
+//ListUTXO finds all unspent transaction outputs. It needs every block so it fails with ErrBlockPruned on a pruned node.
+func (bc *Blockchain) ListUTXO() (map[string]*TxOutputs, error) {
+	return bc.listUTXO(bc.Tip())
+}
+
+// listUTXO finds the unspent transaction outputs right after the block with hash tip
+func (bc *Blockchain) listUTXO(tip []byte) (map[string]*TxOutputs, error) {
+	utxo := make(map[string]*TxOutputs)
 	spentTxOutputs := make(map[string][]int)
-	bci := bc.Iterator()
+	bci := &BlockchainIterator{
+		currentHash: tip,
+		store:       bc.store,
+	}
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err == ErrNoMoreBlocks {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -101,55 +319,176 @@ func (bc *Blockchain) ListUTXO() (map[string]TxOutputs, error) {
 					}
 				}
 
-				outs := utxo[txID]
-				outs.Outputs = append(outs.Outputs, outTx)
+				outs, ok := utxo[txID]
+				if !ok {
+					outs = NewTxOutputs()
+				}
+				outs.Outputs[outTxIndex] = outTx
 				utxo[txID] = outs
 			}
 
 			if !tx.IsCoinbase() {
 				for _, in := range tx.Inputs {
-					inTxID := hex.EncodeToString(in.TxID)
-					spentTxOutputs[inTxID] = append(spentTxOutputs[inTxID], in.outIndex)
+					inTxID := hex.EncodeToString(in.PrevOut.TxID)
+					spentTxOutputs[inTxID] = append(spentTxOutputs[inTxID], in.PrevOut.Index)
 				}
 			}
 
 		}
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
 	}
 	return utxo, nil
 }
 
 //FindTx is
 func (bc *Blockchain) FindTx(id []byte) (*Transaction, error) {
+	block, index, err := bc.FindTxBlock(id)
+	if err != nil {
+		return nil, err
+	}
+	return block.Transactions[index], nil
+}
+
+//FindTxBlock finds the block that contains the transaction and the transaction's index in it. On a pruned node it fails with ErrBlockPruned once the search reaches the pruned blocks.
+func (bc *Blockchain) FindTxBlock(id []byte) (*Block, int, error) {
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err == ErrNoMoreBlocks {
+			return nil, 0, ErrNotFound
+		}
+		if err != nil {
+			return nil, 0, err
+		}
 
-		for _, tx := range block.Transactions {
+		for i, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, id) == 0 {
-				return tx, nil
+				return block, i, nil
 			}
 		}
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
 	}
-	return nil, ErrNotFound
 }
 
-//SignTx is
-func (bc *Blockchain) SignTx(tx *Transaction, privKey *ecdsa.PrivateKey) error {
-	prevTxs := make(map[string]*Transaction)
+//TxProof builds the proof that the transaction is included in the chain
+func (bc *Blockchain) TxProof(id []byte) (*TxProof, error) {
+	block, index, err := bc.FindTxBlock(id)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, input := range tx.Inputs {
-		prevTx, err := bc.FindTx(input.TxID)
+	mTree, err := block.MerkleTree()
+	if err != nil {
+		return nil, err
+	}
+	proof, err := mTree.Proof(index)
+	if err != nil {
+		return nil, err
+	}
+	header, err := block.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxProof{
+		Header: header,
+		Tx:     block.Transactions[index],
+		Proof:  proof,
+	}, nil
+}
+
+//Headers returns the headers of the blocks following the block with hash after, oldest first. An empty after returns every header. It makes Blockchain a ProofSource for light clients, pruned nodes included since they keep every header.
+func (bc *Blockchain) Headers(after []byte) ([]*BlockHeader, error) {
+	return bc.headers(after, 0)
+}
+
+// headers returns at most max headers following the block with hash after, all of them when max is 0. They are looked up by height so a page doesn't cost a walk from the tip. after has to be on the chain, ErrNotFound otherwise.
+func (bc *Blockchain) headers(after []byte, max int) ([]*BlockHeader, error) {
+	var headers []*BlockHeader
+	if err := bc.view(func(tx StoreTx) error {
+		tip, err := tx.Header(tx.Tip())
 		if err != nil {
 			return err
 		}
-		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+		height := int64(0)
+		if len(after) > 0 {
+			header, err := tx.Header(after)
+			if err != nil {
+				return err
+			}
+			if hash, err := tx.MainChainHash(header.Height); err != nil || !bytes.Equal(hash, after) {
+				return ErrNotFound
+			}
+			height = header.Height + 1
+		}
+
+		for ; height <= tip.Height && (max == 0 || len(headers) < max); height++ {
+			hash, err := tx.MainChainHash(height)
+			if err != nil {
+				return err
+			}
+			header, err := tx.Header(hash)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, header)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return headers, nil
+}
+
+//TxProofs returns inclusion proofs for the transactions paying to pubKeyHash or spending its outputs. It needs every block so it fails with ErrBlockPruned on a pruned node.
+func (bc *Blockchain) TxProofs(pubKeyHash []byte) ([]*TxProof, error) {
+	var proofs []*TxProof
+	bci := bc.Iterator()
+
+	for {
+		block, err := bci.Next()
+		if err == ErrNoMoreBlocks {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var mTree *MerkleTree
+		var header *BlockHeader
+		for i, tx := range block.Transactions {
+			relevant, err := tx.touches(pubKeyHash)
+			if err != nil {
+				return nil, err
+			}
+			if !relevant {
+				continue
+			}
+
+			if mTree == nil {
+				if mTree, err = block.MerkleTree(); err != nil {
+					return nil, err
+				}
+				if header, err = block.Header(); err != nil {
+					return nil, err
+				}
+			}
+			proof, err := mTree.Proof(i)
+			if err != nil {
+				return nil, err
+			}
+			proofs = append(proofs, &TxProof{Header: header, Tx: tx, Proof: proof})
+		}
+	}
+
+	return proofs, nil
+}
+
+//SignTx is
+func (bc *Blockchain) SignTx(tx *Transaction, privKey *ecdsa.PrivateKey) error {
+	prevTxs, err := bc.prevTxs(tx)
+	if err != nil {
+		return err
 	}
 
 	return tx.Sign(privKey, prevTxs)
@@ -161,76 +500,190 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) (bool, error) {
 		return true, nil
 	}
 
-	prevTxs := make(map[string]*Transaction)
-	for _, input := range tx.Inputs {
-		prevTx, err := bc.FindTx(input.TxID)
-		if err != nil {
-			return false, err
-		}
-		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+	prevTxs, err := bc.prevTxs(tx)
+	if err != nil {
+		return false, err
 	}
 
 	return tx.Verify(prevTxs)
 }
 
-//MineBlock adds a new block to the blockchain
-func (bc *Blockchain) MineBlock(txs []*Transaction) (*Block, error) {
-	for _, tx := range txs {
-		ok, err := bc.VerifyTransaction(tx)
+// checkTransaction checks that a transaction going into a block is signed by the owners of the outputs it spends and doesn't create value
+func (bc *Blockchain) checkTransaction(t *Transaction) error {
+	prevTxs := make(map[string]*Transaction)
+	if !t.IsCoinbase() {
+		var err error
+		if prevTxs, err = bc.prevTxs(t); err != nil {
+			return err
+		}
+		ok, err := t.Verify(prevTxs)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
 		if !ok {
-			return nil, errors.New("invalid transaction")
+			return ErrInvalidSignature
 		}
 	}
+	return checkTxValues(t, prevTxs)
+}
 
-	var lastHash []byte
-	if err := bc.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte(lastHashKey))
-		return nil
+// prevTxs finds the transactions whose outputs tx spends. When a transaction is in a pruned block it is rebuilt from its outputs in the UTXO set, which are the only ones that can still be spent.
+func (bc *Blockchain) prevTxs(tx *Transaction) (map[string]*Transaction, error) {
+	prevTxs := make(map[string]*Transaction)
+	for _, input := range tx.Inputs {
+		prevTx, err := bc.FindTx(input.PrevOut.TxID)
+		if err == ErrBlockPruned {
+			prevTx, err = bc.utxoTx(input.PrevOut.TxID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+	}
+	return prevTxs, nil
+}
+
+// utxoTx returns a transaction holding only the unspent outputs of the transaction id, spent outputs are left nil
+func (bc *Blockchain) utxoTx(id []byte) (*Transaction, error) {
+	var outs *TxOutputs
+	if err := bc.view(func(tx StoreTx) error {
+		var err error
+		outs, err = tx.UTXO(id)
+		return err
 	}); err != nil {
 		return nil, err
 	}
 
-	newBlock := NewBlock(txs, lastHash)
-	if err := bc.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+	t := &Transaction{ID: id}
+	for index, out := range outs.Outputs {
+		for len(t.Outputs) <= index {
+			t.Outputs = append(t.Outputs, nil)
+		}
+		t.Outputs[index] = out
+	}
+	return t, nil
+}
 
-		blockBytes, err := newBlock.Bytes()
+//MineBlock mines a new block on top of the tip and adds it to the blockchain, its filter is committed with it and its UTXO set changes go to the UTXO cache. It fails with ErrSpentOutput before mining when txs spend an output that is already spent or spend one twice.
+func (bc *Blockchain) MineBlock(txs []*Transaction) (*Block, error) {
+	for _, tx := range txs {
+		if err := bc.checkTransaction(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	var lastHash []byte
+	var bits uint32
+	var height int64
+	if err := bc.view(func(tx StoreTx) error {
+		lastHash = tx.Tip()
+		prev, err := tx.Header(lastHash)
 		if err != nil {
 			return err
 		}
-		if err := b.Put(newBlock.Hash, blockBytes); err != nil {
+		height = prev.Height + 1
+		if err := bc.checkCoinbase(txs, height); err != nil {
 			return err
 		}
-
-		if err := b.Put([]byte(lastHashKey), newBlock.Hash); err != nil {
+		// the tip is checked again before the block is connected, the outputs are unspent as long as it hasn't moved
+		if err := checkUnspent(tx, txs); err != nil {
 			return err
 		}
-		bc.tip = newBlock.Hash
-		return nil
+		bits, err = bc.nextBits(tx, prev)
+		return err
 	}); err != nil {
 		return nil, err
 	}
 
+	bc.log.pow.Debug("mining block", "height", height, "bits", bits, "txs", len(txs))
+	start := time.Now()
+	newBlock := NewBlock(txs, lastHash, bits)
+	bc.log.pow.Info("block mined", "height", height, hashAttr("hash", newBlock.Hash), "nonce", newBlock.Nonce, "elapsed", time.Since(start))
+
+	if err := bc.update(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Tip(), lastHash) {
+			return ErrStaleTip
+		}
+		return bc.connectBlock(tx, newBlock)
+	}); err != nil {
+		return nil, err
+	}
+	bc.log.chain.Info("block connected", "height", height, hashAttr("hash", newBlock.Hash), "txs", len(txs))
 	return newBlock, nil
 }
 
-//Iterator returns a new iterator to loop over the blocks in the blockchain
-func (bc *Blockchain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{
-		currentHash: bc.tip,
-		db:          bc.DB,
+// connectBlock stores a validated block on top of the tip with its filter and undo record, and applies it to the UTXO set and the address history index
+func (bc *Blockchain) connectBlock(tx StoreTx, block *Block) error {
+	if err := tx.PutBlock(block); err != nil {
+		return err
+	}
+	if err := putBlockFilter(tx, block); err != nil {
+		return err
+	}
+	if err := putBlockUndo(tx, block); err != nil {
+		return err
+	}
+	if err := updateUTXO(tx, block); err != nil {
+		return err
+	}
+	if bc.addrIndex {
+		if err := connectHistory(tx, block); err != nil {
+			return err
+		}
 	}
+	return tx.SetTip(block.Hash)
 }
 
-func dbExists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		return false
+// checkUnspent makes sure the outputs spent by txs are in the UTXO set and spent only once
+func checkUnspent(tx StoreTx, txs []*Transaction) error {
+	spent := make(map[string]bool)
+	for _, t := range txs {
+		if t.IsCoinbase() {
+			continue
+		}
+		for _, input := range t.Inputs {
+			outPoint := input.PrevOut.String()
+			if spent[outPoint] {
+				return ErrSpentOutput
+			}
+			spent[outPoint] = true
+
+			outs, err := tx.UTXO(input.PrevOut.TxID)
+			if err == ErrNotFound {
+				return ErrSpentOutput
+			}
+			if err != nil {
+				return err
+			}
+			if _, ok := outs.Outputs[input.PrevOut.Index]; !ok {
+				return ErrSpentOutput
+			}
+		}
 	}
+	return nil
+}
 
-	return true
+// checkCoinbase makes sure the block at height has at most one coinbase transaction paying no more than the subsidy
+func (bc *Blockchain) checkCoinbase(txs []*Transaction, height int64) error {
+	coinbases := 0
+	for _, tx := range txs {
+		if !tx.IsCoinbase() {
+			continue
+		}
+		coinbases++
+
+		value := 0
+		for _, out := range tx.Outputs {
+			value += out.Value
+		}
+		if coinbases > 1 || value > bc.params.Subsidy(height) {
+			return ErrInvalidCoinbase
+		}
+	}
+	return nil
+}
+
+//Iterator returns a new iterator to loop over the blocks in the blockchain, from the tip back to the genesis block
+func (bc *Blockchain) Iterator() *BlockchainIterator {
+	return bc.IteratorContext(context.Background())
 }
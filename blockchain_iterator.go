@@ -1,32 +1,157 @@
 package hoji
 
 import (
-	"log"
-
-	"github.com/boltdb/bolt"
+	"bytes"
+	"context"
 )
 
-// BlockchainIterator helps us loop over all of the blocks in the blockchain
+// BlockchainIterator helps us loop over all of the blocks in the blockchain, from the tip back to the genesis block or forward over a range of heights. Next returns ErrNoMoreBlocks once every block was returned. The iterator walks the chain as it was when it was created, blocks added afterwards are not returned.
 type BlockchainIterator struct {
+	ctx   context.Context
+	store Store
+	// currentHash is the next block of a backward iterator, it is empty past the genesis block
 	currentHash []byte
-	db          *bolt.DB
+
+	// a forward iterator looks up the hashes of its range on the first call to Next
+	forward  bool
+	tip      []byte
+	from, to int64
+	hashes   [][]byte
+	resolved bool
 }
 
-//Next is
-func (i *BlockchainIterator) Next() *Block {
-	block := new(Block)
-	if err := i.db.View(func(tx *bolt.Tx) error {
-		bu := tx.Bucket([]byte(blocksBucket))
-		nextBlock := bu.Get(i.currentHash)
-		b, err := BytesToBlock(nextBlock)
+// IteratorContext returns an iterator going from the tip back to the genesis block. Next fails with the context's error once ctx is done.
+func (bc *Blockchain) IteratorContext(ctx context.Context) *BlockchainIterator {
+	return &BlockchainIterator{
+		ctx:         ctx,
+		currentHash: bc.Tip(),
+		store:       bc.store,
+	}
+}
+
+// RangeIterator returns an iterator going forward over the blocks from height from to height to, both included. A negative to, or one past the tip, stops at the tip. Next fails with the context's error once ctx is done, and with ErrBlockPruned on a block that was pruned.
+func (bc *Blockchain) RangeIterator(ctx context.Context, from, to int64) *BlockchainIterator {
+	return &BlockchainIterator{
+		ctx:     ctx,
+		store:   bc.store,
+		forward: true,
+		tip:     bc.Tip(),
+		from:    from,
+		to:      to,
+	}
+}
+
+// Next returns the next block, or ErrNoMoreBlocks when there are none left. Any other error, such as ErrBlockPruned, ends the iteration as well.
+func (i *BlockchainIterator) Next() (*Block, error) {
+	if err := i.err(); err != nil {
+		return nil, err
+	}
+
+	if i.forward {
+		return i.nextForward()
+	}
+	if len(i.currentHash) == 0 {
+		return nil, ErrNoMoreBlocks
+	}
+
+	var block *Block
+	if err := i.store.View(func(tx StoreTx) error {
+		var err error
+		block, err = tx.Block(i.currentHash)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	i.currentHash = block.PrevBlockHash
+	return block, nil
+}
+
+// nextForward returns the next block of a forward iterator
+func (i *BlockchainIterator) nextForward() (*Block, error) {
+	if !i.resolved {
+		if err := i.resolve(); err != nil {
+			return nil, err
+		}
+		i.resolved = true
+	}
+	if len(i.hashes) == 0 {
+		return nil, ErrNoMoreBlocks
+	}
+
+	var block *Block
+	if err := i.store.View(func(tx StoreTx) error {
+		var err error
+		block, err = tx.Block(i.hashes[0])
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	i.hashes = i.hashes[1:]
+	return block, nil
+}
+
+// err returns the error of the iterator's context once it is done, an iterator without a context never stops early
+func (i *BlockchainIterator) err() error {
+	if i.ctx == nil {
+		return nil
+	}
+	return i.ctx.Err()
+}
+
+// resolve looks up the hashes of the blocks in the range, oldest first, in the height index of the chain. When the chain was rewound since the iterator was created the index no longer leads to its tip, the headers are walked back from the tip instead; they are kept for every block so it works on pruned nodes.
+func (i *BlockchainIterator) resolve() error {
+	if i.from < 0 || i.to >= 0 && i.to < i.from {
+		return ErrBadRequest
+	}
+
+	return i.store.View(func(tx StoreTx) error {
+		tip, err := tx.Header(i.tip)
 		if err != nil {
 			return err
 		}
-		block = b
+		to := i.to
+		if to < 0 || to > tip.Height {
+			to = tip.Height
+		}
+		if hash, err := tx.MainChainHash(tip.Height); err != nil || !bytes.Equal(hash, i.tip) {
+			return i.walkHeaders(tx, to)
+		}
+
+		for height := i.from; height <= to; height++ {
+			if err := i.err(); err != nil {
+				return err
+			}
+			hash, err := tx.MainChainHash(height)
+			if err != nil {
+				return err
+			}
+			i.hashes = append(i.hashes, hash)
+		}
 		return nil
-	}); err != nil {
-		log.Panic(err)
+	})
+}
+
+// walkHeaders finds the hashes of the blocks from i.from to to by walking the headers back from the tip
+func (i *BlockchainIterator) walkHeaders(tx StoreTx, to int64) error {
+	for hash := i.tip; len(hash) > 0; {
+		if err := i.err(); err != nil {
+			return err
+		}
+		header, err := tx.Header(hash)
+		if err != nil {
+			return err
+		}
+		if header.Height < i.from {
+			break
+		}
+		if header.Height <= to {
+			i.hashes = append(i.hashes, header.Hash)
+		}
+		hash = header.PrevBlockHash
 	}
-	i.currentHash = block.PrevBlockHash
-	return block
+
+	for l, r := 0, len(i.hashes)-1; l < r; l, r = l+1, r-1 {
+		i.hashes[l], i.hashes[r] = i.hashes[r], i.hashes[l]
+	}
+	return nil
 }
@@ -0,0 +1,88 @@
+package hoji
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// rangeHeights returns the heights of the blocks returned by it
+func rangeHeights(t *testing.T, it *BlockchainIterator) []int64 {
+	t.Helper()
+
+	var heights []int64
+	for {
+		block, err := it.Next()
+		if err == ErrNoMoreBlocks {
+			return heights
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		heights = append(heights, block.Height)
+	}
+}
+
+func TestRangeIterator(t *testing.T) {
+	c := newTestChain(t, nil)
+	var blocks []*Block
+	for i := 0; i < 4; i++ {
+		blocks = append(blocks, c.mine(t))
+	}
+
+	for _, test := range []struct {
+		name     string
+		ctx      context.Context
+		from, to int64
+		want     []int64
+	}{
+		{"range", context.Background(), 2, 4, []int64{2, 3, 4}},
+		{"to the tip", context.Background(), 3, -1, []int64{3, 4, 5}},
+		{"past the tip", context.Background(), 4, 10, []int64{4, 5}},
+		{"above the tip", context.Background(), 7, -1, nil},
+		{"without context", nil, 0, 1, []int64{0, 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rangeHeights(t, c.RangeIterator(test.ctx, test.from, test.to)); !slices.Equal(got, test.want) {
+				t.Errorf("got heights %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.RangeIterator(ctx, 0, -1).Next(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v from a cancelled iterator", err)
+	}
+	if _, err := c.RangeIterator(nil, 3, 2).Next(); err != ErrBadRequest {
+		t.Errorf("got %v from an empty range", err)
+	}
+
+	// an iterator keeps the chain it was created on after the tip is rewound, the height index follows the new chain
+	before := c.RangeIterator(nil, 3, -1)
+	if err := c.InvalidateBlock(blocks[1].Hash); err != nil {
+		t.Fatal(err)
+	}
+	replacement := c.mine(t)
+	if got := rangeHeights(t, before); !slices.Equal(got, []int64{3, 4, 5}) {
+		t.Errorf("iterator created before the chain was rewound got heights %v", got)
+	}
+	if err := c.view(func(tx StoreTx) error {
+		if hash, err := tx.MainChainHash(3); err != nil || !bytes.Equal(hash, replacement.Hash) {
+			t.Errorf("height 3 is indexed as %x (%v), want the replacement block", hash, err)
+		}
+		for _, height := range []int64{4, 5} {
+			if _, err := tx.MainChainHash(height); err != ErrNotFound {
+				t.Errorf("height %d past the tip is still indexed: %v", height, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rangeHeights(t, c.RangeIterator(nil, 2, -1)); !slices.Equal(got, []int64{2, 3}) {
+		t.Errorf("got heights %v after the chain was rewound", got)
+	}
+}
@@ -0,0 +1,177 @@
+package hoji
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentReadsWhileMining reads the chain while blocks are mined and the UTXO cache is flushed, it is meant to run with -race
+func TestConcurrentReadsWhileMining(t *testing.T) {
+	// a cache too small for a single block is written after every block
+	c := newTestChain(t, nil, WithUTXOCache(1, 0))
+	const blocks = 30
+	subsidy := c.params.Subsidy(0)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < blocks; i++ {
+			coinbase, err := c.NewCoinbaseTx(c.miner, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.MineBlock([]*Transaction{coinbase}); err != nil {
+				t.Error(err)
+				return
+			}
+			if i%5 == 0 {
+				if err := c.Flush(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	readers := map[string]func() error{
+		"FindUTXO": func() error {
+			// the miner only gains block rewards, in whole subsidies
+			last := 0
+			return untilDone(done, func() error {
+				outs, err := (UTXOSet{Bc: c.Blockchain}).FindUTXO(c.miner)
+				if err != nil {
+					return err
+				}
+				total := 0
+				for _, out := range outs {
+					total += out.Value
+				}
+				if total < last || total%subsidy != 0 {
+					t.Errorf("miner balance %d after %d", total, last)
+				}
+				last = total
+				return nil
+			})
+		},
+		"Headers": func() error {
+			return untilDone(done, func() error {
+				headers, err := c.Headers(nil)
+				if err != nil {
+					return err
+				}
+				for i, header := range headers {
+					if header.Height != int64(i) || i > 0 && !bytes.Equal(header.PrevBlockHash, headers[i-1].Hash) {
+						t.Errorf("header %d at height %d doesn't follow the previous one", i, header.Height)
+					}
+				}
+				return nil
+			})
+		},
+		"Iterator": func() error {
+			return untilDone(done, func() error {
+				bci := c.Iterator()
+				height := int64(-1)
+				for {
+					block, err := bci.Next()
+					if err == ErrNoMoreBlocks {
+						break
+					}
+					if err != nil {
+						return err
+					}
+					if height >= 0 && block.Height != height-1 {
+						t.Errorf("block at height %d after height %d", block.Height, height)
+					}
+					height = block.Height
+				}
+				if height != 0 {
+					t.Errorf("iteration stopped at height %d", height)
+				}
+				return nil
+			})
+		},
+		"RangeIterator": func() error {
+			return untilDone(done, func() error {
+				bci := c.RangeIterator(context.Background(), 0, -1)
+				for height := int64(0); ; height++ {
+					block, err := bci.Next()
+					if err == ErrNoMoreBlocks {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+					if block.Height != height {
+						t.Errorf("block at height %d, want %d", block.Height, height)
+					}
+				}
+			})
+		},
+	}
+	for name, read := range readers {
+		wg.Add(1)
+		go func(name string, read func() error) {
+			defer wg.Done()
+			if err := read(); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}(name, read)
+	}
+	wg.Wait()
+
+	if _, err := c.VerifyChain(VerifyUTXO, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// untilDone calls read until done is closed, and once more afterwards
+func untilDone(done <-chan struct{}, read func() error) error {
+	for {
+		select {
+		case <-done:
+			return read()
+		default:
+		}
+		if err := read(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestMineBlockDoubleSpend(t *testing.T) {
+	c := newTestChain(t, nil)
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	first := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(reward.Value, payee))
+	second := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(reward.Value, c.newAddress(t)))
+
+	// both spends in one block
+	tip := c.Tip()
+	if _, err := c.MineBlock([]*Transaction{first, second}); !errors.Is(err, ErrSpentOutput) {
+		t.Fatalf("got %v, want ErrSpentOutput", err)
+	}
+	if !bytes.Equal(c.Tip(), tip) {
+		t.Fatal("a block spending an output twice was added")
+	}
+	c.mine(t, first)
+
+	// the output was spent by a block whose UTXO changes are still in the cache
+	tip = c.Tip()
+	coinbase, err := c.NewCoinbaseTx(c.miner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.MineBlock([]*Transaction{second, coinbase}); !errors.Is(err, ErrSpentOutput) {
+		t.Fatalf("got %v, want ErrSpentOutput", err)
+	}
+	if !bytes.Equal(c.Tip(), tip) {
+		t.Fatal("a block spending a spent output was added")
+	}
+}
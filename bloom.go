@@ -0,0 +1,237 @@
+package hoji
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// maxBloomFilterSize and maxBloomHashFuncs bound the filters a peer can ask a node to apply
+	maxBloomFilterSize = 36000
+	maxBloomHashFuncs  = 50
+
+	// bloomSeedStep spreads the seeds of the filter's hash functions
+	bloomSeedStep = 0xfba4c795
+)
+
+// BloomUpdateType tells a node whether to add the outpoints of matched outputs to the filter, so the transactions spending them match as well
+type BloomUpdateType uint8
+
+const (
+	// BloomUpdateNone never changes the filter while matching
+	BloomUpdateNone BloomUpdateType = 0
+	// BloomUpdateAll adds the outpoint of every matched output to the filter
+	BloomUpdateAll BloomUpdateType = 1
+)
+
+// BloomFilter is a probabilistic set used by light clients to tell full nodes which transactions they are interested in without revealing their exact addresses. False positives are expected and give the client some privacy.
+type BloomFilter struct {
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+	Flags     BloomUpdateType
+}
+
+// FilteredBlock is a block stripped down to the transactions matching a bloom filter, each with the merkle proof tying it to the header
+type FilteredBlock struct {
+	Header *BlockHeader
+	Txs    []*Transaction
+	Proofs []*MerkleProof
+}
+
+// NewBloomFilter creates a filter sized for elements entries with the given false positive rate. tweak randomizes the hash functions.
+func NewBloomFilter(elements int, fpRate float64, tweak uint32, flags BloomUpdateType) *BloomFilter {
+	if elements < 1 {
+		elements = 1
+	}
+	fpRate = math.Min(math.Max(fpRate, 1e-9), 1)
+
+	size := int(-1 / (math.Ln2 * math.Ln2) * float64(elements) * math.Log(fpRate) / 8)
+	size = min(max(size, 1), maxBloomFilterSize)
+
+	hashFuncs := int(float64(size*8) / float64(elements) * math.Ln2)
+	hashFuncs = min(max(hashFuncs, 1), maxBloomHashFuncs)
+
+	return &BloomFilter{
+		Filter:    make([]byte, size),
+		HashFuncs: uint32(hashFuncs),
+		Tweak:     tweak,
+		Flags:     flags,
+	}
+}
+
+// Add inserts data in the filter
+func (f *BloomFilter) Add(data []byte) {
+	if len(f.Filter) == 0 {
+		return
+	}
+	for i := uint32(0); i < f.HashFuncs; i++ {
+		bit := f.bit(i, data)
+		f.Filter[bit>>3] |= 1 << (bit & 7)
+	}
+}
+
+// AddOutPoint inserts an outpoint in the filter
+func (f *BloomFilter) AddOutPoint(o OutPoint) error {
+	data, err := o.Bytes()
+	if err != nil {
+		return err
+	}
+	f.Add(data)
+	return nil
+}
+
+// Matches reports whether data may be in the filter
+func (f *BloomFilter) Matches(data []byte) bool {
+	if len(f.Filter) == 0 {
+		return false
+	}
+	for i := uint32(0); i < f.HashFuncs; i++ {
+		bit := f.bit(i, data)
+		if f.Filter[bit>>3]&(1<<(bit&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesOutPoint reports whether the outpoint may be in the filter
+func (f *BloomFilter) MatchesOutPoint(o OutPoint) (bool, error) {
+	data, err := o.Bytes()
+	if err != nil {
+		return false, err
+	}
+	return f.Matches(data), nil
+}
+
+// MatchTx reports whether the transaction is relevant to the filter: its ID, one of its outputs' PubKeyHash, or one of its inputs' outpoint or public key matches. With BloomUpdateAll the outpoints of matched outputs are added to the filter.
+func (f *BloomFilter) MatchTx(tx *Transaction) (bool, error) {
+	matched := f.Matches(tx.ID)
+
+	for i, out := range tx.Outputs {
+		if !f.Matches(out.PubKeyHash) {
+			continue
+		}
+		matched = true
+		if f.Flags == BloomUpdateAll {
+			if err := f.AddOutPoint(NewOutPoint(tx.ID, i)); err != nil {
+				return false, err
+			}
+		}
+	}
+	if matched || tx.IsCoinbase() {
+		return matched, nil
+	}
+
+	for _, in := range tx.Inputs {
+		ok, err := f.MatchesOutPoint(in.PrevOut)
+		if err != nil {
+			return false, err
+		}
+		if ok || f.Matches(in.PubKey) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// MatchBlock scans the block's transactions and returns the ones matching the filter with their merkle proofs
+func (f *BloomFilter) MatchBlock(b *Block) (*FilteredBlock, error) {
+	header, err := b.Header()
+	if err != nil {
+		return nil, err
+	}
+	filtered := &FilteredBlock{Header: header}
+
+	var mTree *MerkleTree
+	for i, tx := range b.Transactions {
+		ok, err := f.MatchTx(tx)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if mTree == nil {
+			if mTree, err = b.MerkleTree(); err != nil {
+				return nil, err
+			}
+		}
+		proof, err := mTree.Proof(i)
+		if err != nil {
+			return nil, err
+		}
+		filtered.Txs = append(filtered.Txs, tx)
+		filtered.Proofs = append(filtered.Proofs, proof)
+	}
+
+	return filtered, nil
+}
+
+// Verify checks the header's proof of work and that every transaction is a leaf of the header's merkle root
+func (fb *FilteredBlock) Verify() (bool, error) {
+	if len(fb.Proofs) != len(fb.Txs) || !ValidateHeader(fb.Header) {
+		return false, nil
+	}
+	for i, tx := range fb.Txs {
+		ok, err := (&TxProof{Header: fb.Header, Tx: tx, Proof: fb.Proofs[i]}).Verify()
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// bit returns the filter bit selected by hash function i for data
+func (f *BloomFilter) bit(i uint32, data []byte) uint32 {
+	return murmur3(i*bloomSeedStep+f.Tweak, data) % uint32(len(f.Filter)*8)
+}
+
+// murmur3 is the 32 bit MurmurHash3 (x86) used by bloom filters
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	blocks := len(data) / 4
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+
+		h ^= k
+		h = h<<13 | h>>19
+		h = h*5 + 0xe6546b64
+	}
+
+	tail := data[blocks*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return h
+}
@@ -0,0 +1,96 @@
+package hoji
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestMurmur3(t *testing.T) {
+	// MurmurHash3_x86_32 reference values
+	tests := []struct {
+		seed uint32
+		data string
+		want uint32
+	}{
+		{0x00000000, "", 0x00000000},
+		{0xfba4c795, "", 0x6a396f08},
+		{0xffffffff, "", 0x81f16f39},
+		{0x00000000, "00", 0x514e28b7},
+		{0xfba4c795, "00", 0xea3f0b17},
+		{0x00000000, "ff", 0xfd6cf10d},
+		{0x00000000, "0011", 0x16c6b7ab},
+		{0x00000000, "001122", 0x8eb51c3d},
+		{0x00000000, "00112233", 0xb4471bf8},
+		{0x00000000, "0011223344", 0xe2301fa8},
+		{0x00000000, "001122334455", 0xfc2e4a15},
+		{0x00000000, "00112233445566", 0xb074502c},
+		{0x00000000, "0011223344556677", 0x8034d2a0},
+		{0x00000000, "001122334455667788", 0xb4698def},
+		{0x00000000, "21436587", 0xf55b516b},
+		{0x5082edee, "21436587", 0x2362f9de},
+	}
+	for _, test := range tests {
+		data, err := hex.DecodeString(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := murmur3(test.seed, data); got != test.want {
+			t.Errorf("murmur3(%#x, %s) = %#x, want %#x", test.seed, test.data, got, test.want)
+		}
+	}
+}
+
+func TestBloomFilterSerialization(t *testing.T) {
+	// the elements and encodings of BIP37's reference filters
+	elements := []string{
+		"99108ad8ed9bb6274d3980bab5a85c048f0950c8",
+		"b5a2c786d9ef4658287ced5914b37a1b4aa32eee",
+		"b9300670b4c5366e95b2699e8b18bc75e5f729c5",
+	}
+	tests := []struct {
+		tweak uint32
+		want  string
+	}{
+		{0, "03614e9b050000000000000001"},
+		{2147483649, "03ce4299050000000100008001"},
+	}
+	for _, test := range tests {
+		f := NewBloomFilter(3, 0.01, test.tweak, BloomUpdateAll)
+		for _, e := range elements {
+			data, _ := hex.DecodeString(e)
+			f.Add(data)
+			if !f.Matches(data) {
+				t.Fatalf("tweak %d: %s doesn't match after being added", test.tweak, e)
+			}
+		}
+
+		got, err := (&MsgFilterLoad{Filter: f}).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != test.want {
+			t.Errorf("tweak %d: filterload is %x, want %s", test.tweak, got, test.want)
+		}
+
+		decoded, err := BytesToMsgFilterLoad(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(decoded.Filter.Filter) != hex.EncodeToString(f.Filter) || decoded.Filter.HashFuncs != f.HashFuncs || decoded.Filter.Tweak != f.Tweak || decoded.Filter.Flags != f.Flags {
+			t.Errorf("tweak %d: filterload doesn't round trip", test.tweak)
+		}
+	}
+}
+
+func TestBloomMessagesRefuseIncompleteFields(t *testing.T) {
+	if _, err := (&MsgFilterLoad{}).Bytes(); err != ErrInvalidFilter {
+		t.Errorf("filterload without a filter: got %v, want ErrInvalidFilter", err)
+	}
+	if _, err := (&MsgMerkleBlock{}).Bytes(); err != ErrMalformedEncoding {
+		t.Errorf("merkleblock without a block: got %v, want ErrMalformedEncoding", err)
+	}
+	missingProof := &FilteredBlock{Header: &BlockHeader{Version: blockVersion}, Txs: []*Transaction{{Version: txVersion}}}
+	if _, err := (&MsgMerkleBlock{Block: missingProof}).Bytes(); err != ErrMalformedEncoding {
+		t.Errorf("merkleblock with fewer proofs than transactions: got %v, want ErrMalformedEncoding", err)
+	}
+}
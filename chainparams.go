@@ -0,0 +1,252 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"time"
+)
+
+// legacyTargetBits is the fixed difficulty of blocks written before it was part of the block encoding
+const legacyTargetBits = 24
+
+// maxTargetBits keeps targets above zero, a block hash can't be below 1
+const maxTargetBits = 255
+
+// ChainParams defines a network: its genesis block, address and key encodings, proof of work rules, subsidy schedule and the ports and magic bytes its nodes use
+type ChainParams struct {
+	Name string
+
+	// Magic prefixes every peer message so nodes of different networks can't talk to each other
+	Magic       [4]byte
+	DefaultPort int
+	RPCPort     int
+
+	// AddressVersion and WIFVersion are the base58check version bytes of addresses and private keys
+	AddressVersion byte
+	WIFVersion     byte
+
+	// The genesis block is fixed so every node of the network agrees on it: its coinbase pays the subsidy to GenesisAddress with GenesisMessage as data, and GenesisTimestamp and GenesisNonce give it the hash GenesisHash
+	GenesisAddress   string
+	GenesisMessage   []byte
+	GenesisTimestamp int64
+	GenesisNonce     int
+	GenesisHash      []byte
+
+	// Difficulty is the number of leading zero bits a block hash needs. GenesisBits is the difficulty of the genesis block and PowLimitBits the lowest difficulty retargeting can go down to.
+	GenesisBits  uint32
+	PowLimitBits uint32
+
+	// Every RetargetInterval blocks the difficulty is adjusted so blocks come every TargetSpacing, by a factor of at most MaxRetargetFactor. It moves in whole bits, see nextBits. NoRetargeting keeps the genesis difficulty forever.
+	TargetSpacing     time.Duration
+	RetargetInterval  int64
+	MaxRetargetFactor int64
+	NoRetargeting     bool
+
+	// BaseSubsidy is the coinbase reward of the first blocks, halved every SubsidyHalvingInterval blocks
+	BaseSubsidy            int
+	SubsidyHalvingInterval int64
+
+	// AssumeUTXO lists the UTXO set snapshots a new node can be bootstrapped from with LoadUTXOSnapshot. The predefined networks only commit to the UTXO set of their genesis block, which a node already has; commitments to later blocks are added as their chains grow. Until then WithAssumeUTXO, or assumeutxo in the config file, adds the commitment printed by DumpUTXOSet on a trusted node.
+	AssumeUTXO []UTXOCommitment
+}
+
+// MainNetParams are the parameters of the main network
+var MainNetParams = ChainParams{
+	Name:        "mainnet",
+	Magic:       [4]byte{0x68, 0x6f, 0x6a, 0x69},
+	DefaultPort: 9333,
+	RPCPort:     9332,
+
+	AddressVersion: 0x00,
+	WIFVersion:     0x80,
+
+	GenesisAddress:   "1BdW7j8spC1iB74S5ffKtcwYGegfS2xRua",
+	GenesisMessage:   []byte("yolo dolo"),
+	GenesisTimestamp: 1515110400,
+	GenesisNonce:     21588765,
+	GenesisHash:      mustDecodeHex("0000008643919770fb7cd4c0cd31d68cb05c5b947e51d4b150e136335e39422f"),
+
+	GenesisBits:  legacyTargetBits,
+	PowLimitBits: 20,
+
+	TargetSpacing:     10 * time.Minute,
+	RetargetInterval:  2016,
+	MaxRetargetFactor: 4,
+
+	BaseSubsidy:            10,
+	SubsidyHalvingInterval: 210000,
+
+	AssumeUTXO: []UTXOCommitment{
+		{
+			Height:    0,
+			BlockHash: mustDecodeHex("0000008643919770fb7cd4c0cd31d68cb05c5b947e51d4b150e136335e39422f"),
+			UTXOHash:  mustDecodeHex("40b9dfddb7a7f2d2edac5292808dec3a7b782ff6808dfffe3a5c7a6f0511265b"),
+		},
+	},
+}
+
+// TestNetParams are the parameters of the public test network, it is easier to mine than mainnet
+var TestNetParams = ChainParams{
+	Name:        "testnet",
+	Magic:       [4]byte{0x68, 0x6f, 0x6a, 0x74},
+	DefaultPort: 19333,
+	RPCPort:     19332,
+
+	AddressVersion: 0x6f,
+	WIFVersion:     0xef,
+
+	GenesisAddress:   "mr9TQnDrdDSxxDY3oEdhiY9s8eHNJJGyzH",
+	GenesisMessage:   []byte("yolo dolo testnet"),
+	GenesisTimestamp: 1515110400,
+	GenesisNonce:     50563,
+	GenesisHash:      mustDecodeHex("00000493a9d05eb1ee1bd266ab7b15566c367c10bc6275156b3008c9068df1a6"),
+
+	GenesisBits:  20,
+	PowLimitBits: 16,
+
+	TargetSpacing:     10 * time.Minute,
+	RetargetInterval:  2016,
+	MaxRetargetFactor: 4,
+
+	BaseSubsidy:            10,
+	SubsidyHalvingInterval: 210000,
+
+	AssumeUTXO: []UTXOCommitment{
+		{
+			Height:    0,
+			BlockHash: mustDecodeHex("00000493a9d05eb1ee1bd266ab7b15566c367c10bc6275156b3008c9068df1a6"),
+			UTXOHash:  mustDecodeHex("638896abea75f49c411cc318a6e7962baf40e7597e365b6a02d29e2abf7a3d7c"),
+		},
+	},
+}
+
+// RegTestParams are the parameters of a local regression test network: blocks are mined instantly and the subsidy halves quickly
+var RegTestParams = ChainParams{
+	Name:        "regtest",
+	Magic:       [4]byte{0x68, 0x6f, 0x6a, 0x72},
+	DefaultPort: 19444,
+	RPCPort:     19443,
+
+	AddressVersion: 0x6f,
+	WIFVersion:     0xef,
+
+	GenesisAddress:   "mr9TQnDrdDSxxDY3oEdhiY9s8eHNJJGyzH",
+	GenesisMessage:   []byte("yolo dolo regtest"),
+	GenesisTimestamp: 1515110400,
+	GenesisNonce:     0,
+	GenesisHash:      mustDecodeHex("390e081ffe7e4ce92a3f4c4a6246c79182c46edbcb32f687523e48b00142311b"),
+
+	GenesisBits:  1,
+	PowLimitBits: 1,
+
+	TargetSpacing:     10 * time.Minute,
+	RetargetInterval:  2016,
+	MaxRetargetFactor: 4,
+	NoRetargeting:     true,
+
+	BaseSubsidy:            10,
+	SubsidyHalvingInterval: 150,
+
+	AssumeUTXO: []UTXOCommitment{
+		{
+			Height:    0,
+			BlockHash: mustDecodeHex("390e081ffe7e4ce92a3f4c4a6246c79182c46edbcb32f687523e48b00142311b"),
+			UTXOHash:  mustDecodeHex("84326f1555b9f37a32ca7a020d66b0df0dd5730120527a19e64e804316311879"),
+		},
+	},
+}
+
+var networks = []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams}
+
+// GenesisBlock builds the network's genesis block and checks it against GenesisHash
+func (p *ChainParams) GenesisBlock() (*Block, error) {
+	coinbase, err := NewCoinbaseTx([]byte(p.GenesisAddress), p.GenesisMessage, p.Subsidy(0))
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := &Block{
+		Version:       genesisVersion,
+		Timestamp:     p.GenesisTimestamp,
+		Transactions:  []*Transaction{coinbase},
+		PrevBlockHash: []byte{},
+		Nonce:         p.GenesisNonce,
+		Bits:          p.GenesisBits,
+	}
+	if genesis.Hash, err = NewPOW(genesis).hash(genesis.Nonce); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(genesis.Hash, p.GenesisHash) || !NewPOW(genesis).Validate() {
+		return nil, ErrGenesisMismatch
+	}
+
+	return genesis, nil
+}
+
+// NetworkParams returns the parameters of the network with the given name
+func NetworkParams(name string) (*ChainParams, error) {
+	for _, params := range networks {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return nil, ErrInvalidNetwork
+}
+
+// Subsidy returns the coinbase reward of the block at height
+func (p *ChainParams) Subsidy(height int64) int {
+	halvings := height / p.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.BaseSubsidy >> uint(halvings)
+}
+
+// ValidateAddress checks the address's checksum and that it belongs to the network
+func (p *ChainParams) ValidateAddress(address string) bool {
+	if !ValidateAddress(address) {
+		return false
+	}
+	return addressVersion(address) == p.AddressVersion
+}
+
+// nextBits returns the difficulty of the block following prev. windowStart returns the timestamp of the block RetargetInterval-1 blocks before prev, it is only called on retarget heights.
+func (p *ChainParams) nextBits(prevBits uint32, prevHeight, prevTimestamp int64, windowStart func() (int64, error)) (uint32, error) {
+	if p.NoRetargeting || (prevHeight+1)%p.RetargetInterval != 0 {
+		return prevBits, nil
+	}
+
+	start, err := windowStart()
+	if err != nil {
+		return 0, err
+	}
+
+	expected := int64(p.TargetSpacing/time.Second) * p.RetargetInterval
+	actual := prevTimestamp - start
+	if actual < expected/p.MaxRetargetFactor {
+		actual = expected / p.MaxRetargetFactor
+	}
+	if actual > expected*p.MaxRetargetFactor {
+		actual = expected * p.MaxRetargetFactor
+	}
+
+	// Bits counts the leading zero bits of the target, so the difficulty only moves in steps of a doubling or a halving. Rounding the number of doublings means a retarget leaves it unchanged until blocks come more than √2 (about 1.41) times too fast or too slow, and the block time can settle anywhere between 0.71 and 1.41 times TargetSpacing. A finer adjustment needs a compact target in the header.
+	bits := int64(prevBits) + int64(math.Round(math.Log2(float64(expected)/float64(actual))))
+	if bits < int64(p.PowLimitBits) {
+		bits = int64(p.PowLimitBits)
+	}
+	if bits > maxTargetBits {
+		bits = maxTargetBits
+	}
+
+	return uint32(bits), nil
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
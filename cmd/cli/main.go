@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"gitlab.com/rodzzlessa24/hoji"
 )
@@ -16,24 +26,30 @@ func main() {
 }
 
 // CLI responsible for processing command line arguments
-type CLI struct{}
+type CLI struct {
+	opts   []hoji.Option
+	params *hoji.ChainParams
+}
 
 func (cli *CLI) createBlockchain(address string) {
-	if !hoji.ValidateAddress(address) {
+	if !cli.params.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	if err := hoji.CreateBlockchain([]byte(address)); err != nil {
+	if err := hoji.CreateBlockchain([]byte(address), cli.opts...); err != nil {
 		log.Panic(err)
 	}
 	fmt.Println("Done!")
 }
 
 func (cli *CLI) getBalance(address string) {
-	if !hoji.ValidateAddress(address) {
+	if !cli.params.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc, _ := hoji.NewBlockchain()
-	defer bc.DB.Close()
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
 
 	utxoSet := hoji.UTXOSet{Bc: bc}
 	balance := 0
@@ -50,11 +66,18 @@ func (cli *CLI) getBalance(address string) {
 }
 
 func (cli *CLI) reindexUTXO() {
-	bc, _ := hoji.NewBlockchain()
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
 	UTXOSet := hoji.UTXOSet{
 		Bc: bc,
 	}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		cli.historyError(bc, err)
+	}
 
 	count, err := UTXOSet.CountTransactions()
 	if err != nil {
@@ -64,34 +87,31 @@ func (cli *CLI) reindexUTXO() {
 }
 
 func (cli *CLI) send(from, to string, amount int) {
-	if !hoji.ValidateAddress(from) {
+	if !cli.params.ValidateAddress(from) {
 		log.Panic("ERROR: from address is not valid")
 	}
-	if !hoji.ValidateAddress(to) {
+	if !cli.params.ValidateAddress(to) {
 		log.Panic("ERROR: to address is not valid")
 	}
-	bc, _ := hoji.NewBlockchain()
-	defer bc.DB.Close()
-
-	tx, err := bc.NewTx([]byte(from), []byte(to), amount)
+	bc, err := hoji.NewBlockchain(cli.opts...)
 	if err != nil {
 		log.Panic(err)
 	}
+	defer bc.Close()
 
-	coinbaseTx, err := hoji.NewCoinbaseTx([]byte(from), []byte("reward tx"))
+	tx, err := bc.NewTx([]byte(from), []byte(to), amount)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	txs := []*hoji.Transaction{tx, coinbaseTx}
-
-	b, err := bc.MineBlock(txs)
+	coinbaseTx, err := bc.NewCoinbaseTx([]byte(from), []byte("reward tx"))
 	if err != nil {
 		log.Panic(err)
 	}
 
-	utxoSet := hoji.UTXOSet{Bc: bc}
-	if err := utxoSet.Update(b); err != nil {
+	txs := []*hoji.Transaction{tx, coinbaseTx}
+
+	if _, err := bc.MineBlock(txs); err != nil {
 		log.Panic(err)
 	}
 
@@ -101,11 +121,75 @@ func (cli *CLI) send(from, to string, amount int) {
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain on top of the network's genesis block and send the first block reward to ADDRESS")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  spvbalance [-address ADDRESS] [-node HOST:PORT] - Sync block headers from a node and get the balance of ADDRESS, or of every wallet address, from merkle proofs only")
+	fmt.Println("  startnode [-listen HOST:PORT] [-rpclisten HOST:PORT] - Serve the blockchain to light clients and other nodes, and the JSON-RPC interface, until interrupted")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("  dumpprivkey -address ADDRESS - Print the private key of ADDRESS in wallet import format")
+	fmt.Println("  importprivkey -key KEY [-rescan] - Add a private key in wallet import format to the wallet file and optionally rescan the chain for its balance")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
+	fmt.Println("  gettxproof -txid TXID - Print the block header and merkle proof showing TXID is in the blockchain")
+	fmt.Println("  verifytxproof -proof PROOF - Check a proof printed by gettxproof without the blockchain")
+	fmt.Println("  getblockfilter -hash HASH [-address ADDRESS] - Print the compact filter and filter header of block HASH and optionally test ADDRESS against it")
+	fmt.Println("  verifychain [-level N] [-depth N] [-invalidate] - Re-validate the last N blocks up to level N (0 links, 1 proof of work, 2 contents, 3 signatures, 4 UTXO set) and optionally invalidate the first bad block")
+	fmt.Println("  dumputxoset -file FILE [-hash HASH] - Write a snapshot of the UTXO set at block HASH, by default the tip, to FILE")
+	fmt.Println("  loadutxoset -file FILE - Bootstrap a new node from a UTXO set snapshot matching one of the network's commitments. The networks only commit to their genesis block, add the commitment dumputxoset printed on a trusted node with -assumeutxo, or assumeutxo in the config file, until the snapshot is validated")
+	fmt.Println("  history -address ADDRESS [-offset N] [-limit N] - List the transactions paying to or spending from ADDRESS, oldest first, using the address history index")
+	fmt.Println("  exportblocks -out FILE [-from HEIGHT] [-to HEIGHT] - Write the blocks from HEIGHT to HEIGHT, by default all of them, to FILE")
+	fmt.Println("  importblocks FILE - Validate and add the blocks of a file written by exportblocks, then validate a loaded UTXO snapshot against them")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("Every command also accepts -datadir DIR, -network NETWORK, -conf FILE, -prune N, -addrindex, -assumeutxo HEIGHT:HASH:UTXOHASH and -loglevel LEVELS")
+}
+
+// configure sets the options passed to the library. Flags take precedence over the environment, which takes precedence over the config file. The library logs to stderr.
+func (cli *CLI) configure(dataDir, network, configFile string, prune int64, addrIndex bool, assumeUTXO, logLevel string) {
+	if configFile == "" {
+		dir := dataDir
+		if dir == "" {
+			dir = hoji.DefaultDataDir()
+		}
+		configFile = filepath.Join(dir, hoji.ConfigFileName)
+	}
+	opts, err := hoji.LoadConfig(configFile)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.opts = append([]hoji.Option{hoji.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, nil)))}, opts...)
+
+	if dir := os.Getenv(hoji.DataDirEnv); dir != "" {
+		cli.opts = append(cli.opts, hoji.WithDataDir(dir))
+	}
+	if dataDir != "" {
+		cli.opts = append(cli.opts, hoji.WithDataDir(dataDir))
+	}
+	if network != "" {
+		cli.opts = append(cli.opts, hoji.WithNetwork(network))
+	}
+	if prune != 0 {
+		cli.opts = append(cli.opts, hoji.WithPrune(prune))
+	}
+	if addrIndex {
+		cli.opts = append(cli.opts, hoji.WithAddressIndex(true))
+	}
+	if assumeUTXO != "" {
+		commitment, err := hoji.ParseUTXOCommitment(assumeUTXO)
+		if err != nil {
+			log.Panic(err)
+		}
+		cli.opts = append(cli.opts, hoji.WithAssumeUTXO(commitment))
+	}
+	if logLevel != "" {
+		levels, err := hoji.ParseLogLevels(logLevel)
+		if err != nil {
+			log.Panic(err)
+		}
+		cli.opts = append(cli.opts, levels...)
+	}
+
+	if cli.params, err = hoji.ResolveParams(cli.opts...); err != nil {
+		log.Panic(err)
+	}
 }
 
 func (cli *CLI) validateArgs() {
@@ -116,7 +200,7 @@ func (cli *CLI) validateArgs() {
 }
 
 func (cli *CLI) createWallet() {
-	wallets, err := hoji.NewWallets()
+	wallets, err := hoji.NewWallets(cli.opts...)
 	if err != nil {
 		log.Panic("err creating new wallets", err)
 	}
@@ -130,29 +214,505 @@ func (cli *CLI) createWallet() {
 }
 
 func (cli *CLI) printChain() {
-	// TODO: Fix this
-	bc, _ := hoji.NewBlockchain()
-	defer bc.DB.Close()
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err == hoji.ErrNoMoreBlocks {
+			break
+		}
+		if err == hoji.ErrBlockPruned {
+			fmt.Printf("The node is pruned, blocks up to height %d were deleted\n", pruneHeight)
+			break
+		}
+		if err != nil {
+			log.Panic(err)
+		}
 
 		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
 		pow := hoji.NewPOW(block)
 		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
 		fmt.Println()
+	}
+}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
+// historyError reports an error of a command reading old blocks, telling how far back a pruned node can go
+func (cli *CLI) historyError(bc *hoji.Blockchain, err error) {
+	if err == hoji.ErrBlockPruned {
+		if height, herr := bc.PruneHeight(); herr == nil {
+			log.Panicf("ERROR: the node is pruned, blocks up to height %d were deleted: %v", height, err)
+		}
+	}
+	log.Panic(err)
+}
+
+func (cli *CLI) dumpPrivKey(address string) {
+	if !cli.params.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	wallets, err := hoji.NewWallets(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(address)
+	if wallet == nil {
+		log.Panic("ERROR: Address is not in the wallet file")
+	}
+
+	fmt.Printf("%s\n", wallet.WIF())
+}
+
+func (cli *CLI) importPrivKey(key string, rescan bool) {
+	wallet, err := hoji.NewWalletFromWIF([]byte(key))
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets, err := hoji.NewWallets(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	address, err := wallets.ImportWallet(wallet)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Imported address: %s\n", address)
+
+	if !rescan {
+		return
+	}
+
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	utxoSet := hoji.UTXOSet{Bc: bc}
+	if err := utxoSet.Reindex(); err != nil {
+		cli.historyError(bc, err)
+	}
+
+	balance := 0
+	UTXOs, err := utxoSet.FindUTXO(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, out := range UTXOs {
+		balance += out.Value
+	}
+
+	fmt.Printf("Rescan done! Balance of '%s': %d\n", address, balance)
+}
+
+func (cli *CLI) getTxProof(txID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("ERROR: Transaction ID is not valid hex")
+	}
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	proof, err := bc.TxProof(id)
+	if err != nil {
+		cli.historyError(bc, err)
+	}
+	proofBytes, err := proof.Bytes()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Block: %x\n", proof.Header.Hash)
+	fmt.Printf("Merkle root: %x\n", proof.Header.MerkleRoot)
+	fmt.Printf("Proof: %x\n", proofBytes)
+}
+
+func (cli *CLI) verifyTxProof(proofHex string) {
+	proofBytes, err := hex.DecodeString(proofHex)
+	if err != nil {
+		log.Panic("ERROR: Proof is not valid hex")
+	}
+	proof, err := hoji.BytesToTxProof(proofBytes)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	ok, err := proof.Verify()
+	if err != nil {
+		log.Panic(err)
+	}
+	if !ok {
+		fmt.Println("Proof is NOT valid")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Proof is valid: transaction %x is in block %x\n", proof.Tx.ID, proof.Header.Hash)
+}
+
+func (cli *CLI) getBlockFilter(hashHex, address string) {
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		log.Panic("ERROR: Block hash is not valid hex")
+	}
+	if address != "" && !cli.params.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	msg, err := bc.HandleGetCFilter(&hoji.MsgGetCFilter{BlockHash: hash})
+	if err != nil {
+		log.Panic(err)
+	}
+	filter, err := msg.GCSFilter()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Items: %d\n", filter.N)
+	fmt.Printf("Filter: %x\n", msg.Filter)
+	fmt.Printf("Filter header: %x\n", msg.FilterHeader)
+	if address != "" {
+		fmt.Printf("Matches '%s': %v\n", address, filter.Match(hoji.ExtractPubKeyHash([]byte(address))))
+	}
+}
+
+func (cli *CLI) history(address string, offset, limit int) {
+	if !cli.params.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		cli.addressIndexError(err)
+	}
+	defer bc.Close()
+
+	history, err := bc.AddressHistory([]byte(address), offset, limit)
+	if err != nil {
+		cli.addressIndexError(err)
+	}
+
+	for _, tx := range history {
+		var kind string
+		switch {
+		case tx.Funding && tx.Spending:
+			kind = "sent with change"
+		case tx.Spending:
+			kind = "sent"
+		default:
+			kind = "received"
 		}
+		fmt.Printf("%x at height %d: %s\n", tx.TxID, tx.Height, kind)
+	}
+}
+
+// addressIndexError reports an error of a command using the address history index, telling how to enable it
+func (cli *CLI) addressIndexError(err error) {
+	switch err {
+	case hoji.ErrNoAddressIndex:
+		log.Panicf("ERROR: %v, pass -addrindex or set addrindex = 1 in the config file", err)
+	case hoji.ErrBlockPruned:
+		log.Panicf("ERROR: the address history index needs every block and the node is pruned: %v", err)
+	}
+	log.Panic(err)
+}
+
+func (cli *CLI) verifyChain(level, depth int, invalidate bool) {
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	// os.Exit skips the deferred Close, the blockchain is closed first so an invalidation is written out of the UTXO cache
+	fail := func() {
+		if err := bc.Close(); err != nil {
+			log.Panic(err)
+		}
+		os.Exit(1)
+	}
+
+	checked, err := bc.VerifyChain(level, depth)
+	var bad *hoji.BadBlockError
+	switch {
+	case err == nil:
+		fmt.Printf("Verified %d blocks at level %d\n", checked, level)
+		return
+	case errors.As(err, &bad):
+		fmt.Printf("Block %x at height %d is invalid: %v\n", bad.Hash, bad.Height, bad.Err)
+	case errors.Is(err, hoji.ErrUTXOMismatch):
+		fmt.Printf("Verified %d blocks but the UTXO set is invalid: %v\n", checked, err)
+		fmt.Println("Run reindexutxo to rebuild it")
+		fail()
+	default:
+		log.Panic(err)
+	}
+
+	if invalidate {
+		if err := bc.InvalidateBlock(bad.Hash); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Invalidated block %x and its descendants\n", bad.Hash)
+	}
+	fail()
+}
+
+func (cli *CLI) dumpUTXOSet(path, hashHex string) {
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		log.Panic("ERROR: Block hash is not valid hex")
+	}
+
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	snapshot, err := bc.DumpUTXOSet(f, hash)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Dumped %d transactions at block %x, height %d\n", snapshot.Transactions, snapshot.BlockHash, snapshot.Height)
+	fmt.Printf("UTXO set hash: %x\n", snapshot.UTXOHash)
+	fmt.Printf("Load it on another node with -assumeutxo %s\n", snapshot.Commitment())
+}
+
+// blocksProgress is how many blocks exportblocks and importblocks process between two progress reports
+const blocksProgress = 100
+
+func (cli *CLI) exportBlocks(path string, from, to int64) {
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	w := bufio.NewWriter(f)
+	exported, err := bc.ExportBlocks(w, from, to, func(blocks int, height int64) {
+		if blocks%blocksProgress == 0 {
+			fmt.Printf("Exported %d blocks, height %d\n", blocks, height)
+		}
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cli.historyError(bc, err)
+	}
+
+	fmt.Printf("Done! Exported %d blocks to %s\n", exported, path)
+}
+
+func (cli *CLI) importBlocks(path string) {
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	imported, err := bc.ImportBlocks(bufio.NewReader(f), func(blocks int, height int64) {
+		if blocks%blocksProgress == 0 {
+			fmt.Printf("Imported %d blocks, height %d\n", blocks, height)
+		}
+	})
+	var bad *hoji.BadBlockError
+	if errors.As(err, &bad) {
+		log.Panicf("ERROR: imported %d blocks, block %x at height %d is invalid: %v", imported, bad.Hash, bad.Height, bad.Err)
+	}
+	if err != nil {
+		log.Panicf("ERROR: imported %d blocks: %v", imported, err)
+	}
+	fmt.Printf("Done! Imported %d blocks\n", imported)
+
+	// a node bootstrapped from a UTXO snapshot checks it once it has the blocks up to it
+	err = bc.ValidateSnapshot()
+	if errors.As(err, &bad) {
+		log.Panicf("ERROR: the UTXO snapshot doesn't hold, block %x at height %d is invalid: %v", bad.Hash, bad.Height, bad.Err)
+	}
+	if err != nil && err != hoji.ErrBlockPruned {
+		log.Panic(err)
+	}
+}
+
+func (cli *CLI) loadUTXOSet(path string) {
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	snapshot, err := bc.LoadUTXOSnapshot(f)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Loaded %d transactions, the chain now ends at block %x, height %d\n", snapshot.Transactions, snapshot.BlockHash, snapshot.Height)
+	fmt.Println("The snapshot is validated once the blocks up to it are imported with importblocks")
+}
+
+func (cli *CLI) spvBalance(address, node string) {
+	addresses := []string{address}
+	if address == "" {
+		wallets, err := hoji.NewWallets(cli.opts...)
+		if err != nil {
+			log.Panic(err)
+		}
+		addresses = wallets.GetAddresses()
+	} else if !cli.params.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	if node == "" {
+		node = net.JoinHostPort("localhost", strconv.Itoa(cli.params.DefaultPort))
+	}
+	peer, err := hoji.DialPeer(node, cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer peer.Close()
+
+	version, err := peer.Version()
+	if err != nil {
+		log.Panic(err)
+	}
+	if version.Services&hoji.SFNodeNetwork == 0 {
+		fmt.Printf("%s is a pruned node, it can only prove the transactions of its last %d blocks\n", node, hoji.MinPruneDepth)
+	}
+
+	lc, err := hoji.NewLightClient(peer, cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer lc.Close()
+
+	synced, err := lc.Sync()
+	if err != nil {
+		log.Panic(err)
+	}
+	_, height, err := lc.Tip()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Synced %d new headers from %s, header chain height: %d\n", synced, node, height)
+
+	for _, address := range addresses {
+		balance, err := lc.Balance([]byte(address))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Printf("Balance of '%s': %d\n", address, balance.Balance)
+		for _, utxo := range balance.UTXOs {
+			fmt.Printf("  %s: %d (%d confirmations)\n", utxo.OutPoint, utxo.Value, utxo.Confirmations)
+		}
+	}
+}
+
+func (cli *CLI) startNode(listen, rpcListen string) {
+	if listen == "" {
+		listen = net.JoinHostPort("", strconv.Itoa(cli.params.DefaultPort))
+	}
+	if rpcListen == "" {
+		rpcListen = net.JoinHostPort("localhost", strconv.Itoa(cli.params.RPCPort))
+	}
+	bc, err := hoji.NewBlockchain(cli.opts...)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Panic(err)
+	}
+	server := hoji.NewServer(bc)
+
+	rpcListener, err := net.Listen("tcp", rpcListen)
+	if err != nil {
+		log.Panic(err)
+	}
+	rpcServer := &http.Server{Handler: hoji.NewRPCHandler(bc)}
+	go func() {
+		if err := rpcServer.Serve(rpcListener); err != http.ErrServerClosed {
+			log.Panic(err)
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		rpcServer.Close()
+		server.Close()
+	}()
+
+	// a node bootstrapped from a UTXO snapshot whose blocks were imported checks it while serving
+	validated := bc.ValidateSnapshotInBackground()
+	go func() {
+		if err := <-validated; err != nil && err != hoji.ErrBlockPruned {
+			log.Printf("ERROR: UTXO snapshot validation failed: %v", err)
+		}
+	}()
+
+	fmt.Printf("Serving the %s chain to peers on %s and RPC on %s, press Ctrl-C to stop\n", cli.params.Name, l.Addr(), rpcListener.Addr())
+	if err := server.Serve(l); err != nil {
+		log.Panic(err)
 	}
 }
 
 func (cli *CLI) listAddresses() {
-	wallets, err := hoji.NewWallets()
+	wallets, err := hoji.NewWallets(cli.opts...)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -174,12 +734,63 @@ func (cli *CLI) Run() {
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	getTxProofCmd := flag.NewFlagSet("gettxproof", flag.ExitOnError)
+	verifyTxProofCmd := flag.NewFlagSet("verifytxproof", flag.ExitOnError)
+	spvBalanceCmd := flag.NewFlagSet("spvbalance", flag.ExitOnError)
+	getBlockFilterCmd := flag.NewFlagSet("getblockfilter", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	dumpUTXOSetCmd := flag.NewFlagSet("dumputxoset", flag.ExitOnError)
+	loadUTXOSetCmd := flag.NewFlagSet("loadutxoset", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	exportBlocksCmd := flag.NewFlagSet("exportblocks", flag.ExitOnError)
+	importBlocksCmd := flag.NewFlagSet("importblocks", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send the first block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "The address to export the private key of")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "The private key in wallet import format")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", false, "Rescan the blockchain for the imported key's balance")
+	getTxProofID := getTxProofCmd.String("txid", "", "The hex encoded transaction ID to prove")
+	verifyTxProofProof := verifyTxProofCmd.String("proof", "", "The hex encoded proof printed by gettxproof")
+	spvBalanceAddress := spvBalanceCmd.String("address", "", "The address to get balance for, defaults to every wallet address")
+	spvBalanceNode := spvBalanceCmd.String("node", "", "The node to sync from, defaults to the network's port on localhost")
+	getBlockFilterHash := getBlockFilterCmd.String("hash", "", "The hex encoded hash of the block")
+	getBlockFilterAddress := getBlockFilterCmd.String("address", "", "An address to test against the filter")
+	verifyChainLevel := verifyChainCmd.Int("level", hoji.VerifySignatures, "How thorough the verification is, from 0 to 4")
+	verifyChainDepth := verifyChainCmd.Int("depth", 6, "The number of blocks to verify from the tip, 0 for all of them")
+	verifyChainInvalidate := verifyChainCmd.Bool("invalidate", false, "Rewind the chain to the parent of the first bad block")
+	dumpUTXOSetFile := dumpUTXOSetCmd.String("file", "", "The file to write the snapshot to")
+	dumpUTXOSetHash := dumpUTXOSetCmd.String("hash", "", "The hex encoded hash of the block to take the snapshot at, the tip by default")
+	loadUTXOSetFile := loadUTXOSetCmd.String("file", "", "The snapshot file to load")
+	historyAddress := historyCmd.String("address", "", "The address to list the transactions of")
+	historyOffset := historyCmd.Int("offset", 0, "The number of transactions to skip")
+	historyLimit := historyCmd.Int("limit", 0, "The maximum number of transactions to list, 0 lists them all")
+	exportBlocksOut := exportBlocksCmd.String("out", "", "The file to write the blocks to")
+	exportBlocksFrom := exportBlocksCmd.Int64("from", 0, "The height of the first block to export")
+	exportBlocksTo := exportBlocksCmd.Int64("to", -1, "The height of the last block to export, -1 for the tip")
+	var importBlocksFile string
+	startNodeListen := startNodeCmd.String("listen", "", "The address to listen on, defaults to the network's port on every interface")
+	startNodeRPCListen := startNodeCmd.String("rpclisten", "", "The address to serve JSON-RPC on, defaults to the network's RPC port on localhost")
+
+	var dataDir, network, configFile string
+	var prune int64
+	var addrIndex bool
+	var assumeUTXO, logLevel string
+	for _, cmd := range []*flag.FlagSet{getBalanceCmd, createBlockchainCmd, createWalletCmd, listAddressesCmd, sendCmd, printChainCmd, reindexUTXOCmd, dumpPrivKeyCmd, importPrivKeyCmd, getTxProofCmd, verifyTxProofCmd, spvBalanceCmd, getBlockFilterCmd, verifyChainCmd, dumpUTXOSetCmd, loadUTXOSetCmd, historyCmd, exportBlocksCmd, importBlocksCmd, startNodeCmd} {
+		cmd.StringVar(&dataDir, "datadir", "", "The data directory, defaults to $"+hoji.DataDirEnv+" or ~/.hoji")
+		cmd.StringVar(&network, "network", "", "The network to use: mainnet, testnet or regtest, defaults to "+hoji.DefaultNetwork)
+		cmd.StringVar(&configFile, "conf", "", "The config file, defaults to "+hoji.ConfigFileName+" in the data directory")
+		cmd.Int64Var(&prune, "prune", 0, fmt.Sprintf("Only keep the transactions of the last N blocks, at least %d, 0 keeps every block", hoji.MinPruneDepth))
+		cmd.BoolVar(&addrIndex, "addrindex", false, "Maintain the address history index used by the history command")
+		cmd.StringVar(&assumeUTXO, "assumeutxo", "", "A UTXO set commitment HEIGHT:HASH:UTXOHASH printed by dumputxoset on a trusted node, added to the network's commitments")
+		cmd.StringVar(&logLevel, "loglevel", "", "The log level, debug, info, warn or error, of every subsystem or of some of them, e.g. warn,pow=info. Subsystems: "+strings.Join(hoji.LogSubsystems, ", "))
+	}
 
 	switch os.Args[1] {
 	case "getbalance":
@@ -217,11 +828,85 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxproof":
+		err := getTxProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifytxproof":
+		err := verifyTxProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "spvbalance":
+		err := spvBalanceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblockfilter":
+		err := getBlockFilterCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumputxoset":
+		err := dumpUTXOSetCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "loadutxoset":
+		err := loadUTXOSetCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportblocks":
+		err := exportBlocksCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importblocks":
+		err := importBlocksCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		// the flags may also follow the file
+		if importBlocksCmd.NArg() > 0 {
+			importBlocksFile = importBlocksCmd.Arg(0)
+			if err := importBlocksCmd.Parse(importBlocksCmd.Args()[1:]); err != nil {
+				log.Panic(err)
+			}
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
 	}
 
+	cli.configure(dataDir, network, configFile, prune, addrIndex, assumeUTXO, logLevel)
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
@@ -250,6 +935,102 @@ func (cli *CLI) Run() {
 		cli.listAddresses()
 	}
 
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(*importPrivKeyKey, *importPrivKeyRescan)
+	}
+
+	if getTxProofCmd.Parsed() {
+		if *getTxProofID == "" {
+			getTxProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTxProof(*getTxProofID)
+	}
+
+	if verifyTxProofCmd.Parsed() {
+		if *verifyTxProofProof == "" {
+			verifyTxProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyTxProof(*verifyTxProofProof)
+	}
+
+	if spvBalanceCmd.Parsed() {
+		cli.spvBalance(*spvBalanceAddress, *spvBalanceNode)
+	}
+
+	if getBlockFilterCmd.Parsed() {
+		if *getBlockFilterHash == "" {
+			getBlockFilterCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlockFilter(*getBlockFilterHash, *getBlockFilterAddress)
+	}
+
+	if verifyChainCmd.Parsed() {
+		if *verifyChainLevel < hoji.VerifyLinks || *verifyChainLevel > hoji.VerifyUTXO || *verifyChainDepth < 0 {
+			verifyChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(*verifyChainLevel, *verifyChainDepth, *verifyChainInvalidate)
+	}
+
+	if dumpUTXOSetCmd.Parsed() {
+		if *dumpUTXOSetFile == "" {
+			dumpUTXOSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpUTXOSet(*dumpUTXOSetFile, *dumpUTXOSetHash)
+	}
+
+	if loadUTXOSetCmd.Parsed() {
+		if *loadUTXOSetFile == "" {
+			loadUTXOSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadUTXOSet(*loadUTXOSetFile)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historyOffset < 0 || *historyLimit < 0 {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress, *historyOffset, *historyLimit)
+	}
+
+	if exportBlocksCmd.Parsed() {
+		if *exportBlocksOut == "" || *exportBlocksFrom < 0 || *exportBlocksTo >= 0 && *exportBlocksTo < *exportBlocksFrom {
+			exportBlocksCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportBlocks(*exportBlocksOut, *exportBlocksFrom, *exportBlocksTo)
+	}
+
+	if importBlocksCmd.Parsed() {
+		if importBlocksFile == "" || importBlocksCmd.NArg() > 0 {
+			importBlocksCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importBlocks(importBlocksFile)
+	}
+
+	if startNodeCmd.Parsed() {
+		cli.startNode(*startNodeListen, *startNodeRPCListen)
+	}
+
 	if printChainCmd.Parsed() {
 		cli.printChain()
 	}
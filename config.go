@@ -0,0 +1,282 @@
+package hoji
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultOpenTimeout is how long opening a database waits for another process to release it by default
+	DefaultOpenTimeout = 5 * time.Second
+	// DataDirEnv overrides the default data directory
+	DataDirEnv = "HOJI_DATA_DIR"
+	// DefaultNetwork is the network used when none is configured
+	DefaultNetwork = "mainnet"
+	// ConfigFileName is the name of the config file looked up in the data directory
+	ConfigFileName = "hoji.conf"
+)
+
+// Option configures NewBlockchain, CreateBlockchain, NewWallets and NewLightClient
+type Option func(*options)
+
+type options struct {
+	store   Store
+	dataDir string
+	network string
+	params  *ChainParams
+	prune   int64
+	// addrIndex maintains the address history index
+	addrIndex bool
+
+	cacheSize     int
+	flushInterval time.Duration
+
+	openTimeout time.Duration
+
+	// assumeUTXO is added to the network's AssumeUTXO commitments
+	assumeUTXO []UTXOCommitment
+
+	logger    *slog.Logger
+	logLevels map[string]slog.Leveler
+}
+
+// WithStore makes the blockchain use store instead of the bolt database file. The caller keeps ownership of the store until it is handed to a Blockchain, which closes it on Close.
+func WithStore(store Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithDataDir sets the directory holding the databases and the wallet file of every network
+func WithDataDir(dir string) Option {
+	return func(o *options) {
+		o.dataDir = dir
+	}
+}
+
+// WithNetwork selects one of the predefined networks by name, its files are kept in their own subdirectory of the data directory
+func WithNetwork(network string) Option {
+	return func(o *options) {
+		o.network = network
+		o.params = nil
+	}
+}
+
+// WithChainParams selects a network by its parameters, for networks other than the predefined ones
+func WithChainParams(params *ChainParams) Option {
+	return func(o *options) {
+		o.network = params.Name
+		o.params = params
+	}
+}
+
+// WithPrune makes the blockchain keep only the transactions of its last depth blocks, depth has to be at least MinPruneDepth. Older blocks can't be served to peers nor used to rebuild the UTXO set. They are deleted when the UTXO cache is written to the store. 0 keeps every block.
+func WithPrune(depth int64) Option {
+	return func(o *options) {
+		o.prune = depth
+	}
+}
+
+// WithAddressIndex makes the blockchain maintain the address history index used by AddressHistory. The index catches up with the chain when the blockchain is opened, which needs every block it hasn't indexed yet. Without the option the index is left as is.
+func WithAddressIndex(enabled bool) Option {
+	return func(o *options) {
+		o.addrIndex = enabled
+	}
+}
+
+// WithUTXOCache sets the memory budget of the UTXO cache in bytes and how often it is written to the store. The cache is also written when it goes over budget and when the blockchain is closed. A budget of 0 writes it after every block, an interval of 0 disables the periodic writes.
+func WithUTXOCache(size int, flushInterval time.Duration) Option {
+	return func(o *options) {
+		o.cacheSize = size
+		o.flushInterval = flushInterval
+	}
+}
+
+// WithOpenTimeout sets how long opening a database waits for another process using it to close it, see NewBoltStore
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.openTimeout = timeout
+	}
+}
+
+// WithAssumeUTXO adds commitments to the AssumeUTXO commitments of the network, so snapshots dumped on a trusted node can be loaded with LoadUTXOSnapshot and validated with ValidateSnapshot. The node that loaded a snapshot needs the commitment until the snapshot is validated.
+func WithAssumeUTXO(commitments ...UTXOCommitment) Option {
+	return func(o *options) {
+		o.assumeUTXO = append(o.assumeUTXO, commitments...)
+	}
+}
+
+// ResolveParams returns the parameters of the network selected by opts
+func ResolveParams(opts ...Option) (*ChainParams, error) {
+	return newOptions(opts).chainParams()
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		dataDir: DefaultDataDir(),
+		network: DefaultNetwork,
+
+		cacheSize:     DefaultUTXOCacheSize,
+		flushInterval: DefaultUTXOFlushInterval,
+
+		openTimeout: DefaultOpenTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// DefaultDataDir returns $HOJI_DATA_DIR, or ~/.hoji when it isn't set
+func DefaultDataDir() string {
+	if dir := os.Getenv(DataDirEnv); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".hoji"
+	}
+	return filepath.Join(home, ".hoji")
+}
+
+// LoadConfig reads a config file of "key = value" lines, blank lines and lines starting with # are ignored. The datadir, network, prune, addrindex, assumeutxo and loglevel keys are supported, loglevel takes the levels ParseLogLevels does and assumeutxo, which can be repeated, a commitment ParseUTXOCommitment reads. A missing file is not an error.
+func LoadConfig(path string) ([]Option, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var opts []Option
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, ErrInvalidConfig)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "datadir":
+			opts = append(opts, WithDataDir(value))
+		case "network":
+			opts = append(opts, WithNetwork(value))
+		case "prune":
+			depth, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v: %v", path, line, err, ErrInvalidConfig)
+			}
+			opts = append(opts, WithPrune(depth))
+		case "addrindex":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v: %v", path, line, err, ErrInvalidConfig)
+			}
+			opts = append(opts, WithAddressIndex(enabled))
+		case "assumeutxo":
+			commitment, err := ParseUTXOCommitment(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			opts = append(opts, WithAssumeUTXO(commitment))
+		case "loglevel":
+			levels, err := ParseLogLevels(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			opts = append(opts, levels...)
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key %q: %v", path, line, key, ErrInvalidConfig)
+		}
+	}
+
+	return opts, scanner.Err()
+}
+
+func (o *options) chainParams() (*ChainParams, error) {
+	params := o.params
+	if params == nil {
+		var err error
+		if params, err = NetworkParams(o.network); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.assumeUTXO) == 0 {
+		return params, nil
+	}
+
+	// the network's parameters are shared, the commitments go to a copy
+	p := *params
+	p.AssumeUTXO = append(append([]UTXOCommitment{}, params.AssumeUTXO...), o.assumeUTXO...)
+	return &p, nil
+}
+
+// path returns the path of the file name in the network's directory, creating the directory if needed
+func (o *options) path(name string) (string, error) {
+	params, err := o.chainParams()
+	if err != nil {
+		return "", err
+	}
+	if params.Name == "" || params.Name != filepath.Base(params.Name) || params.Name == "." || params.Name == ".." {
+		return "", ErrInvalidNetwork
+	}
+
+	dir := filepath.Join(o.dataDir, params.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// checkLegacyFile refuses to start a new file at path while the file of the same name that versions before the data directory kept in the working directory is still there, so it isn't silently left behind. Those versions only knew the main network.
+func (o *options) checkLegacyFile(name, path string) error {
+	params, err := o.chainParams()
+	if err != nil || params.Name != MainNetParams.Name {
+		return err
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return err
+	}
+	legacy, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("%w: move %s to %s, or out of the way to start afresh", ErrLegacyDataFile, legacy, path)
+}
+
+// openStore returns the configured store, opening the network's bolt database when none is set. owned reports whether the store was opened here.
+func (o *options) openStore() (store Store, owned bool, err error) {
+	if o.store != nil {
+		return o.store, false, nil
+	}
+
+	path, err := o.path(dbFile)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := o.checkLegacyFile(dbFile, path); err != nil {
+		return nil, false, err
+	}
+	store, err = NewBoltStore(path, o.openTimeout)
+	return store, true, err
+}
@@ -0,0 +1,97 @@
+package hoji
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir changes the working directory until the end of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLegacyDataFiles(t *testing.T) {
+	chdir(t, t.TempDir())
+	if err := os.WriteFile(walletFile, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbFile, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+
+	if _, err := NewWallets(WithDataDir(dataDir)); !errors.Is(err, ErrLegacyDataFile) {
+		t.Fatalf("wallets: got %v, want ErrLegacyDataFile", err)
+	}
+	if _, err := NewBlockchain(WithDataDir(dataDir)); !errors.Is(err, ErrLegacyDataFile) {
+		t.Fatalf("blockchain: got %v, want ErrLegacyDataFile", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "mainnet", dbFile)); !os.IsNotExist(err) {
+		t.Fatalf("a new database was created next to the legacy one: %v", err)
+	}
+
+	// the files of older versions only belong to the main network
+	if _, err := NewWallets(WithDataDir(dataDir), WithNetwork("regtest")); err != nil {
+		t.Fatal(err)
+	}
+
+	// once moved they are used from the data directory
+	if err := os.Rename(walletFile, filepath.Join(dataDir, "mainnet", walletFile)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWallets(WithDataDir(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalletFileMode(t *testing.T) {
+	dataDir := t.TempDir()
+	path := filepath.Join(dataDir, "regtest", walletFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	// a wallet file created readable by everyone is replaced on the next save, the keys are never written to it
+	if err := os.WriteFile(path, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(dataDir, "old wallet file")
+	if err := os.Link(path, old); err != nil {
+		t.Fatal(err)
+	}
+
+	wallets, err := NewWallets(WithDataDir(dataDir), WithNetwork("regtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("wallet file mode is %o, want 600", mode)
+	}
+	if content, err := os.ReadFile(old); err != nil || len(content) != 0 {
+		t.Fatalf("keys were written to the file readable by everyone: %d bytes (%v)", len(content), err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("the wallet directory holds %d files, the temporary file was left behind", len(entries))
+	}
+}
@@ -0,0 +1,479 @@
+package hoji
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Wire format
+//
+// Blocks, transactions and their parts are serialized with the following length-prefixed binary format. It doesn't depend on Go type metadata so hashes stay stable across refactors and can be reproduced in other languages. Transaction IDs are sha256(transaction encoding) and every block hash is computed over data derived from it.
+//
+// All integers are little endian. varint is bitcoin's CompactSize: values below 0xfd are a single byte, otherwise a 0xfd/0xfe/0xff marker followed by a uint16/uint32/uint64. bytes is a varint length followed by the raw bytes.
+//
+//	Block:       uint32 version | int64 timestamp | uint32 bits | bytes prev block hash | int64 nonce | varint tx count | Transaction...
+//	BlockHeader: uint32 version | int64 timestamp | uint32 bits | bytes prev block hash | bytes merkle root | int64 nonce
+//	Transaction: uint32 version | varint input count | TxInput... | varint output count | TxOutput...
+//	TxInput:     OutPoint | bytes signature | bytes public key
+//	OutPoint:    bytes tx id | uint32 output index (0xffffffff for the coinbase null outpoint)
+//	TxOutput:    int64 value | bytes public key hash
+//	TxOutputs:   varint output count | (uint32 output index | TxOutput)... ordered by index
+//	BlockUndo:   varint output count | TxOutput... the outputs spent by the block's inputs, in block order, coinbases skipped
+//	MerkleProof: varint leaf index | varint leaf count | varint sibling count | bytes sibling...
+//	TxProof:     BlockHeader | Transaction | MerkleProof
+//	UTXOSnapshot: [4]byte network magic | uint32 version | bytes base block hash | varint header count | BlockHeader... | bytes filter header | varint tx count | (bytes tx id | bytes TxOutputs)... ordered by tx id | [32]byte sha256 of everything before
+//	BlockFile:   ([4]byte network magic | uint32 block size | Block)... ordered by height
+//
+// Version 1 blocks and headers don't have the bits field, they were all mined with legacyTargetBits. The hash of version 3 blocks commits to their version, the hash of older ones doesn't: since version 1 blocks are no longer valid, see minBlockVersion, and a version 3 block hashes differently, the version of a block can't be changed without changing its hash. The block hash and transaction IDs are not part of the encoding, decoders recompute them. A decoder rejects versions newer than the ones below.
+const (
+	blockVersion = 3
+	txVersion    = 1
+)
+
+const (
+	// minBlockVersion is the lowest version of a valid block. Version 1 blocks were hashed over merkle roots that duplicated the last node of odd levels, they only exist in databases that have to be recreated.
+	minBlockVersion = 2
+	// genesisVersion is the version of the hard-coded genesis blocks, their hashes were fixed before block hashes committed to the version
+	genesisVersion = 2
+)
+
+// maxVarBytes caps the size of a single length-prefixed field, a block can't be bigger than this anyway
+const maxVarBytes = 1 << 20
+
+// coinbaseOutIndex is how the -1 output index of a coinbase input is encoded
+const coinbaseOutIndex = 0xffffffff
+
+func writeVarInt(w io.Writer, v uint64) error {
+	var buf []byte
+	switch {
+	case v < 0xfd:
+		buf = []byte{byte(v)}
+	case v <= 0xffff:
+		buf = make([]byte, 3)
+		buf[0] = 0xfd
+		binary.LittleEndian.PutUint16(buf[1:], uint16(v))
+	case v <= 0xffffffff:
+		buf = make([]byte, 5)
+		buf[0] = 0xfe
+		binary.LittleEndian.PutUint32(buf[1:], uint32(v))
+	default:
+		buf = make([]byte, 9)
+		buf[0] = 0xff
+		binary.LittleEndian.PutUint64(buf[1:], v)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func readVarInt(r io.Reader) (uint64, error) {
+	var marker [1]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil {
+		return 0, err
+	}
+
+	switch marker[0] {
+	case 0xfd:
+		var v uint16
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xfe:
+		var v uint32
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xff:
+		var v uint64
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return v, err
+	}
+
+	return uint64(marker[0]), nil
+}
+
+func writeVarBytes(w io.Writer, b []byte) error {
+	if err := writeVarInt(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readVarBytes(r io.Reader) ([]byte, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxVarBytes {
+		return nil, ErrMalformedEncoding
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readCount reads an element count and rejects counts that can't possibly fit in the remaining input
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, ErrMalformedEncoding
+	}
+	return int(n), nil
+}
+
+func writeBlock(w io.Writer, b *Block) error {
+	if err := binary.Write(w, binary.LittleEndian, b.Version); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, b.Timestamp); err != nil {
+		return err
+	}
+	if b.Version >= 2 {
+		if err := binary.Write(w, binary.LittleEndian, b.Bits); err != nil {
+			return err
+		}
+	}
+	if err := writeVarBytes(w, b.PrevBlockHash); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int64(b.Nonce)); err != nil {
+		return err
+	}
+	if err := writeVarInt(w, uint64(len(b.Transactions))); err != nil {
+		return err
+	}
+	for _, tx := range b.Transactions {
+		if err := writeTransaction(w, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readBlock(r *bytes.Reader) (*Block, error) {
+	b := new(Block)
+	if err := binary.Read(r, binary.LittleEndian, &b.Version); err != nil {
+		return nil, err
+	}
+	if b.Version > blockVersion {
+		return nil, ErrUnknownVersion
+	}
+	if err := binary.Read(r, binary.LittleEndian, &b.Timestamp); err != nil {
+		return nil, err
+	}
+	b.Bits = legacyTargetBits
+	if b.Version >= 2 {
+		if err := binary.Read(r, binary.LittleEndian, &b.Bits); err != nil {
+			return nil, err
+		}
+		if b.Bits > maxTargetBits {
+			return nil, ErrMalformedEncoding
+		}
+	}
+
+	prevBlockHash, err := readVarBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	b.PrevBlockHash = prevBlockHash
+
+	var nonce int64
+	if err := binary.Read(r, binary.LittleEndian, &nonce); err != nil {
+		return nil, err
+	}
+	b.Nonce = int(nonce)
+
+	count, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		tx, err := readTransaction(r)
+		if err != nil {
+			return nil, err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	return b, nil
+}
+
+func writeBlockHeader(w io.Writer, h *BlockHeader) error {
+	if err := binary.Write(w, binary.LittleEndian, h.Version); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, h.Timestamp); err != nil {
+		return err
+	}
+	if h.Version >= 2 {
+		if err := binary.Write(w, binary.LittleEndian, h.Bits); err != nil {
+			return err
+		}
+	}
+	if err := writeVarBytes(w, h.PrevBlockHash); err != nil {
+		return err
+	}
+	if err := writeVarBytes(w, h.MerkleRoot); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, int64(h.Nonce))
+}
+
+func readBlockHeader(r io.Reader) (*BlockHeader, error) {
+	h := new(BlockHeader)
+	if err := binary.Read(r, binary.LittleEndian, &h.Version); err != nil {
+		return nil, err
+	}
+	if h.Version > blockVersion {
+		return nil, ErrUnknownVersion
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h.Timestamp); err != nil {
+		return nil, err
+	}
+	h.Bits = legacyTargetBits
+	if h.Version >= 2 {
+		if err := binary.Read(r, binary.LittleEndian, &h.Bits); err != nil {
+			return nil, err
+		}
+		if h.Bits > maxTargetBits {
+			return nil, ErrMalformedEncoding
+		}
+	}
+
+	var err error
+	if h.PrevBlockHash, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+	if h.MerkleRoot, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+
+	var nonce int64
+	if err := binary.Read(r, binary.LittleEndian, &nonce); err != nil {
+		return nil, err
+	}
+	h.Nonce = int(nonce)
+
+	hash := sha256.Sum256(powData(h.Version, h.PrevBlockHash, h.Timestamp, h.MerkleRoot, h.Bits, h.Nonce))
+	h.Hash = hash[:]
+
+	return h, nil
+}
+
+func writeMerkleProof(w io.Writer, p *MerkleProof) error {
+	if err := writeVarInt(w, uint64(p.Index)); err != nil {
+		return err
+	}
+	if err := writeVarInt(w, uint64(p.LeafCount)); err != nil {
+		return err
+	}
+	if err := writeVarInt(w, uint64(len(p.Siblings))); err != nil {
+		return err
+	}
+	for _, sibling := range p.Siblings {
+		if err := writeVarBytes(w, sibling); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readMerkleProof(r *bytes.Reader) (*MerkleProof, error) {
+	p := new(MerkleProof)
+
+	index, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if index > maxVarBytes {
+		return nil, ErrMalformedEncoding
+	}
+	p.Index = int(index)
+
+	leafCount, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if leafCount > maxVarBytes {
+		return nil, ErrMalformedEncoding
+	}
+	p.LeafCount = int(leafCount)
+
+	count, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		sibling, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		p.Siblings = append(p.Siblings, sibling)
+	}
+
+	return p, nil
+}
+
+func writeTransaction(w io.Writer, t *Transaction) error {
+	if err := binary.Write(w, binary.LittleEndian, t.Version); err != nil {
+		return err
+	}
+	if err := writeVarInt(w, uint64(len(t.Inputs))); err != nil {
+		return err
+	}
+	for _, in := range t.Inputs {
+		if err := writeTxInput(w, in); err != nil {
+			return err
+		}
+	}
+	if err := writeVarInt(w, uint64(len(t.Outputs))); err != nil {
+		return err
+	}
+	for _, out := range t.Outputs {
+		if err := writeTxOutput(w, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTransaction(r *bytes.Reader) (*Transaction, error) {
+	t := new(Transaction)
+	if err := binary.Read(r, binary.LittleEndian, &t.Version); err != nil {
+		return nil, err
+	}
+	if t.Version > txVersion {
+		return nil, ErrUnknownVersion
+	}
+
+	inputs, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < inputs; i++ {
+		in, err := readTxInput(r)
+		if err != nil {
+			return nil, err
+		}
+		t.Inputs = append(t.Inputs, in)
+	}
+
+	outputs, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < outputs; i++ {
+		out, err := readTxOutput(r)
+		if err != nil {
+			return nil, err
+		}
+		t.Outputs = append(t.Outputs, out)
+	}
+
+	id, err := t.hashTransaction()
+	if err != nil {
+		return nil, err
+	}
+	t.ID = id
+
+	return t, nil
+}
+
+func writeOutPoint(w io.Writer, o OutPoint) error {
+	if err := writeVarBytes(w, o.TxID); err != nil {
+		return err
+	}
+	index := uint32(o.Index)
+	if o.Index < 0 {
+		index = coinbaseOutIndex
+	}
+	return binary.Write(w, binary.LittleEndian, index)
+}
+
+func readOutPoint(r io.Reader) (OutPoint, error) {
+	txID, err := readVarBytes(r)
+	if err != nil {
+		return OutPoint{}, err
+	}
+
+	var index uint32
+	if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+		return OutPoint{}, err
+	}
+	if index == coinbaseOutIndex {
+		return NewOutPoint(txID, -1), nil
+	}
+
+	return NewOutPoint(txID, int(index)), nil
+}
+
+func writeTxInput(w io.Writer, in *TxInput) error {
+	if err := writeOutPoint(w, in.PrevOut); err != nil {
+		return err
+	}
+	if err := writeVarBytes(w, in.Signature); err != nil {
+		return err
+	}
+	return writeVarBytes(w, in.PubKey)
+}
+
+func readTxInput(r io.Reader) (*TxInput, error) {
+	in := new(TxInput)
+
+	prevOut, err := readOutPoint(r)
+	if err != nil {
+		return nil, err
+	}
+	in.PrevOut = prevOut
+
+	if in.Signature, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+	if in.PubKey, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+
+	return in, nil
+}
+
+func writeTxOutput(w io.Writer, out *TxOutput) error {
+	if err := binary.Write(w, binary.LittleEndian, int64(out.Value)); err != nil {
+		return err
+	}
+	return writeVarBytes(w, out.PubKeyHash)
+}
+
+func readTxOutput(r io.Reader) (*TxOutput, error) {
+	var value int64
+	if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := readVarBytes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxOutput{Value: int(value), PubKeyHash: pubKeyHash}, nil
+}
+
+// decodeAll runs decode over v and makes sure nothing is left over
+func decodeAll(v []byte, decode func(r *bytes.Reader) error) error {
+	r := bytes.NewReader(v)
+	if err := decode(r); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrMalformedEncoding
+		}
+		return err
+	}
+	if r.Len() != 0 {
+		return ErrMalformedEncoding
+	}
+	return nil
+}
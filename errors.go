@@ -2,12 +2,56 @@ package hoji
 
 // General errors.
 const (
-	ErrUnauthorized     = Error("unauthorized")
-	ErrInternal         = Error("internal error")
-	ErrNotFound         = Error("resource not found")
-	ErrBadRequest       = Error("bad request")
-	ErrBucketNotExist   = Error("bucket does not exist")
-	ErrInsuficientFunds = Error("insufficient funds")
+	ErrUnauthorized       = Error("unauthorized")
+	ErrInternal           = Error("internal error")
+	ErrNotFound           = Error("resource not found")
+	ErrBadRequest         = Error("bad request")
+	ErrBucketNotExist     = Error("bucket does not exist")
+	ErrInsuficientFunds   = Error("insufficient funds")
+	ErrInvalidWIF         = Error("invalid wallet import format")
+	ErrInvalidSigHash     = Error("invalid signature hash type")
+	ErrMalformedEncoding  = Error("malformed encoding")
+	ErrUnknownVersion     = Error("unknown encoding version")
+	ErrInvalidHeader      = Error("invalid block header")
+	ErrHeaderNotConnected = Error("block header does not connect to the header chain")
+	ErrInvalidFilter      = Error("invalid bloom filter")
+	ErrNoFilterLoaded     = Error("no bloom filter loaded")
+	ErrTxNotWritable      = Error("transaction is read only")
+	ErrStoreClosed        = Error("store is closed")
+	ErrStaleTip           = Error("another block was added while mining")
+	ErrBlockchainExists   = Error("blockchain already exists")
+	ErrInvalidConfig      = Error("invalid config file")
+	ErrInvalidNetwork     = Error("invalid network name")
+	ErrInvalidCoinbase    = Error("invalid coinbase transaction")
+	ErrWrongNetwork       = Error("key belongs to another network")
+	ErrGenesisMismatch    = Error("genesis block does not match the network's")
+	ErrBadBlockHash       = Error("block is not stored under its hash")
+	ErrDuplicateTx        = Error("block contains a transaction twice")
+	ErrInvalidSignature   = Error("invalid transaction signature")
+	ErrFilterMismatch     = Error("stored block filter does not match the block")
+	ErrUTXOMismatch       = Error("UTXO set does not match the chain")
+	ErrBlockPruned        = Error("block was pruned, the node only keeps the transactions of recent blocks")
+	ErrInvalidPruneDepth  = Error("prune depth is below the minimum")
+	ErrInvalidSnapshot    = Error("invalid UTXO snapshot")
+	ErrSnapshotChecksum   = Error("UTXO snapshot checksum mismatch")
+	ErrSnapshotMismatch   = Error("UTXO snapshot does not match any commitment of the network")
+	ErrInvalidBlockFile   = Error("invalid block file")
+	ErrNewerSchema        = Error("database was written by a newer version of hoji")
+	ErrNetworkMismatch    = Error("database belongs to another network")
+	ErrInvalidLogLevel    = Error("invalid log level")
+	ErrNoMoreBlocks       = Error("no more blocks")
+	ErrDatabaseLocked     = Error("database is locked by another process")
+	ErrNoAddressIndex     = Error("address index is disabled")
+	ErrLegacyDatabase     = Error("database was written with the gob encoding of early versions of hoji and its blocks can't be converted: move hoji.db out of the data directory to start a new chain, the wallet file and its keys are not affected")
+	ErrWrongMagic         = Error("peer message belongs to another network")
+	ErrRejected           = Error("request rejected by peer")
+	ErrUnexpectedMessage  = Error("unexpected peer message")
+	ErrLegacyDataFile     = Error("found a data file of an older version of hoji in the working directory")
+	ErrObsoleteBlocks     = Error("database holds blocks of a version that is no longer valid, it has to be recreated")
+	ErrInvalidValue       = Error("invalid transaction output value")
+	ErrValueExceedsInputs = Error("transaction pays out more than its inputs")
+	ErrInvalidCommitment  = Error("invalid UTXO commitment, want HEIGHT:BLOCKHASH:UTXOHASH")
+	ErrSpentOutput        = Error("transaction spends an output that is already spent")
 )
 
 // Error represents a Vano error.
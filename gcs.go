@@ -0,0 +1,255 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Golomb-coded set parameters, the same as BIP158's basic filter: P bits of remainder per item and a false positive rate of 1/M
+const (
+	gcsP = 19
+	gcsM = 784931
+)
+
+// GCSFilter is a Golomb-coded set: a compact, probabilistic set of items that can be tested for membership locally. Items are hashed with SipHash-2-4 keyed by key into [0, N*M), sorted, and the differences between consecutive values are Golomb-Rice coded.
+type GCSFilter struct {
+	N    uint32
+	key  [16]byte
+	data []byte
+}
+
+// NewGCSFilter builds a filter over items, duplicates are dropped
+func NewGCSFilter(key [16]byte, items [][]byte) *GCSFilter {
+	unique := make(map[string]bool)
+	var distinct [][]byte
+	for _, item := range items {
+		if !unique[string(item)] {
+			unique[string(item)] = true
+			distinct = append(distinct, item)
+		}
+	}
+
+	f := &GCSFilter{
+		N:   uint32(len(distinct)),
+		key: key,
+	}
+	values := f.hashItems(distinct)
+
+	w := new(bitWriter)
+	var last uint64
+	for _, v := range values {
+		delta := v - last
+		last = v
+
+		for q := delta >> gcsP; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, gcsP)
+	}
+	f.data = w.bytes()
+
+	return f
+}
+
+// BytesToGCSFilter decodes a filter serialized by Bytes. key has to be the key the filter was built with.
+func BytesToGCSFilter(key [16]byte, v []byte) (*GCSFilter, error) {
+	r := bytes.NewReader(v)
+	n, err := readVarInt(r)
+	if err != nil || n > uint64(^uint32(0)) {
+		return nil, ErrMalformedEncoding
+	}
+
+	return &GCSFilter{
+		N:    uint32(n),
+		key:  key,
+		data: v[len(v)-r.Len():],
+	}, nil
+}
+
+// Bytes serializes the filter: varint N | Golomb-Rice coded bit stream
+func (f *GCSFilter) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarInt(&buff, uint64(f.N)); err != nil {
+		return nil, err
+	}
+	if _, err := buff.Write(f.data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// Match reports whether item may be in the set
+func (f *GCSFilter) Match(item []byte) bool {
+	return f.MatchAny([][]byte{item})
+}
+
+// MatchAny reports whether any of items may be in the set. Both sorted lists are walked side by side so the filter is only decoded once.
+func (f *GCSFilter) MatchAny(items [][]byte) bool {
+	if f.N == 0 || len(items) == 0 {
+		return false
+	}
+	targets := f.hashItems(items)
+
+	r := &bitReader{data: f.data}
+	var value uint64
+	for i := uint32(0); i < f.N; i++ {
+		delta, ok := r.readGolombRice()
+		if !ok {
+			return false
+		}
+		value += delta
+
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false
+		}
+		if targets[0] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashItems maps items into [0, N*M) and sorts them
+func (f *GCSFilter) hashItems(items [][]byte) []uint64 {
+	k0 := binary.LittleEndian.Uint64(f.key[:8])
+	k1 := binary.LittleEndian.Uint64(f.key[8:])
+	modulus := uint64(f.N) * gcsM
+
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		// multiply and keep the high word instead of a modulo, it is faster and just as uniform
+		hi, _ := bits.Mul64(sipHash24(k0, k1, item), modulus)
+		values = append(values, hi)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	return values
+}
+
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.nbits%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << (7 - w.nbits%8)
+	}
+	w.nbits++
+}
+
+// writeBits writes the n low bits of v, most significant first
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(v&(1<<(i-1)) != 0)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) readBit() (bool, bool) {
+	if r.pos >= uint(len(r.data))*8 {
+		return false, false
+	}
+	bit := r.data[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+	return bit, true
+}
+
+func (r *bitReader) readGolombRice() (uint64, bool) {
+	var q uint64
+	for {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+
+	v := q << gcsP
+	for i := gcsP - 1; i >= 0; i-- {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if bit {
+			v |= 1 << uint(i)
+		}
+	}
+
+	return v, true
+}
+
+// sipHash24 is SipHash-2-4 with a 64 bit output
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		data = data[8:]
+	}
+
+	var last [8]byte
+	copy(last[:], data)
+	last[7] = byte(length)
+	m := binary.LittleEndian.Uint64(last[:])
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
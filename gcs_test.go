@@ -0,0 +1,91 @@
+package hoji
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSipHash24(t *testing.T) {
+	// the reference vectors of the SipHash paper: key 00 01 .. 0f, message 00 01 .. of each length
+	want := map[int]uint64{
+		0:  0x726fdb47dd0e0e31,
+		1:  0x74f839c593dc67fd,
+		2:  0x0d6c8009d9a94f5a,
+		3:  0x85676696d7fb7e2d,
+		8:  0x93f5f5799a932462,
+		15: 0xa129ca6149be45e5,
+	}
+
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+
+	for length, hash := range want {
+		message := make([]byte, length)
+		for i := range message {
+			message[i] = byte(i)
+		}
+		if got := sipHash24(k0, k1, message); got != hash {
+			t.Errorf("%d byte message: got %#x, want %#x", length, got, hash)
+		}
+	}
+}
+
+func TestGCSFilterBIP158(t *testing.T) {
+	// BIP158 test vector of the testnet genesis block: its basic filter holds the output script of the coinbase and is keyed by the block hash in internal byte order
+	var key [16]byte
+	copy(key[:], mustDecodeHex("43497fd7f826957108f4a30fd9cec3ae"))
+	script := mustDecodeHex("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+
+	filter := NewGCSFilter(key, [][]byte{script})
+	filterBytes, err := filter.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(filterBytes); got != "019dfca8" {
+		t.Fatalf("filter is %s, want 019dfca8", got)
+	}
+
+	decoded, err := BytesToGCSFilter(key, filterBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Match(script) {
+		t.Fatal("decoded filter doesn't match its item")
+	}
+	if decoded.Match([]byte("not in the filter")) {
+		t.Fatal("decoded filter matches an item it doesn't have")
+	}
+}
+
+func TestGCSFilterMatch(t *testing.T) {
+	var key [16]byte
+	var items [][]byte
+	for i := 0; i < 500; i++ {
+		items = append(items, IntToByte(int64(i)))
+	}
+	filter := NewGCSFilter(key, items)
+	if filter.N != 500 {
+		t.Fatalf("filter has %d items, want 500", filter.N)
+	}
+	for _, item := range items {
+		if !filter.Match(item) {
+			t.Fatalf("filter doesn't match %x", item)
+		}
+	}
+
+	falsePositives := 0
+	for i := 500; i < 10500; i++ {
+		if filter.Match(IntToByte(int64(i))) {
+			falsePositives++
+		}
+	}
+	// the false positive rate is 1/gcsM
+	if falsePositives > 1 {
+		t.Fatalf("%d false positives out of 10000", falsePositives)
+	}
+}
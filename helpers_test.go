@@ -0,0 +1,174 @@
+package hoji
+
+import (
+	"testing"
+)
+
+// testChain is a regtest blockchain with a wallet whose first address received the reward of block 1
+type testChain struct {
+	*Blockchain
+	opts    []Option
+	wallets *Wallets
+	miner   []byte
+}
+
+// newTestChain creates a regtest blockchain in a temporary data directory, in the bolt store or in store when it isn't nil
+func newTestChain(t *testing.T, store Store, opts ...Option) *testChain {
+	t.Helper()
+
+	opts = append([]Option{WithNetwork("regtest"), WithDataDir(t.TempDir())}, opts...)
+	if store != nil {
+		opts = append(opts, WithStore(store))
+	}
+
+	wallets, err := NewWallets(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, err := wallets.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateBlockchain(miner, opts...); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testChain{opts: opts, wallets: wallets, miner: miner}
+	c.open(t)
+	return c
+}
+
+// open opens the blockchain, it is closed at the end of the test
+func (c *testChain) open(t *testing.T) {
+	t.Helper()
+
+	bc, err := NewBlockchain(c.opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Blockchain = bc
+	t.Cleanup(func() { c.close(t) })
+}
+
+// close writes the UTXO cache and closes the store, a memory store is left open so the chain can be opened again
+func (c *testChain) close(t *testing.T) {
+	t.Helper()
+
+	if c.Blockchain == nil {
+		return
+	}
+	var err error
+	if newOptions(c.opts).store == nil {
+		err = c.Close()
+	} else {
+		err = c.shutdown()
+	}
+	c.Blockchain = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crash stops the blockchain without writing the UTXO cache, as if the process was killed, and closes the store unless it is a memory store
+func (c *testChain) crash(t *testing.T) {
+	t.Helper()
+
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	var err error
+	if newOptions(c.opts).store == nil {
+		err = c.store.Close()
+	}
+	c.Blockchain = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reopen closes the blockchain and opens it again
+func (c *testChain) reopen(t *testing.T) {
+	t.Helper()
+
+	c.close(t)
+	c.open(t)
+}
+
+// newAddress adds a wallet and returns its address
+func (c *testChain) newAddress(t *testing.T) []byte {
+	t.Helper()
+
+	address, err := c.wallets.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// spend returns a transaction signed by the wallet of from, spending prevOuts into outputs
+func (c *testChain) spend(t *testing.T, from []byte, prevOuts []OutPoint, outputs ...*TxOutput) *Transaction {
+	t.Helper()
+
+	w := c.wallets.GetWallet(string(from))
+	tx := &Transaction{Version: txVersion, Outputs: outputs}
+	for _, prevOut := range prevOuts {
+		tx.Inputs = append(tx.Inputs, &TxInput{PrevOut: prevOut, PubKey: w.PublicKey})
+	}
+	if err := c.SignTx(tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	id, err := tx.hashTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.ID = id
+	return tx
+}
+
+// mine mines a block holding txs, its reward goes to the miner
+func (c *testChain) mine(t *testing.T, txs ...*Transaction) *Block {
+	t.Helper()
+
+	coinbase, err := c.NewCoinbaseTx(c.miner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := c.MineBlock(append(txs, coinbase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// spendable returns the unspent outputs of address
+func (c *testChain) spendable(t *testing.T, address []byte) []*SpendableOutput {
+	t.Helper()
+
+	outs, err := (&UTXOSet{Bc: c.Blockchain}).FindSpendableOutputs(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outs
+}
+
+// newBlock mines a block holding txs and a coinbase on top of the tip without checking or connecting it
+func (c *testChain) newBlock(t *testing.T, txs ...*Transaction) *Block {
+	t.Helper()
+
+	coinbase, err := c.NewCoinbaseTx(c.miner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tip *BlockHeader
+	if err := c.view(func(tx StoreTx) error {
+		tip, err = tx.Header(tx.Tip())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(append(txs, coinbase), tip.Hash, tip.Bits)
+	block.Height = tip.Height + 1
+	return block
+}
@@ -0,0 +1,245 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+)
+
+const (
+	lightDBFile   = "hoji-light.db"
+	headersBucket = "headers"
+)
+
+// ProofSource is what a light client needs from a full node. Peer implements it over the peer protocol, Blockchain directly.
+type ProofSource interface {
+	// Headers returns the headers of the blocks following the block with hash after, oldest first. An empty after asks for every header starting with the genesis block.
+	Headers(after []byte) ([]*BlockHeader, error)
+	// TxProofs returns inclusion proofs for every transaction with an output locked to pubKeyHash or an input signed by a key hashing to it.
+	TxProofs(pubKeyHash []byte) ([]*TxProof, error)
+}
+
+// LightClient is an SPV client: it only stores block headers, validates their proof of work chain, and only believes transactions that come with a merkle proof against one of its headers.
+type LightClient struct {
+	store  Store
+	source ProofSource
+	params *ChainParams
+	log    *slog.Logger
+}
+
+// LightUTXO is an unspent output found by a light client
+type LightUTXO struct {
+	OutPoint      OutPoint
+	Value         int
+	Confirmations int
+}
+
+// LightBalance is the balance of an address as seen by a light client
+type LightBalance struct {
+	Balance int
+	UTXOs   []*LightUTXO
+}
+
+// NewLightClient opens the light client header store of the configured network
+func NewLightClient(source ProofSource, opts ...Option) (*LightClient, error) {
+	o := newOptions(opts)
+	params, err := o.chainParams()
+	if err != nil {
+		return nil, err
+	}
+	path, err := o.path(lightDBFile)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewBoltStore(path, o.openTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LightClient{store, source, params, o.loggers().net}, nil
+}
+
+// Close closes the header store
+func (lc *LightClient) Close() error {
+	return lc.store.Close()
+}
+
+// Sync downloads the headers following the stored tip, checks that each one links to the previous one and carries a valid proof of work at the difficulty the network requires, and stores them. It returns the number of new headers.
+func (lc *LightClient) Sync() (int, error) {
+	tip, height, err := lc.Tip()
+	if err != nil {
+		return 0, err
+	}
+
+	headers, err := lc.source.Headers(tip)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := lc.store.Update(func(tx StoreTx) error {
+		for _, header := range headers {
+			if !bytes.Equal(header.PrevBlockHash, tip) {
+				return ErrHeaderNotConnected
+			}
+			if len(tip) == 0 && !bytes.Equal(header.Hash, lc.params.GenesisHash) {
+				return ErrGenesisMismatch
+			}
+			bits, err := lc.nextBits(tx, tip, height)
+			if err != nil {
+				return err
+			}
+			if header.Bits != bits || !ValidateHeader(header) {
+				return ErrInvalidHeader
+			}
+
+			height++
+			headerBytes, err := header.Bytes()
+			if err != nil {
+				return err
+			}
+			if err := tx.Put([]byte(headersBucket), header.Hash, append(IntToByte(height), headerBytes...)); err != nil {
+				return err
+			}
+			tip = header.Hash
+		}
+
+		return tx.Put([]byte(headersBucket), []byte(lastHashKey), tip)
+	}); err != nil {
+		return 0, err
+	}
+
+	if len(headers) > 0 {
+		lc.log.Info("headers synced", "headers", len(headers), "height", height, hashAttr("tip", tip))
+	}
+	return len(headers), nil
+}
+
+// Tip returns the hash and height of the last stored header. The genesis block has height 1, an empty store returns an empty hash and height 0.
+func (lc *LightClient) Tip() ([]byte, int64, error) {
+	var tip []byte
+	var height int64
+
+	err := lc.store.View(func(tx StoreTx) error {
+		tip = append([]byte{}, tx.Get([]byte(headersBucket), []byte(lastHashKey))...)
+		if len(tip) == 0 {
+			return nil
+		}
+
+		_, h, err := decodeStoredHeader(tx.Get([]byte(headersBucket), tip))
+		height = h
+		return err
+	})
+
+	return tip, height, err
+}
+
+// Balance asks the source for the transactions relevant to address, keeps the ones whose merkle proof checks out against a stored header and returns the outputs that none of them spend, with their number of confirmations.
+func (lc *LightClient) Balance(address []byte) (*LightBalance, error) {
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+
+	proofs, err := lc.source.TxProofs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	_, tipHeight, err := lc.Tip()
+	if err != nil {
+		return nil, err
+	}
+
+	var unspent []*LightUTXO
+	var spent []OutPoint
+
+	if err := lc.store.View(func(tx StoreTx) error {
+		for _, proof := range proofs {
+			stored := tx.Get([]byte(headersBucket), proof.Header.Hash)
+			if stored == nil {
+				// the block isn't part of the header chain we validated
+				continue
+			}
+			header, height, err := decodeStoredHeader(stored)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(header.MerkleRoot, proof.Header.MerkleRoot) {
+				continue
+			}
+			ok, err := proof.Verify()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			for i, out := range proof.Tx.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					unspent = append(unspent, &LightUTXO{
+						OutPoint:      NewOutPoint(proof.Tx.ID, i),
+						Value:         out.Value,
+						Confirmations: int(tipHeight - height + 1),
+					})
+				}
+			}
+			if !proof.Tx.IsCoinbase() {
+				for _, in := range proof.Tx.Inputs {
+					spent = append(spent, in.PrevOut)
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	balance := new(LightBalance)
+Outputs:
+	for _, utxo := range unspent {
+		for _, s := range spent {
+			if s.Equal(utxo.OutPoint) {
+				continue Outputs
+			}
+		}
+		balance.Balance += utxo.Value
+		balance.UTXOs = append(balance.UTXOs, utxo)
+	}
+
+	return balance, nil
+}
+
+// nextBits returns the difficulty of the header following the stored header prevHash at height prevHeight
+func (lc *LightClient) nextBits(tx StoreTx, prevHash []byte, prevHeight int64) (uint32, error) {
+	if len(prevHash) == 0 {
+		return lc.params.GenesisBits, nil
+	}
+	prev, _, err := decodeStoredHeader(tx.Get([]byte(headersBucket), prevHash))
+	if err != nil {
+		return 0, err
+	}
+
+	// the light client counts heights from 1, the chain params from 0
+	return lc.params.nextBits(prev.Bits, prevHeight-1, prev.Timestamp, func() (int64, error) {
+		header := prev
+		for i := int64(1); i < lc.params.RetargetInterval; i++ {
+			var err error
+			if header, _, err = decodeStoredHeader(tx.Get([]byte(headersBucket), header.PrevBlockHash)); err != nil {
+				return 0, err
+			}
+		}
+		return header.Timestamp, nil
+	})
+}
+
+func decodeStoredHeader(v []byte) (*BlockHeader, int64, error) {
+	if len(v) < 8 {
+		return nil, 0, ErrMalformedEncoding
+	}
+	header, err := BytesToBlockHeader(v[8:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return header, int64(binary.BigEndian.Uint64(v[:8])), nil
+}
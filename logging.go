@@ -0,0 +1,146 @@
+package hoji
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Logging subsystems, each one can be given its own level with WithLogLevel
+const (
+	// LogChain covers blocks added to or removed from the chain, schema migrations and pruning
+	LogChain = "chain"
+	// LogPoW covers mining
+	LogPoW = "pow"
+	// LogUTXO covers the UTXO set, its cache and snapshots
+	LogUTXO = "utxo"
+	// LogWallet covers the wallet file
+	LogWallet = "wallet"
+	// LogNet covers what is exchanged with other nodes, such as light client syncs
+	LogNet = "net"
+)
+
+// LogSubsystems lists every logging subsystem
+var LogSubsystems = []string{LogChain, LogPoW, LogUTXO, LogWallet, LogNet}
+
+// WithLogger sends the library's logs to logger, every record carries a "subsystem" attribute. Without it nothing is logged: the library never writes to stdout or stderr on its own.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithLogLevel sets the minimum level of the records of subsystem, overriding the level of the logger's handler. An empty subsystem sets the level of all of them.
+func WithLogLevel(subsystem string, level slog.Leveler) Option {
+	return func(o *options) {
+		if o.logLevels == nil {
+			o.logLevels = make(map[string]slog.Leveler)
+		}
+		if subsystem == "" {
+			for _, s := range LogSubsystems {
+				o.logLevels[s] = level
+			}
+			return
+		}
+		o.logLevels[subsystem] = level
+	}
+}
+
+// ParseLogLevels turns a comma separated list of levels into options. A bare level, such as "debug", applies to every subsystem, "subsystem=level" to one of them: "warn,pow=info" logs warnings and the mined blocks.
+func ParseLogLevels(spec string) ([]Option, error) {
+	var opts []Option
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		subsystem, name, ok := strings.Cut(item, "=")
+		if !ok {
+			subsystem, name = "", item
+		} else if !knownSubsystem(subsystem) {
+			return nil, fmt.Errorf("%w: unknown subsystem %q", ErrInvalidLogLevel, subsystem)
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLogLevel, name)
+		}
+		opts = append(opts, WithLogLevel(subsystem, level))
+	}
+	return opts, nil
+}
+
+func knownSubsystem(subsystem string) bool {
+	for _, s := range LogSubsystems {
+		if s == subsystem {
+			return true
+		}
+	}
+	return false
+}
+
+// loggers holds a logger per subsystem
+type loggers struct {
+	chain, pow, utxo, wallet, net *slog.Logger
+}
+
+func (o *options) loggers() *loggers {
+	return &loggers{
+		chain:  o.subsystemLogger(LogChain),
+		pow:    o.subsystemLogger(LogPoW),
+		utxo:   o.subsystemLogger(LogUTXO),
+		wallet: o.subsystemLogger(LogWallet),
+		net:    o.subsystemLogger(LogNet),
+	}
+}
+
+// subsystemLogger returns the logger of subsystem, one dropping every record when no logger was configured
+func (o *options) subsystemLogger(subsystem string) *slog.Logger {
+	if o.logger == nil {
+		return slog.New(discardHandler{})
+	}
+
+	handler := o.logger.Handler()
+	if level, ok := o.logLevels[subsystem]; ok {
+		handler = &levelHandler{handler, level}
+	}
+	return slog.New(handler).With("subsystem", subsystem)
+}
+
+// levelHandler filters records by its own level instead of the one of the handler it wraps
+type levelHandler struct {
+	handler slog.Handler
+	level   slog.Leveler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.handler.WithAttrs(attrs), h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.handler.WithGroup(name), h.level}
+}
+
+// discardHandler drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// hashAttr logs a hash in hex
+func hashAttr(key string, hash []byte) slog.Attr {
+	return slog.String(key, hex.EncodeToString(hash))
+}
@@ -0,0 +1,75 @@
+package hoji
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	for _, test := range []struct {
+		spec   string
+		levels map[string]slog.Level
+		err    bool
+	}{
+		{spec: "", levels: map[string]slog.Level{}},
+		{spec: "debug", levels: map[string]slog.Level{LogChain: slog.LevelDebug, LogPoW: slog.LevelDebug, LogUTXO: slog.LevelDebug, LogWallet: slog.LevelDebug, LogNet: slog.LevelDebug}},
+		{spec: "pow=info", levels: map[string]slog.Level{LogPoW: slog.LevelInfo}},
+		{spec: "warn, pow=info", levels: map[string]slog.Level{LogChain: slog.LevelWarn, LogPoW: slog.LevelInfo, LogUTXO: slog.LevelWarn, LogWallet: slog.LevelWarn, LogNet: slog.LevelWarn}},
+		// later items override earlier ones
+		{spec: "net=error,debug", levels: map[string]slog.Level{LogChain: slog.LevelDebug, LogPoW: slog.LevelDebug, LogUTXO: slog.LevelDebug, LogWallet: slog.LevelDebug, LogNet: slog.LevelDebug}},
+		{spec: "utxo=debug+2,,wallet=ERROR", levels: map[string]slog.Level{LogUTXO: slog.LevelDebug + 2, LogWallet: slog.LevelError}},
+		{spec: "mempool=debug", err: true},
+		{spec: "=debug", err: true},
+		{spec: "verbose", err: true},
+		{spec: "chain=", err: true},
+	} {
+		opts, err := ParseLogLevels(test.spec)
+		if test.err {
+			if !errors.Is(err, ErrInvalidLogLevel) {
+				t.Errorf("%q: got %v, want ErrInvalidLogLevel", test.spec, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+
+		o := newOptions(opts)
+		if len(o.logLevels) != len(test.levels) {
+			t.Errorf("%q: sets the level of %d subsystems, want %d", test.spec, len(o.logLevels), len(test.levels))
+			continue
+		}
+		for subsystem, level := range test.levels {
+			if got, ok := o.logLevels[subsystem]; !ok || got.Level() != level {
+				t.Errorf("%q: %s level is %v, want %v", test.spec, subsystem, got, level)
+			}
+		}
+	}
+}
+
+func TestLogLevelFilter(t *testing.T) {
+	var out bytes.Buffer
+	// the handler only lets errors through, the subsystems given a level of their own get what it allows
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelError}))
+	c := newTestChain(t, nil, WithLogger(logger), WithLogLevel(LogPoW, slog.LevelDebug), WithLogLevel(LogChain, slog.LevelWarn))
+	c.mine(t)
+	c.log.utxo.Warn("utxo warning")
+	c.log.chain.Warn("chain warning")
+	c.log.chain.Info("chain info")
+
+	logs := out.String()
+	for _, want := range []string{`msg="mining block" subsystem=pow`, `msg="block mined" subsystem=pow`, `msg="chain warning" subsystem=chain`} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("%s is missing from the logs:\n%s", want, logs)
+		}
+	}
+	for _, unwanted := range []string{"block connected", "chain info", "utxo warning"} {
+		if strings.Contains(logs, unwanted) {
+			t.Errorf("%q was logged:\n%s", unwanted, logs)
+		}
+	}
+}
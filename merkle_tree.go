@@ -1,10 +1,22 @@
 package hoji
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+)
 
-// MerkleTree represent a Merkle tree
+// Leaves and internal nodes are hashed with different prefixes so an internal node can never be passed off as a leaf (second preimage attack)
+const (
+	merkleLeafPrefix = byte(0x00)
+	merkleNodePrefix = byte(0x01)
+)
+
+// MerkleTree represent a Merkle tree. A level with an odd number of nodes promotes its last node to the next level unchanged instead of duplicating it, so every leaf count produces a distinct tree.
 type MerkleTree struct {
 	RootNode *MerkleNode
+
+	// levels holds every level of the tree from the leaves up to the root
+	levels [][]*MerkleNode
 }
 
 // MerkleNode represent a Merkle tree node
@@ -14,46 +26,52 @@ type MerkleNode struct {
 	Data  []byte
 }
 
+// MerkleProof is the sibling path from a leaf up to the root. Index is the position of the leaf and LeafCount the number of leaves in the tree, together they tell on which side each sibling goes and at which levels the node was promoted without a sibling.
+type MerkleProof struct {
+	Index     int
+	LeafCount int
+	Siblings  [][]byte
+}
+
 // NewMerkleTree creates a new Merkle tree from a sequence of data
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+	if len(data) == 0 {
+		hash := sha256.Sum256(nil)
+		return &MerkleTree{RootNode: &MerkleNode{Data: hash[:]}}
 	}
 
+	var nodes []*MerkleNode
 	for _, datum := range data {
-		node := NewMerkleNode(nil, nil, datum)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, NewMerkleNode(nil, nil, datum))
 	}
+	levels := [][]*MerkleNode{nodes}
 
-	for i := 0; i < len(data)/2; i++ {
-		var newLevel []MerkleNode
+	for len(nodes) > 1 {
+		var newLevel []*MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			newLevel = append(newLevel, *node)
+			if j+1 == len(nodes) {
+				newLevel = append(newLevel, nodes[j])
+				continue
+			}
+			newLevel = append(newLevel, NewMerkleNode(nodes[j], nodes[j+1], nil))
 		}
 
 		nodes = newLevel
+		levels = append(levels, nodes)
 	}
 
-	mTree := MerkleTree{&nodes[0]}
-
-	return &mTree
+	return &MerkleTree{nodes[0], levels}
 }
 
-// NewMerkleNode creates a new Merkle tree node
+// NewMerkleNode creates a new Merkle tree node. Leaves hash 0x00 || data and internal nodes hash 0x01 || left || right.
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	mNode := MerkleNode{}
 
 	if left == nil && right == nil {
-		hash := sha256.Sum256(data)
-		mNode.Data = hash[:]
+		mNode.Data = hashMerkleLeaf(data)
 	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash := sha256.Sum256(prevHashes)
-		mNode.Data = hash[:]
+		mNode.Data = hashMerkleNodes(left.Data, right.Data)
 	}
 
 	mNode.Left = left
@@ -61,3 +79,72 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 	return &mNode
 }
+
+// Proof returns the merkle proof of the leaf at index
+func (m *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if len(m.levels) == 0 || index < 0 || index >= len(m.levels[0]) {
+		return nil, ErrNotFound
+	}
+
+	proof := &MerkleProof{
+		Index:     index,
+		LeafCount: len(m.levels[0]),
+	}
+	for _, level := range m.levels[:len(m.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sibling].Data)
+		}
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that leaf, the data the tree was built from, is part of the tree with the given root
+func VerifyMerkleProof(leaf []byte, proof *MerkleProof, root []byte) bool {
+	if proof.Index < 0 || proof.Index >= proof.LeafCount {
+		return false
+	}
+
+	hash := hashMerkleLeaf(leaf)
+	index, size := proof.Index, proof.LeafCount
+	siblings := proof.Siblings
+
+	for size > 1 {
+		switch {
+		case index%2 == 1:
+			if len(siblings) == 0 {
+				return false
+			}
+			hash = hashMerkleNodes(siblings[0], hash)
+			siblings = siblings[1:]
+		case index+1 < size:
+			if len(siblings) == 0 {
+				return false
+			}
+			hash = hashMerkleNodes(hash, siblings[0])
+			siblings = siblings[1:]
+		}
+		// otherwise the node is the odd one out and gets promoted as is
+
+		index /= 2
+		size = (size + 1) / 2
+	}
+
+	return len(siblings) == 0 && bytes.Equal(hash, root)
+}
+
+func hashMerkleLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return hash[:]
+}
+
+func hashMerkleNodes(left, right []byte) []byte {
+	prevHashes := make([]byte, 0, 1+len(left)+len(right))
+	prevHashes = append(prevHashes, merkleNodePrefix)
+	prevHashes = append(prevHashes, left...)
+	prevHashes = append(prevHashes, right...)
+
+	hash := sha256.Sum256(prevHashes)
+	return hash[:]
+}
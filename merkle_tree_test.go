@@ -0,0 +1,85 @@
+package hoji
+
+import (
+	"bytes"
+	"testing"
+)
+
+// merkleRoot computes the root level by level without building a tree, promoting the last node of an odd level
+func merkleRoot(data [][]byte) []byte {
+	var level [][]byte
+	for _, datum := range data {
+		level = append(level, hashMerkleLeaf(datum))
+	}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, hashMerkleNodes(level[i], level[i+1]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func merkleLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = IntToByte(int64(i))
+	}
+	return leaves
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 1000; n++ {
+		leaves := merkleLeaves(n)
+		tree := NewMerkleTree(leaves)
+		root := tree.RootNode.Data
+		if !bytes.Equal(root, merkleRoot(leaves)) {
+			t.Fatalf("%d leaves: wrong root", n)
+		}
+
+		for i, leaf := range leaves {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("%d leaves: proof of leaf %d: %v", n, i, err)
+			}
+			if proof.Index != i || proof.LeafCount != n {
+				t.Fatalf("%d leaves: proof of leaf %d has index %d and leaf count %d", n, i, proof.Index, proof.LeafCount)
+			}
+			if !VerifyMerkleProof(leaf, proof, root) {
+				t.Fatalf("%d leaves: proof of leaf %d doesn't verify", n, i)
+			}
+			if VerifyMerkleProof(IntToByte(int64(n)), proof, root) {
+				t.Fatalf("%d leaves: proof of leaf %d verifies another leaf", n, i)
+			}
+			if n > 1 {
+				moved := *proof
+				moved.Index = (i + 1) % n
+				if VerifyMerkleProof(leaf, &moved, root) {
+					t.Fatalf("%d leaves: proof of leaf %d verifies at index %d", n, i, moved.Index)
+				}
+			}
+		}
+
+		for _, i := range []int{-1, n} {
+			if _, err := tree.Proof(i); err != ErrNotFound {
+				t.Fatalf("%d leaves: proof of leaf %d: got %v, want ErrNotFound", n, i, err)
+			}
+		}
+	}
+}
+
+func TestMerkleRootDuplicateLeaf(t *testing.T) {
+	// duplicating the last leaf used to give the same root, so a block could be passed off with a transaction twice
+	for n := 1; n <= 1000; n++ {
+		leaves := merkleLeaves(n)
+		duplicated := append(leaves, leaves[n-1])
+		if bytes.Equal(NewMerkleTree(leaves).RootNode.Data, NewMerkleTree(duplicated).RootNode.Data) {
+			t.Fatalf("%d leaves: duplicating the last leaf keeps the root", n)
+		}
+	}
+}
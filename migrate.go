@@ -0,0 +1,213 @@
+package hoji
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	// metaBucket describes the database itself: the version of its schema and the network of its chain
+	metaBucket       = "meta"
+	schemaVersionKey = "version"
+	networkKey       = "network"
+)
+
+// SchemaVersion is the version of the database layout written by this code. Older databases are upgraded by running the migrations after their version when they are opened, newer ones are refused with ErrNewerSchema.
+const SchemaVersion = 5
+
+// migration upgrades a database from the previous schema version to version. It runs in the same store transaction as the version bump, so an interrupted migration leaves the database at the previous version. rebuildUTXO reports a UTXO set that has to be rebuilt from the blocks afterwards.
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx StoreTx) (rebuildUTXO bool, err error)
+}
+
+// migrations lists every change of the database layout in order, the last one brings a database to SchemaVersion. Version 1, the switch from gob to the wire format, has no migration: the transaction IDs and block hashes of a gob database can't be carried over, NewBlockchain refuses it with ErrLegacyDatabase, which tells the user to start a new chain. The wallet file is still readable so no key is lost, only the coins of the old chain.
+var migrations = []migration{
+	{2, "index the height, header and filter of every block", indexBlocks},
+	{3, "store the UTXO set per output with an address index", migrateUTXOKeys},
+	{4, "move the state of the chain out of the blocks bucket", migrateMetaKeys},
+	{5, "index the chain by height", indexMainChain},
+}
+
+// metaKeys are the keys migrateMetaKeys moves from the blocks bucket, which now only holds blocks and the tip, to the meta bucket
+var metaKeys = []string{pruneHeightKey, snapshotBaseKey, utxoTipKey, addrHistoryTipKey}
+
+// migrateSchema brings the store to SchemaVersion one migration at a time. A database that can't be opened, because it is too old or too new or belongs to another network, is refused before anything is written to it. rebuildUTXO reports that a migration left a UTXO set to rebuild.
+func migrateSchema(store Store, params *ChainParams, log *slog.Logger) (rebuildUTXO bool, err error) {
+	var version int
+	var recorded bool
+	if err := store.View(func(tx StoreTx) error {
+		version, recorded, err = schemaVersion(tx)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	if version > SchemaVersion {
+		return false, fmt.Errorf("%w: version %d, this code supports up to version %d", ErrNewerSchema, version, SchemaVersion)
+	}
+	if version < 1 {
+		return false, ErrLegacyDatabase
+	}
+	if err := store.View(checkBlockVersion); err != nil {
+		return false, err
+	}
+	if err := store.View(func(tx StoreTx) error {
+		return checkCompatible(tx, params)
+	}); err != nil {
+		return false, err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		log.Info("migrating database schema", "version", m.version, "migration", m.description)
+		if err := store.Update(func(tx StoreTx) error {
+			rebuild, err := m.migrate(tx)
+			if err != nil {
+				return fmt.Errorf("schema migration to version %d, %s: %w", m.version, m.description, err)
+			}
+			rebuildUTXO = rebuildUTXO || rebuild
+			return putSchemaVersion(tx, m.version)
+		}); err != nil {
+			return false, err
+		}
+		recorded = true
+	}
+
+	if !recorded {
+		err = store.Update(func(tx StoreTx) error {
+			return putSchemaVersion(tx, SchemaVersion)
+		})
+	}
+	return rebuildUTXO, err
+}
+
+// schemaVersion returns the schema version of the database, recorded is false for an empty database or one written before the meta bucket existed. The version of the latter is told by the markers that used to flag each change of the layout.
+func schemaVersion(tx StoreTx) (version int, recorded bool, err error) {
+	if v := tx.Get([]byte(metaBucket), []byte(schemaVersionKey)); v != nil {
+		if len(v) != 8 {
+			return 0, false, ErrMalformedEncoding
+		}
+		return int(binary.BigEndian.Uint64(v)), true, nil
+	}
+
+	switch {
+	case tx.Tip() == nil:
+		return SchemaVersion, false, nil
+	case tx.Get([]byte(blocksBucket), []byte(encodingKey)) == nil:
+		return 0, false, nil
+	case tx.Get([]byte(blocksBucket), []byte(utxoFormatKey)) == nil:
+		return 1, false, nil
+	default:
+		return 3, false, nil
+	}
+}
+
+// checkBlockVersion refuses a chain whose tip is older than minBlockVersion before anything is migrated. Such blocks were hashed over a merkle root that duplicated the last transaction of an odd level, the tree built now gives them another root and they would all fail their proof of work.
+func checkBlockVersion(tx StoreTx) error {
+	tip := tx.Tip()
+	if tip == nil {
+		return nil
+	}
+	var version uint32
+	if v := tx.Get([]byte(blocksBucket), tip); v != nil {
+		block, err := BytesToBlock(v)
+		if err != nil {
+			return err
+		}
+		version = block.Version
+	} else {
+		header, err := tx.Header(tip)
+		if err != nil {
+			return err
+		}
+		version = header.Version
+	}
+	if version < minBlockVersion {
+		return fmt.Errorf("%w: the tip is a version %d block", ErrObsoleteBlocks, version)
+	}
+	return nil
+}
+
+// checkCompatible makes sure a database of any schema version holds a chain of the network: the network it recorded is the same and it has the network's genesis block. The blocks are stored under their hash in every version.
+func checkCompatible(tx StoreTx, params *ChainParams) error {
+	if tx.Tip() == nil {
+		return nil
+	}
+	if network := tx.Get([]byte(metaBucket), []byte(networkKey)); network != nil && string(network) != params.Name {
+		return fmt.Errorf("%w: it is a %s database", ErrNetworkMismatch, network)
+	}
+	if tx.Get([]byte(blocksBucket), params.GenesisHash) == nil {
+		return ErrGenesisMismatch
+	}
+	return nil
+}
+
+// putSchemaVersion records the schema version, replacing the markers older databases used
+func putSchemaVersion(tx StoreTx, version int) error {
+	for _, key := range []string{encodingKey, utxoFormatKey} {
+		if err := tx.Delete([]byte(blocksBucket), []byte(key)); err != nil {
+			return err
+		}
+	}
+	return tx.Put([]byte(metaBucket), []byte(schemaVersionKey), IntToByte(int64(version)))
+}
+
+// encodingKey marked, inside the blocks bucket, a database whose values use the wire format from encoding.go, schema version 1. Databases without it were written with encoding/gob.
+const encodingKey = "e"
+
+// utxoFormatKey marked, inside the blocks bucket, a database whose chainstate stores one key per output and has an address index, schema version 3. Databases without it stored all the outputs of a transaction under its ID.
+const utxoFormatKey = "c"
+
+// migrateMetaKeys moves the keys describing the state of the chain to the meta bucket, so the blocks bucket is keyed by block hash only besides the tip
+func migrateMetaKeys(tx StoreTx) (rebuildUTXO bool, err error) {
+	for _, key := range metaKeys {
+		v := tx.Get([]byte(blocksBucket), []byte(key))
+		if v == nil {
+			continue
+		}
+		if err := tx.Put([]byte(metaBucket), []byte(key), append([]byte{}, v...)); err != nil {
+			return false, err
+		}
+		if err := tx.Delete([]byte(blocksBucket), []byte(key)); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// indexMainChain builds the height index of the chain leading to the tip, setting the tip again fills it
+func indexMainChain(tx StoreTx) (rebuildUTXO bool, err error) {
+	if tip := tx.Tip(); tip != nil {
+		return false, tx.SetTip(tip)
+	}
+	return false, nil
+}
+
+// migrateUTXOKeys rewrites a chainstate keyed by transaction ID into one key per output and builds the address index
+func migrateUTXOKeys(tx StoreTx) (rebuildUTXO bool, err error) {
+	utxo := make(map[string]*TxOutputs)
+	if err := tx.ForEach([]byte(utxoBucket), func(k, v []byte) error {
+		outs, err := BytesToOutputs(v)
+		if err != nil {
+			return err
+		}
+		utxo[string(k)] = outs
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	if err := tx.ResetUTXO(); err != nil {
+		return false, err
+	}
+	for txID, outs := range utxo {
+		if err := tx.PutUTXO([]byte(txID), outs); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,191 @@
+package hoji
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestLegacyDatabaseRefused(t *testing.T) {
+	store := NewMemoryStore()
+	legacyBlock := []byte("gob encoded block")
+	if err := store.Update(func(tx StoreTx) error {
+		if err := tx.Put([]byte(blocksBucket), []byte("hash"), legacyBlock); err != nil {
+			return err
+		}
+		return tx.Put([]byte(blocksBucket), []byte(lastHashKey), []byte("hash"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewBlockchain(WithStore(store), WithNetwork("regtest"), WithDataDir(t.TempDir()))
+	if !errors.Is(err, ErrLegacyDatabase) {
+		t.Fatalf("got %v, want ErrLegacyDatabase", err)
+	}
+
+	if err := store.View(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Get([]byte(blocksBucket), []byte("hash")), legacyBlock) {
+			t.Error("legacy block was rewritten")
+		}
+		if tx.Get([]byte(metaBucket), []byte(schemaVersionKey)) != nil {
+			t.Error("legacy database got a schema version")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObsoleteBlocksRefused(t *testing.T) {
+	store := NewMemoryStore()
+	block := &Block{
+		Version:      1,
+		Timestamp:    1,
+		Bits:         legacyTargetBits,
+		Hash:         []byte("version 1 block"),
+		Transactions: []*Transaction{{Version: txVersion, Inputs: []*TxInput{{PrevOut: NewOutPoint(nil, -1)}}, Outputs: []*TxOutput{{Value: 10}}}},
+	}
+	if err := store.Update(func(tx StoreTx) error {
+		if err := tx.PutBlock(block); err != nil {
+			return err
+		}
+		if err := tx.Put([]byte(blocksBucket), []byte(encodingKey), []byte{}); err != nil {
+			return err
+		}
+		return tx.SetTip(block.Hash)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewBlockchain(WithStore(store), WithNetwork("regtest"), WithDataDir(t.TempDir()))
+	if !errors.Is(err, ErrObsoleteBlocks) {
+		t.Fatalf("got %v, want ErrObsoleteBlocks", err)
+	}
+	if err := store.View(func(tx StoreTx) error {
+		if tx.Get([]byte(metaBucket), []byte(schemaVersionKey)) != nil {
+			t.Error("obsolete database was migrated")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// downgradeMetaKeys puts a store back to schema version 3, which kept the state of the chain in the blocks bucket
+func downgradeMetaKeys(t *testing.T, store Store) {
+	t.Helper()
+
+	if err := store.Update(func(tx StoreTx) error {
+		for _, key := range metaKeys {
+			v := tx.Get([]byte(metaBucket), []byte(key))
+			if v == nil {
+				continue
+			}
+			if err := tx.Put([]byte(blocksBucket), []byte(key), append([]byte{}, v...)); err != nil {
+				return err
+			}
+			if err := tx.Delete([]byte(metaBucket), []byte(key)); err != nil {
+				return err
+			}
+		}
+		return putSchemaVersion(tx, 3)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetaKeysMigration(t *testing.T) {
+	store := NewMemoryStore()
+	c := newTestChain(t, store, WithAddressIndex(true))
+	c.mine(t)
+	c.close(t)
+	downgradeMetaKeys(t, store)
+
+	c.open(t)
+	if err := store.View(func(tx StoreTx) error {
+		if version, _, err := schemaVersion(tx); err != nil || version != SchemaVersion {
+			t.Errorf("schema version %d (%v), want %d", version, err, SchemaVersion)
+		}
+		for _, key := range []string{utxoTipKey, addrHistoryTipKey} {
+			if tx.Get([]byte(blocksBucket), []byte(key)) != nil || tx.Get([]byte(metaBucket), []byte(key)) == nil {
+				t.Errorf("key %q wasn't moved to the meta bucket", key)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyChain(VerifyUTXO, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMainChainIndexMigration(t *testing.T) {
+	store := NewMemoryStore()
+	c := newTestChain(t, store)
+	block := c.mine(t)
+	c.close(t)
+	if err := store.Update(func(tx StoreTx) error {
+		if err := tx.DeleteBucket([]byte(mainChainBucket)); err != nil {
+			return err
+		}
+		return putSchemaVersion(tx, 4)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.open(t)
+	if err := store.View(func(tx StoreTx) error {
+		for height, want := range [][]byte{RegTestParams.GenesisHash, block.PrevBlockHash, block.Hash} {
+			if hash, err := tx.MainChainHash(int64(height)); err != nil || !bytes.Equal(hash, want) {
+				t.Errorf("height %d is indexed as %x (%v), want %x", height, hash, err, want)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncompatibleDatabaseNotMigrated(t *testing.T) {
+	store := NewMemoryStore()
+	c := newTestChain(t, store)
+	c.close(t)
+	downgradeMetaKeys(t, store)
+
+	for _, test := range []struct {
+		name string
+		// unrecorded drops the network from the meta bucket, like databases written before it was recorded
+		unrecorded bool
+		want       error
+	}{
+		{"recorded network", false, ErrNetworkMismatch},
+		{"unrecorded network", true, ErrGenesisMismatch},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.unrecorded {
+				if err := store.Update(func(tx StoreTx) error {
+					return tx.Delete([]byte(metaBucket), []byte(networkKey))
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := NewBlockchain(WithStore(store), WithNetwork("testnet"), WithDataDir(t.TempDir()))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+			if err := store.View(func(tx StoreTx) error {
+				if version, _, err := schemaVersion(tx); err != nil || version != 3 {
+					t.Errorf("schema version %d (%v), want 3", version, err)
+				}
+				if tx.Get([]byte(blocksBucket), []byte(utxoTipKey)) == nil {
+					t.Error("database of another network was migrated")
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// OutPoint references a single transaction output: the ID of the transaction that created it and the output's index in that transaction's Outputs. Coinbase inputs don't spend anything and use an empty TxID with index -1.
+type OutPoint struct {
+	TxID  []byte
+	Index int
+}
+
+// NewOutPoint creates a reference to output index of transaction txID
+func NewOutPoint(txID []byte, index int) OutPoint {
+	return OutPoint{
+		TxID:  txID,
+		Index: index,
+	}
+}
+
+// IsNull reports whether the outpoint is the null reference used by coinbase inputs
+func (o OutPoint) IsNull() bool {
+	return len(o.TxID) == 0 && o.Index == -1
+}
+
+// Equal reports whether both outpoints reference the same output
+func (o OutPoint) Equal(other OutPoint) bool {
+	return o.Index == other.Index && bytes.Equal(o.TxID, other.TxID)
+}
+
+// String formats the outpoint as txid:index
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(o.TxID), o.Index)
+}
+
+// Bytes transforms an outpoint into its wire format
+func (o OutPoint) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeOutPoint(&buff, o); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToOutPoint deserializes an OutPoint
+func BytesToOutPoint(data []byte) (OutPoint, error) {
+	var o OutPoint
+	err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		o, err = readOutPoint(r)
+		return err
+	})
+	return o, err
+}
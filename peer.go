@@ -0,0 +1,639 @@
+package hoji
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Peer message commands for light clients
+const (
+	CmdGetHeaders  = "getheaders"
+	CmdHeaders     = "headers"
+	CmdGetTxProofs = "gettxproofs"
+	CmdTxProofs    = "txproofs"
+	CmdReject      = "reject"
+	// CmdAck answers the requests that have no reply of their own, such as filterload
+	CmdAck = "ack"
+)
+
+const (
+	// commandSize is the size of the zero padded command of a message header
+	commandSize = 12
+	// maxMessageSize bounds the payload of a peer message
+	maxMessageSize = 32 << 20
+	// peerTimeout bounds how long a peer waits for a connection or a reply, and how long the server waits for the next request of a peer
+	peerTimeout = 30 * time.Second
+	// maxHeadersPerMsg bounds the headers of a headers message so it stays below maxMessageSize however long the chain is, clients ask for the next page after the last header
+	maxHeadersPerMsg = 2000
+)
+
+// MsgGetHeaders asks a node for the headers following the block with hash After, from the genesis block when it is empty
+type MsgGetHeaders struct {
+	After []byte
+}
+
+// MsgHeaders answers getheaders with at most maxHeadersPerMsg headers, oldest header first. A full message means more headers may follow.
+type MsgHeaders struct {
+	Headers []*BlockHeader
+}
+
+// MsgGetTxProofs asks a node for the inclusion proofs of the transactions paying to or spending from PubKeyHash
+type MsgGetTxProofs struct {
+	PubKeyHash []byte
+}
+
+// MsgTxProofs answers gettxproofs
+type MsgTxProofs struct {
+	Proofs []*TxProof
+}
+
+// MsgReject answers a request the node couldn't handle
+type MsgReject struct {
+	Command string
+	Reason  string
+}
+
+// message is a peer message payload
+type message interface {
+	Bytes() ([]byte, error)
+}
+
+// writeMessage frames a message: [4]byte network magic | [12]byte command, zero padded | uint32 payload length | [4]byte checksum | payload. The checksum is the first 4 bytes of sha256d(payload).
+func writeMessage(w io.Writer, magic [4]byte, command string, payload []byte) error {
+	if len(command) > commandSize || len(payload) > maxMessageSize {
+		return ErrMalformedEncoding
+	}
+
+	var header [4 + commandSize + 4 + 4]byte
+	copy(header[:4], magic[:])
+	copy(header[4:], command)
+	binary.LittleEndian.PutUint32(header[4+commandSize:], uint32(len(payload)))
+	copy(header[4+commandSize+4:], doubleSHA256(payload)[:4])
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readMessage reads a message framed by writeMessage, it fails with ErrWrongMagic on a message of another network
+func readMessage(r io.Reader, magic [4]byte) (string, []byte, error) {
+	var header [4 + commandSize + 4 + 4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(header[:4], magic[:]) {
+		return "", nil, ErrWrongMagic
+	}
+	command := string(bytes.TrimRight(header[4:4+commandSize], "\x00"))
+	length := binary.LittleEndian.Uint32(header[4+commandSize:])
+	if length > maxMessageSize {
+		return "", nil, ErrMalformedEncoding
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(doubleSHA256(payload)[:4], header[4+commandSize+4:]) {
+		return "", nil, ErrMalformedEncoding
+	}
+	return command, payload, nil
+}
+
+// Server serves the chain to other nodes and light clients over the peer protocol. Every request is answered by exactly one message: its reply, or reject with the reason it failed.
+type Server struct {
+	bc  *Blockchain
+	log *slog.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a server for the blockchain, it doesn't listen until Serve is called
+func NewServer(bc *Blockchain) *Server {
+	return &Server{
+		bc:        bc,
+		log:       bc.log.net,
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[net.Conn]bool),
+	}
+}
+
+// Serve accepts connections on l and serves each one in its own goroutine. It returns once l fails, or nil after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+	s.log.Info("listening for peers", "address", l.Addr().String())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops every Serve and closes the connections, it waits for the requests being handled
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	log := s.log.With("peer", conn.RemoteAddr().String())
+	log.Debug("peer connected")
+
+	// the bloom filter the peer loaded, if any, only applies to this connection
+	filter := new(PeerFilter)
+
+	r := bufio.NewReader(conn)
+	for {
+		// an idle or slow peer is dropped instead of holding the connection forever
+		if err := conn.SetReadDeadline(time.Now().Add(peerTimeout)); err != nil {
+			return
+		}
+		command, payload, err := readMessage(r, s.bc.params.Magic)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Warn("dropping peer", "err", err)
+			}
+			return
+		}
+
+		replyCommand, reply, err := s.handle(filter, command, payload)
+		if err != nil {
+			log.Debug("request rejected", "command", command, "err", err)
+			replyCommand = CmdReject
+			if reply, err = (&MsgReject{Command: command, Reason: err.Error()}).Bytes(); err != nil {
+				return
+			}
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(peerTimeout)); err != nil {
+			return
+		}
+		if err := writeMessage(conn, s.bc.params.Magic, replyCommand, reply); err != nil {
+			log.Warn("dropping peer", "err", err)
+			return
+		}
+	}
+}
+
+// handle answers a request with the command and payload of its reply, filter is the bloom filter state of the peer
+func (s *Server) handle(filter *PeerFilter, command string, payload []byte) (string, []byte, error) {
+	switch command {
+	case CmdVersion:
+		if _, err := BytesToMsgVersion(payload); err != nil {
+			return "", nil, err
+		}
+		msg, err := s.bc.Version()
+		if err != nil {
+			return "", nil, err
+		}
+		return reply(CmdVersion, msg)
+
+	case CmdGetHeaders:
+		msg, err := BytesToMsgGetHeaders(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		headers, err := s.bc.headers(msg.After, maxHeadersPerMsg)
+		if err != nil {
+			return "", nil, err
+		}
+		return reply(CmdHeaders, &MsgHeaders{Headers: headers})
+
+	case CmdGetTxProofs:
+		msg, err := BytesToMsgGetTxProofs(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		proofs, err := s.bc.TxProofs(msg.PubKeyHash)
+		if err != nil {
+			return "", nil, err
+		}
+		return reply(CmdTxProofs, &MsgTxProofs{Proofs: proofs})
+
+	case CmdFilterLoad:
+		msg, err := BytesToMsgFilterLoad(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		return CmdAck, nil, filter.HandleFilterLoad(msg)
+
+	case CmdFilterAdd:
+		msg, err := BytesToMsgFilterAdd(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		return CmdAck, nil, filter.HandleFilterAdd(msg)
+
+	case CmdFilterClear:
+		if len(payload) != 0 {
+			return "", nil, ErrMalformedEncoding
+		}
+		filter.HandleFilterClear(&MsgFilterClear{})
+		return CmdAck, nil, nil
+
+	case CmdGetMerkleBlock:
+		msg, err := BytesToMsgGetMerkleBlock(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		var block *Block
+		if err := s.bc.view(func(tx StoreTx) error {
+			block, err = tx.Block(msg.BlockHash)
+			return err
+		}); err != nil {
+			return "", nil, err
+		}
+		merkleBlock, err := filter.FilterBlock(block)
+		if err != nil {
+			return "", nil, err
+		}
+		return reply(CmdMerkleBlock, merkleBlock)
+
+	case CmdGetCFilter:
+		msg, err := BytesToMsgGetCFilter(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		cfilter, err := s.bc.HandleGetCFilter(msg)
+		if err != nil {
+			return "", nil, err
+		}
+		return reply(CmdCFilter, cfilter)
+	}
+
+	return "", nil, fmt.Errorf("%w: unknown command %q", ErrBadRequest, command)
+}
+
+func reply(command string, msg message) (string, []byte, error) {
+	payload, err := msg.Bytes()
+	return command, payload, err
+}
+
+// Peer is a connection to a node speaking the peer protocol. It implements ProofSource so a light client can sync from a remote node. It is safe for concurrent use, requests are sent one at a time.
+type Peer struct {
+	mu    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	magic [4]byte
+}
+
+// DialPeer connects to the node at address, a host:port, of the configured network
+func DialPeer(address string, opts ...Option) (*Peer, error) {
+	params, err := newOptions(opts).chainParams()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", address, peerTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Peer{conn: conn, r: bufio.NewReader(conn), magic: params.Magic}, nil
+}
+
+// Close closes the connection
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+// Version introduces the client to the node and returns the node's version, with the services it offers and the height of its chain. A light client offers no services.
+func (p *Peer) Version() (*MsgVersion, error) {
+	payload, err := p.request(CmdVersion, &MsgVersion{}, CmdVersion)
+	if err != nil {
+		return nil, err
+	}
+	return BytesToMsgVersion(payload)
+}
+
+// Headers asks the node for the headers following the block with hash after, one page of maxHeadersPerMsg headers at a time
+func (p *Peer) Headers(after []byte) ([]*BlockHeader, error) {
+	var headers []*BlockHeader
+	for {
+		payload, err := p.request(CmdGetHeaders, &MsgGetHeaders{After: after}, CmdHeaders)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := BytesToMsgHeaders(payload)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, msg.Headers...)
+		if len(msg.Headers) < maxHeadersPerMsg {
+			return headers, nil
+		}
+		after = msg.Headers[len(msg.Headers)-1].Hash
+	}
+}
+
+// TxProofs asks the node for the inclusion proofs of the transactions relevant to pubKeyHash
+func (p *Peer) TxProofs(pubKeyHash []byte) ([]*TxProof, error) {
+	payload, err := p.request(CmdGetTxProofs, &MsgGetTxProofs{PubKeyHash: pubKeyHash}, CmdTxProofs)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := BytesToMsgTxProofs(payload)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Proofs, nil
+}
+
+// LoadFilter sets the bloom filter the node matches the blocks requested with MerkleBlock against
+func (p *Peer) LoadFilter(filter *BloomFilter) error {
+	_, err := p.request(CmdFilterLoad, &MsgFilterLoad{Filter: filter}, CmdAck)
+	return err
+}
+
+// AddFilter adds data to the loaded bloom filter
+func (p *Peer) AddFilter(data []byte) error {
+	_, err := p.request(CmdFilterAdd, &MsgFilterAdd{Data: data}, CmdAck)
+	return err
+}
+
+// ClearFilter removes the loaded bloom filter
+func (p *Peer) ClearFilter() error {
+	_, err := p.request(CmdFilterClear, &MsgFilterClear{}, CmdAck)
+	return err
+}
+
+// MerkleBlock asks the node for the transactions of the block with the given hash that match the loaded bloom filter. The proofs are not checked, see FilteredBlock.Verify.
+func (p *Peer) MerkleBlock(blockHash []byte) (*FilteredBlock, error) {
+	payload, err := p.request(CmdGetMerkleBlock, &MsgGetMerkleBlock{BlockHash: blockHash}, CmdMerkleBlock)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := BytesToMsgMerkleBlock(payload)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Block, nil
+}
+
+// CFilter asks the node for the compact filter of the block with the given hash and its filter header
+func (p *Peer) CFilter(blockHash []byte) (*MsgCFilter, error) {
+	payload, err := p.request(CmdGetCFilter, &MsgGetCFilter{BlockHash: blockHash}, CmdCFilter)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := BytesToMsgCFilter(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(msg.BlockHash, blockHash) {
+		return nil, fmt.Errorf("%w: filter of block %x, want %x", ErrUnexpectedMessage, msg.BlockHash, blockHash)
+	}
+	return msg, nil
+}
+
+// request sends msg and returns the payload of the reply, which must be a want message. A reject is returned as an error wrapping ErrRejected.
+func (p *Peer) request(command string, msg message, want string) ([]byte, error) {
+	payload, err := msg.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.conn.SetDeadline(time.Now().Add(peerTimeout)); err != nil {
+		return nil, err
+	}
+	if err := writeMessage(p.conn, p.magic, command, payload); err != nil {
+		return nil, err
+	}
+	replyCommand, reply, err := readMessage(p.r, p.magic)
+	if err != nil {
+		return nil, err
+	}
+
+	switch replyCommand {
+	case want:
+		return reply, nil
+	case CmdReject:
+		reject, err := BytesToMsgReject(reply)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s: %s", ErrRejected, reject.Command, reject.Reason)
+	}
+	return nil, fmt.Errorf("%w: got %s, want %s", ErrUnexpectedMessage, replyCommand, want)
+}
+
+// Bytes encodes the message: bytes block hash
+func (m *MsgGetHeaders) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarBytes(&buff, m.After); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgGetHeaders decodes a getheaders message
+func BytesToMsgGetHeaders(data []byte) (*MsgGetHeaders, error) {
+	m := new(MsgGetHeaders)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		m.After, err = readVarBytes(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Bytes encodes the message: varint header count | BlockHeader...
+func (m *MsgHeaders) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarInt(&buff, uint64(len(m.Headers))); err != nil {
+		return nil, err
+	}
+	for _, header := range m.Headers {
+		if err := writeBlockHeader(&buff, header); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgHeaders decodes a headers message
+func BytesToMsgHeaders(data []byte) (*MsgHeaders, error) {
+	m := new(MsgHeaders)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		count, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			header, err := readBlockHeader(r)
+			if err != nil {
+				return err
+			}
+			m.Headers = append(m.Headers, header)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Bytes encodes the message: bytes pubkey hash
+func (m *MsgGetTxProofs) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarBytes(&buff, m.PubKeyHash); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgGetTxProofs decodes a gettxproofs message
+func BytesToMsgGetTxProofs(data []byte) (*MsgGetTxProofs, error) {
+	m := new(MsgGetTxProofs)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		m.PubKeyHash, err = readVarBytes(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Bytes encodes the message: varint proof count | TxProof...
+func (m *MsgTxProofs) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarInt(&buff, uint64(len(m.Proofs))); err != nil {
+		return nil, err
+	}
+	for _, proof := range m.Proofs {
+		if err := writeTxProof(&buff, proof); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgTxProofs decodes a txproofs message
+func BytesToMsgTxProofs(data []byte) (*MsgTxProofs, error) {
+	m := new(MsgTxProofs)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		count, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			proof, err := readTxProof(r)
+			if err != nil {
+				return err
+			}
+			m.Proofs = append(m.Proofs, proof)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Bytes encodes the message: bytes command | bytes reason
+func (m *MsgReject) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	for _, field := range []string{m.Command, m.Reason} {
+		if err := writeVarBytes(&buff, []byte(field)); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgReject decodes a reject message
+func BytesToMsgReject(data []byte) (*MsgReject, error) {
+	m := new(MsgReject)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		for _, field := range []*string{&m.Command, &m.Reason} {
+			v, err := readVarBytes(r)
+			if err != nil {
+				return err
+			}
+			*field = string(v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
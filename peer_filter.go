@@ -0,0 +1,278 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+// Peer message commands for bloom filtering
+const (
+	CmdFilterLoad  = "filterload"
+	CmdFilterAdd   = "filteradd"
+	CmdFilterClear = "filterclear"
+	CmdMerkleBlock = "merkleblock"
+	// CmdGetMerkleBlock asks for a block filtered by the loaded filter, the node answers with merkleblock
+	CmdGetMerkleBlock = "getmerkleblk"
+)
+
+// maxFilterAddSize bounds the data a peer can add to its filter with a single filteradd
+const maxFilterAddSize = 520
+
+// MsgFilterLoad replaces the peer's bloom filter
+type MsgFilterLoad struct {
+	Filter *BloomFilter
+}
+
+// MsgFilterAdd adds one element to the peer's bloom filter
+type MsgFilterAdd struct {
+	Data []byte
+}
+
+// MsgFilterClear removes the peer's bloom filter, the node goes back to sending every transaction
+type MsgFilterClear struct{}
+
+// Bytes encodes the message, it has no payload
+func (m *MsgFilterClear) Bytes() ([]byte, error) {
+	return nil, nil
+}
+
+// MsgGetMerkleBlock asks a node for the block with hash BlockHash, filtered by the loaded bloom filter
+type MsgGetMerkleBlock struct {
+	BlockHash []byte
+}
+
+// MsgMerkleBlock answers a block request from a peer with a loaded filter
+type MsgMerkleBlock struct {
+	Block *FilteredBlock
+}
+
+// PeerFilter is the bloom filter state a node keeps for one peer. It is safe for concurrent use.
+type PeerFilter struct {
+	mu     sync.Mutex
+	filter *BloomFilter
+}
+
+// HandleFilterLoad applies a filterload message
+func (pf *PeerFilter) HandleFilterLoad(msg *MsgFilterLoad) error {
+	f := msg.Filter
+	if f == nil || len(f.Filter) > maxBloomFilterSize || f.HashFuncs > maxBloomHashFuncs {
+		return ErrInvalidFilter
+	}
+	if f.Flags != BloomUpdateNone && f.Flags != BloomUpdateAll {
+		return ErrInvalidFilter
+	}
+
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	pf.filter = &BloomFilter{
+		Filter:    append([]byte{}, f.Filter...),
+		HashFuncs: f.HashFuncs,
+		Tweak:     f.Tweak,
+		Flags:     f.Flags,
+	}
+	return nil
+}
+
+// HandleFilterAdd applies a filteradd message
+func (pf *PeerFilter) HandleFilterAdd(msg *MsgFilterAdd) error {
+	if len(msg.Data) > maxFilterAddSize {
+		return ErrInvalidFilter
+	}
+
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if pf.filter == nil {
+		return ErrNoFilterLoaded
+	}
+	pf.filter.Add(msg.Data)
+	return nil
+}
+
+// HandleFilterClear applies a filterclear message
+func (pf *PeerFilter) HandleFilterClear(msg *MsgFilterClear) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	pf.filter = nil
+}
+
+// Loaded reports whether the peer has loaded a filter
+func (pf *PeerFilter) Loaded() bool {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	return pf.filter != nil
+}
+
+// FilterBlock builds the merkleblock message to send the peer for b
+func (pf *PeerFilter) FilterBlock(b *Block) (*MsgMerkleBlock, error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if pf.filter == nil {
+		return nil, ErrNoFilterLoaded
+	}
+	filtered, err := pf.filter.MatchBlock(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MsgMerkleBlock{Block: filtered}, nil
+}
+
+// Bytes encodes the message: bytes filter | uint32 hash funcs | uint32 tweak | uint8 flags
+func (m *MsgFilterLoad) Bytes() ([]byte, error) {
+	if m.Filter == nil {
+		return nil, ErrInvalidFilter
+	}
+	var buff bytes.Buffer
+
+	if err := writeVarBytes(&buff, m.Filter.Filter); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buff, binary.LittleEndian, m.Filter.HashFuncs); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buff, binary.LittleEndian, m.Filter.Tweak); err != nil {
+		return nil, err
+	}
+	if err := buff.WriteByte(byte(m.Filter.Flags)); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgFilterLoad decodes a filterload message
+func BytesToMsgFilterLoad(data []byte) (*MsgFilterLoad, error) {
+	f := new(BloomFilter)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		if f.Filter, err = readVarBytes(r); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &f.HashFuncs); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &f.Tweak); err != nil {
+			return err
+		}
+		flags, err := r.ReadByte()
+		f.Flags = BloomUpdateType(flags)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return &MsgFilterLoad{Filter: f}, nil
+}
+
+// Bytes encodes the message: bytes data
+func (m *MsgFilterAdd) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarBytes(&buff, m.Data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgFilterAdd decodes a filteradd message
+func BytesToMsgFilterAdd(data []byte) (*MsgFilterAdd, error) {
+	m := new(MsgFilterAdd)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		m.Data, err = readVarBytes(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Bytes encodes the message: BlockHeader | varint tx count | (Transaction | MerkleProof)...
+func (m *MsgMerkleBlock) Bytes() ([]byte, error) {
+	if m.Block == nil || m.Block.Header == nil || len(m.Block.Proofs) != len(m.Block.Txs) {
+		return nil, ErrMalformedEncoding
+	}
+	var buff bytes.Buffer
+
+	if err := writeBlockHeader(&buff, m.Block.Header); err != nil {
+		return nil, err
+	}
+	if err := writeVarInt(&buff, uint64(len(m.Block.Txs))); err != nil {
+		return nil, err
+	}
+	for i, tx := range m.Block.Txs {
+		if err := writeTransaction(&buff, tx); err != nil {
+			return nil, err
+		}
+		if err := writeMerkleProof(&buff, m.Block.Proofs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+// Bytes encodes the message: bytes block hash
+func (m *MsgGetMerkleBlock) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeVarBytes(&buff, m.BlockHash); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgGetMerkleBlock decodes a getmerkleblk message
+func BytesToMsgGetMerkleBlock(data []byte) (*MsgGetMerkleBlock, error) {
+	m := new(MsgGetMerkleBlock)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		m.BlockHash, err = readVarBytes(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// BytesToMsgMerkleBlock decodes a merkleblock message
+func BytesToMsgMerkleBlock(data []byte) (*MsgMerkleBlock, error) {
+	filtered := new(FilteredBlock)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		if filtered.Header, err = readBlockHeader(r); err != nil {
+			return err
+		}
+		count, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			tx, err := readTransaction(r)
+			if err != nil {
+				return err
+			}
+			proof, err := readMerkleProof(r)
+			if err != nil {
+				return err
+			}
+			filtered.Txs = append(filtered.Txs, tx)
+			filtered.Proofs = append(filtered.Proofs, proof)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &MsgMerkleBlock{Block: filtered}, nil
+}
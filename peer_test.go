@@ -0,0 +1,266 @@
+package hoji
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// serve serves the chain on a local port until the end of the test and returns its address
+func serve(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(bc)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func dialPeer(t *testing.T, address string, opts ...Option) *Peer {
+	t.Helper()
+
+	peer, err := DialPeer(address, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return peer
+}
+
+func TestLightClientOverPeer(t *testing.T) {
+	c := newTestChain(t, NewMemoryStore())
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	c.mine(t, c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(3, payee), NewTxOutput(reward.Value-3, c.miner)))
+	c.mine(t)
+
+	peer := dialPeer(t, serve(t, c.Blockchain), WithNetwork("regtest"))
+
+	// the light client has its own data directory, it never opens the full node's database
+	lc, err := NewLightClient(peer, WithNetwork("regtest"), WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	synced, err := lc.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced != 4 {
+		t.Fatalf("synced %d headers, want 4", synced)
+	}
+	if synced, err := lc.Sync(); err != nil || synced != 0 {
+		t.Fatalf("second sync got %d headers, %v", synced, err)
+	}
+
+	balance, err := lc.Balance(payee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance != 3 || len(balance.UTXOs) != 1 || balance.UTXOs[0].Confirmations != 2 {
+		t.Fatalf("balance is %d with %d outputs", balance.Balance, len(balance.UTXOs))
+	}
+}
+
+func TestPeerReject(t *testing.T) {
+	c := newTestChain(t, NewMemoryStore())
+	peer := dialPeer(t, serve(t, c.Blockchain), WithNetwork("regtest"))
+
+	_, err := peer.Headers([]byte("unknown block"))
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("got %v, want ErrRejected", err)
+	}
+
+	// the connection is still usable after a reject
+	headers, err := peer.Headers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("got %d headers, want 2", len(headers))
+	}
+}
+
+func TestPeerWrongNetwork(t *testing.T) {
+	c := newTestChain(t, NewMemoryStore())
+	peer := dialPeer(t, serve(t, c.Blockchain), WithNetwork("testnet"))
+
+	// the node drops a peer of another network
+	if _, err := peer.Headers(nil); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v, want EOF", err)
+	}
+}
+
+func TestPeerBloomFilter(t *testing.T) {
+	c := newTestChain(t, NewMemoryStore())
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	payment := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(3, payee), NewTxOutput(reward.Value-3, c.miner))
+	block := c.mine(t, payment)
+
+	peer := dialPeer(t, serve(t, c.Blockchain), WithNetwork("regtest"))
+
+	if _, err := peer.MerkleBlock(block.Hash); !errors.Is(err, ErrRejected) {
+		t.Fatalf("merkleblock without a filter: got %v, want ErrRejected", err)
+	}
+
+	filter := NewBloomFilter(10, 0.0001, 0, BloomUpdateNone)
+	filter.Add(ExtractPubKeyHash(payee))
+	if err := peer.LoadFilter(filter); err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := peer.MerkleBlock(block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered.Txs) != 1 || !bytes.Equal(filtered.Txs[0].ID, payment.ID) {
+		t.Fatalf("filtered block has %d transactions, want the payment", len(filtered.Txs))
+	}
+	if ok, err := filtered.Verify(); err != nil || !ok {
+		t.Fatalf("filtered block doesn't verify: %v %v", ok, err)
+	}
+
+	// the coinbase pays the miner
+	if err := peer.AddFilter(ExtractPubKeyHash(c.miner)); err != nil {
+		t.Fatal(err)
+	}
+	if filtered, err = peer.MerkleBlock(block.Hash); err != nil || len(filtered.Txs) != 2 {
+		t.Fatalf("filtered block after filteradd: %v", err)
+	}
+
+	if err := peer.ClearFilter(); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.AddFilter([]byte("data")); !errors.Is(err, ErrRejected) {
+		t.Fatalf("filteradd without a filter: got %v, want ErrRejected", err)
+	}
+}
+
+func TestPeerCFilter(t *testing.T) {
+	c := newTestChain(t, NewMemoryStore())
+	block := c.mine(t)
+	peer := dialPeer(t, serve(t, c.Blockchain), WithNetwork("regtest"))
+
+	msg, err := peer.CFilter(block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := msg.GCSFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Match(ExtractPubKeyHash(c.miner)) {
+		t.Fatal("filter doesn't match the miner's address")
+	}
+	prevHeader, err := c.FilterHeader(block.PrevBlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.FilterHeader, NextFilterHeader(msg.Filter, prevHeader)) {
+		t.Fatal("filter header doesn't chain to the previous one")
+	}
+}
+
+func TestPeerVersion(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		opts     []Option
+		services uint64
+	}{
+		{"full node", nil, SFNodeNetwork},
+		{"pruned node", []Option{WithPrune(MinPruneDepth)}, SFNodeNetworkLimited},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChain(t, NewMemoryStore(), test.opts...)
+			c.mine(t)
+
+			version, err := dialPeer(t, serve(t, c.Blockchain), WithNetwork("regtest")).Version()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version.Services != test.services || version.Height != 2 {
+				t.Fatalf("got services %d at height %d, want %d at height 2", version.Services, version.Height, test.services)
+			}
+		})
+	}
+}
+
+func TestPeerHeadersPages(t *testing.T) {
+	store := NewMemoryStore()
+	c := newTestChain(t, store)
+
+	// headers are served without being checked, a long chain of them doesn't need to be mined
+	tip := c.Tip()
+	if err := store.Update(func(tx StoreTx) error {
+		for i := 0; i < maxHeadersPerMsg+10; i++ {
+			root := sha256.Sum256(IntToByte(int64(i)))
+			header := &BlockHeader{Version: blockVersion, PrevBlockHash: tip, MerkleRoot: root[:]}
+			hash := sha256.Sum256(powData(header.Version, header.PrevBlockHash, header.Timestamp, header.MerkleRoot, header.Bits, header.Nonce))
+			header.Hash = hash[:]
+			if err := tx.PutHeader(header); err != nil {
+				return err
+			}
+			tip = header.Hash
+		}
+		return tx.SetTip(tip)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := dialPeer(t, serve(t, c.Blockchain), WithNetwork("regtest"))
+	payload, err := peer.request(CmdGetHeaders, &MsgGetHeaders{}, CmdHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := BytesToMsgHeaders(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Headers) != maxHeadersPerMsg {
+		t.Fatalf("a reply holds %d headers, want %d", len(msg.Headers), maxHeadersPerMsg)
+	}
+
+	headers, err := peer.Headers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != maxHeadersPerMsg+12 {
+		t.Fatalf("got %d headers, want %d", len(headers), maxHeadersPerMsg+12)
+	}
+	if !bytes.Equal(headers[0].Hash, RegTestParams.GenesisHash) {
+		t.Error("the first header isn't the genesis block")
+	}
+	for i := 1; i < len(headers); i++ {
+		if !bytes.Equal(headers[i].PrevBlockHash, headers[i-1].Hash) {
+			t.Fatalf("header %d doesn't follow the previous one", i)
+		}
+	}
+	if !bytes.Equal(headers[len(headers)-1].Hash, tip) {
+		t.Error("the last header isn't the tip")
+	}
+
+	after, err := peer.Headers(headers[maxHeadersPerMsg].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 11 || !bytes.Equal(after[0].Hash, headers[maxHeadersPerMsg+1].Hash) {
+		t.Errorf("got %d headers after height %d", len(after), maxHeadersPerMsg)
+	}
+	if _, err := peer.Headers([]byte("unknown block")); !errors.Is(err, ErrRejected) {
+		t.Errorf("headers after an unknown block got %v, want ErrRejected", err)
+	}
+}
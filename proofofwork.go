@@ -3,8 +3,6 @@ package hoji
 import (
 	"bytes"
 	"crypto/sha256"
-	"fmt"
-	"log"
 	"math"
 	"math/big"
 )
@@ -13,9 +11,6 @@ var (
 	maxNonce = math.MaxInt64
 )
 
-// targetBits is how complicated we want to make our hashcash proof. For our example we are saying the first 24 bits or 8 bytes or 3 characters of the hash must be 0
-const targetBits = 24
-
 //ProofOfWork is
 type ProofOfWork struct {
 	Block  *Block
@@ -24,64 +19,105 @@ type ProofOfWork struct {
 
 //NewPOW is
 func NewPOW(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
-
 	return &ProofOfWork{
 		Block:  b,
-		target: target,
+		target: powTarget(b.Bits),
+	}
+}
+
+//powTarget is the number a block hash has to be below. bits is how complicated we want to make our hashcash proof: the number of leading bits of the hash that must be 0.
+func powTarget(bits uint32) *big.Int {
+	if bits > maxTargetBits {
+		return new(big.Int)
 	}
+	target := big.NewInt(1)
+	return target.Lsh(target, uint(256-bits))
 }
 
-// Exec executes the pow for a new block
+// Exec executes the pow for a new block. When every nonce was tried it moves the block's timestamp forward and starts over.
 func (p *ProofOfWork) Exec() ([]byte, int) {
 	var hashInt big.Int
 	nonce := 0
 
-	fmt.Println("Mining new block")
 	for {
-		if nonce > maxNonce {
-			log.Panic("proof of work nonce overflow")
+		if nonce == maxNonce {
+			p.Block.Timestamp++
+			nonce = 0
 		}
 
 		preppedData, _ := p.prepData(nonce)
 		hash := sha256.Sum256(preppedData)
 		hashInt.SetBytes(hash[:])
-		fmt.Printf("\rtesting hash: %x : hash number: %s", hash, hashInt.String())
 
 		if hashInt.Cmp(p.target) == -1 {
-			fmt.Print("\n\n")
 			return hash[:], nonce
 		}
 		nonce++
 	}
 }
 
+//ValidateHeader checks a block header's proof of work without needing the block's transactions: its hash has to match its contents and meet the target
+func ValidateHeader(h *BlockHeader) bool {
+	var hashInt big.Int
+
+	if h.Version < minBlockVersion {
+		return false
+	}
+	hash := sha256.Sum256(powData(h.Version, h.PrevBlockHash, h.Timestamp, h.MerkleRoot, h.Bits, h.Nonce))
+	if !bytes.Equal(hash[:], h.Hash) {
+		return false
+	}
+	hashInt.SetBytes(hash[:])
+
+	return hashInt.Cmp(powTarget(h.Bits)) == -1
+}
+
 //Validate validates if a hash has met its requirments
 func (p *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data, _ := p.prepData(p.Block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	if p.Block.Version < minBlockVersion {
+		return false
+	}
+	hash, err := p.hash(p.Block.Nonce)
+	if err != nil {
+		return false
+	}
+	hashInt.SetBytes(hash)
 
 	return hashInt.Cmp(p.target) == -1
 }
 
+//hash returns the block hash for the given nonce
+func (p *ProofOfWork) hash(nonce int) ([]byte, error) {
+	data, err := p.prepData(nonce)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
 //prepData will convert all of the pow data into bytes.
 func (p *ProofOfWork) prepData(nonce int) ([]byte, error) {
 	hashedTransaction, err := p.Block.HashTransactions()
 	if err != nil {
 		return nil, err
 	}
-	return bytes.Join(
-		[][]byte{
-			p.Block.PrevBlockHash,
-			IntToByte(p.Block.Timestamp),
-			hashedTransaction,
-			IntToByte(int64(targetBits)),
-			IntToByte(int64(nonce)),
-		},
-		[]byte{},
-	), nil
+	return powData(p.Block.Version, p.Block.PrevBlockHash, p.Block.Timestamp, hashedTransaction, p.Block.Bits, nonce), nil
+}
+
+//powData joins everything a block hash commits to. It only needs header fields so headers can be checked on their own. The version is only committed to from version 3 on so older blocks, the genesis blocks among them, keep their hashes.
+func powData(version uint32, prevBlockHash []byte, timestamp int64, merkleRoot []byte, bits uint32, nonce int) []byte {
+	data := [][]byte{
+		prevBlockHash,
+		IntToByte(timestamp),
+		merkleRoot,
+		IntToByte(int64(bits)),
+		IntToByte(int64(nonce)),
+	}
+	if version >= 3 {
+		data = append([][]byte{IntToByte(int64(version))}, data...)
+	}
+	return bytes.Join(data, []byte{})
 }
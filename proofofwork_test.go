@@ -0,0 +1,84 @@
+package hoji
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGenesisBlocks(t *testing.T) {
+	for _, params := range networks {
+		genesis, err := params.GenesisBlock()
+		if err != nil {
+			t.Fatalf("%s: %v", params.Name, err)
+		}
+		if genesis.Version != genesisVersion {
+			t.Fatalf("%s: genesis version %d", params.Name, genesis.Version)
+		}
+	}
+}
+
+func TestBlockHashCommitsToVersion(t *testing.T) {
+	coinbase, err := NewCoinbaseTx([]byte(RegTestParams.GenesisAddress), []byte("version"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock([]*Transaction{coinbase}, RegTestParams.GenesisHash, 8)
+	if block.Version != blockVersion || !NewPOW(block).Validate() {
+		t.Fatal("mined block doesn't validate")
+	}
+
+	for _, version := range []uint32{0, 1} {
+		changed := *block
+		changed.Version = version
+		if NewPOW(&changed).Validate() {
+			t.Fatalf("version %d block validates", version)
+		}
+	}
+
+	changed := *block
+	changed.Version = 2
+	if hash, err := NewPOW(&changed).hash(changed.Nonce); err != nil || bytes.Equal(hash, block.Hash) {
+		t.Fatalf("version 2 keeps the block hash: %v", err)
+	}
+
+	header, err := changed.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ValidateHeader(header) {
+		t.Fatal("version 2 header validates under the version 3 hash")
+	}
+}
+
+func TestNextBitsSteps(t *testing.T) {
+	params := TestNetParams
+	expected := int64(params.TargetSpacing/time.Second) * params.RetargetInterval
+	height := params.RetargetInterval - 1
+	const bits = 24
+
+	for _, test := range []struct {
+		actual int64
+		want   uint32
+	}{
+		{expected, bits},
+		// the difficulty stays until blocks are about 1.41 times off
+		{expected * 10 / 14, bits},
+		{expected * 14 / 10, bits},
+		{expected * 10 / 15, bits + 1},
+		{expected * 15 / 10, bits - 1},
+		{expected / 4, bits + 2},
+		{expected * 4, bits - 2},
+		// limited by MaxRetargetFactor
+		{expected / 100, bits + 2},
+		{expected * 100, bits - 2},
+	} {
+		got, err := params.nextBits(bits, height, test.actual, func() (int64, error) { return 0, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("timespan %d of %d: got bits %d, want %d", test.actual, expected, got, test.want)
+		}
+	}
+}
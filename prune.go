@@ -0,0 +1,136 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// MinPruneDepth is the smallest number of recent blocks a pruned node keeps, about two days of blocks on mainnet
+const MinPruneDepth = 288
+
+// pruneHeightKey stores, inside the meta bucket, the height of the last block whose transactions were deleted
+const pruneHeightKey = "p"
+
+// Service flags a node advertises to its peers
+const (
+	// SFNodeNetwork is set by nodes that can serve every block of the chain
+	SFNodeNetwork uint64 = 1 << 0
+	// SFNodeNetworkLimited is set by pruned nodes, they can only serve the last MinPruneDepth blocks
+	SFNodeNetworkLimited uint64 = 1 << 10
+)
+
+// CmdVersion is the peer message command a node introduces itself with, the node answers with its own
+const CmdVersion = "version"
+
+// MsgVersion tells a peer which services the node offers and how long its chain is
+type MsgVersion struct {
+	Services uint64
+	Height   int64
+}
+
+// Bytes encodes the message: uint64 services | int64 height
+func (m *MsgVersion) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := binary.Write(&buff, binary.LittleEndian, m.Services); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buff, binary.LittleEndian, m.Height); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToMsgVersion decodes a version message
+func BytesToMsgVersion(data []byte) (*MsgVersion, error) {
+	m := new(MsgVersion)
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		if err := binary.Read(r, binary.LittleEndian, &m.Services); err != nil {
+			return err
+		}
+		return binary.Read(r, binary.LittleEndian, &m.Height)
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Version returns the version message the node answers its peers with. Pruned nodes advertise SFNodeNetworkLimited instead of SFNodeNetwork.
+func (bc *Blockchain) Version() (*MsgVersion, error) {
+	msg := new(MsgVersion)
+	if err := bc.view(func(tx StoreTx) error {
+		tip, err := tx.Header(tx.Tip())
+		if err != nil {
+			return err
+		}
+		msg.Height = tip.Height
+
+		msg.Services = SFNodeNetwork
+		if bc.prune > 0 || pruneHeight(tx) > 0 {
+			msg.Services = SFNodeNetworkLimited
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// PruneHeight returns the height of the last block whose transactions were deleted, 0 if the node was never pruned. The genesis block is always kept.
+func (bc *Blockchain) PruneHeight() (int64, error) {
+	var height int64
+	err := bc.view(func(tx StoreTx) error {
+		height = pruneHeight(tx)
+		return nil
+	})
+	return height, err
+}
+
+func pruneHeight(tx StoreTx) int64 {
+	v := tx.Get([]byte(metaBucket), []byte(pruneHeightKey))
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+// pruneBlocks deletes the transactions and undo records of every block but the last depth ones and the genesis block. Headers, heights, filters and the UTXO set are kept.
+func pruneBlocks(tx StoreTx, depth int64) error {
+	tip, err := tx.Header(tx.Tip())
+	if err != nil {
+		return err
+	}
+	last := tip.Height - depth
+	// the blocks the stored UTXO set doesn't include yet are needed to recover from an unclean shutdown
+	if utxoTip, err := tx.Header(tx.Get([]byte(metaBucket), []byte(utxoTipKey))); err == nil && utxoTip.Height < last {
+		last = utxoTip.Height
+	}
+	if last <= pruneHeight(tx) {
+		return nil
+	}
+
+	header := tip
+	for header.Height > last {
+		if header, err = tx.Header(header.PrevBlockHash); err != nil {
+			return err
+		}
+	}
+
+	// blocks below the previous prune height are already gone
+	for done := pruneHeight(tx); header.Height > done; {
+		if err := tx.PruneBlock(header.Hash); err != nil {
+			return err
+		}
+		if err := tx.Delete([]byte(undoBucket), header.Hash); err != nil {
+			return err
+		}
+		if header, err = tx.Header(header.PrevBlockHash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Put([]byte(metaBucket), []byte(pruneHeightKey), IntToByte(last))
+}
@@ -0,0 +1,143 @@
+package hoji
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxRPCRequestSize bounds the body of an RPC request
+const maxRPCRequestSize = 1 << 20
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// rpcNotFound is returned for a block the node doesn't have
+	rpcNotFound = -5
+)
+
+// RPCRequest is a JSON-RPC request, Params holds the positional parameters of Method
+type RPCRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// RPCResponse is a JSON-RPC response, exactly one of Result and Error is set
+type RPCResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// RPCError is the error of a failed JSON-RPC request
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// BlockFilterResult is the result of getblockfilter
+type BlockFilterResult struct {
+	Filter string `json:"filter"`
+	Header string `json:"header"`
+}
+
+// rpcHandler serves JSON-RPC requests over HTTP POST
+type rpcHandler struct {
+	bc      *Blockchain
+	methods map[string]func(params []json.RawMessage) (interface{}, error)
+}
+
+// NewRPCHandler returns the HTTP handler of the node's JSON-RPC interface. Its methods are:
+//
+//	getblockfilter "blockhash" - the hex encoded compact filter of the block and its filter header
+func NewRPCHandler(bc *Blockchain) http.Handler {
+	h := &rpcHandler{bc: bc}
+	h.methods = map[string]func(params []json.RawMessage) (interface{}, error){
+		"getblockfilter": h.getBlockFilter,
+	}
+	return h
+}
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests have to be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RPCRequest
+	resp := &RPCResponse{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRPCRequestSize)).Decode(&req); err != nil {
+		resp.Error = &RPCError{Code: rpcParseError, Message: err.Error()}
+	} else {
+		resp.ID = req.ID
+		resp.Result, resp.Error = h.call(req.Method, req.Params)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.bc.log.net.Warn("writing RPC response", "err", err)
+	}
+}
+
+// call runs a method and turns its error into an RPCError
+func (h *rpcHandler) call(method string, params []json.RawMessage) (interface{}, *RPCError) {
+	fn, ok := h.methods[method]
+	if !ok {
+		return nil, &RPCError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %q not found", method)}
+	}
+
+	result, err := fn(params)
+	var rpcErr *RPCError
+	switch {
+	case err == nil:
+		return result, nil
+	case errors.As(err, &rpcErr):
+		return nil, rpcErr
+	case errors.Is(err, ErrNotFound):
+		return nil, &RPCError{Code: rpcNotFound, Message: err.Error()}
+	}
+	return nil, &RPCError{Code: rpcInternalError, Message: err.Error()}
+}
+
+func (h *rpcHandler) getBlockFilter(params []json.RawMessage) (interface{}, error) {
+	hash, err := hashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := h.bc.HandleGetCFilter(&MsgGetCFilter{BlockHash: hash})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockFilterResult{
+		Filter: hex.EncodeToString(msg.Filter),
+		Header: hex.EncodeToString(msg.FilterHeader),
+	}, nil
+}
+
+// hashParam decodes the only parameter of a method taking a hex encoded hash
+func hashParam(params []json.RawMessage) ([]byte, error) {
+	if len(params) != 1 {
+		return nil, &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf("expected 1 parameter, got %d", len(params))}
+	}
+	var s string
+	if err := json.Unmarshal(params[0], &s); err != nil {
+		return nil, &RPCError{Code: rpcInvalidParams, Message: "the parameter has to be a hex encoded hash"}
+	}
+	hash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, &RPCError{Code: rpcInvalidParams, Message: "the parameter has to be a hex encoded hash"}
+	}
+	return hash, nil
+}
@@ -0,0 +1,74 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func rpcCall(t *testing.T, url, method string, params ...interface{}) *RPCResponse {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{"id": 1, "method": method, "params": params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		RPCResponse
+		Result *BlockFilterResult `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Result != nil {
+		result.RPCResponse.Result = result.Result
+	}
+	return &result.RPCResponse
+}
+
+func TestRPCGetBlockFilter(t *testing.T) {
+	c := newTestChain(t, NewMemoryStore())
+	block := c.mine(t)
+	server := httptest.NewServer(NewRPCHandler(c.Blockchain))
+	defer server.Close()
+
+	resp := rpcCall(t, server.URL, "getblockfilter", hex.EncodeToString(block.Hash))
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	result := resp.Result.(*BlockFilterResult)
+
+	want, err := c.HandleGetCFilter(&MsgGetCFilter{BlockHash: block.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Filter != hex.EncodeToString(want.Filter) || result.Header != hex.EncodeToString(want.FilterHeader) {
+		t.Fatalf("got filter %s and header %s", result.Filter, result.Header)
+	}
+
+	failures := []struct {
+		method string
+		params []interface{}
+		code   int
+	}{
+		{"getblockfilter", []interface{}{"00"}, rpcNotFound},
+		{"getblockfilter", []interface{}{"not hex"}, rpcInvalidParams},
+		{"getblockfilter", nil, rpcInvalidParams},
+		{"stop", nil, rpcMethodNotFound},
+	}
+	for _, test := range failures {
+		resp := rpcCall(t, server.URL, test.method, test.params...)
+		if resp.Error == nil || resp.Error.Code != test.code {
+			t.Errorf("%s %v: got error %v, want code %d", test.method, test.params, resp.Error, test.code)
+		}
+	}
+}
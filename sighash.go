@@ -0,0 +1,101 @@
+package hoji
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// SigHashType selects which parts of a transaction a signature commits to. It is appended as the last byte of every input signature.
+type SigHashType byte
+
+const (
+	// SigHashAll signs every input and every output. This is the default.
+	SigHashAll SigHashType = 0x01
+	// SigHashNone signs every input but none of the outputs, letting anyone decide where the coins go.
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle signs every input and only the output with the same index as the signed input.
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay is combined with one of the types above and restricts the signature to the signed input only, so others can add inputs (e.g. crowdfunding).
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashBaseMask = 0x1f
+)
+
+func (h SigHashType) base() SigHashType {
+	return h & sigHashBaseMask
+}
+
+func (h SigHashType) anyoneCanPay() bool {
+	return h&SigHashAnyoneCanPay != 0
+}
+
+func (h SigHashType) valid() bool {
+	if h&^(SigHashAnyoneCanPay|sigHashBaseMask) != 0 {
+		return false
+	}
+	base := h.base()
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+// SigHash computes the digest that input inputIndex signs. prevPubKeyHash is the PubKeyHash of the output being spent.
+//
+// The digest is built from a copy of the transaction:
+//  1. every input's Signature and PubKey are cleared and the signed input's PubKey is set to prevPubKeyHash
+//  2. with SigHashAnyoneCanPay only the signed input is kept
+//  3. with SigHashNone no outputs are kept; with SigHashSingle outputs after inputIndex are dropped and the ones before it are blanked (Value -1, empty PubKeyHash)
+//  4. the copy is serialized in the transaction wire format (see encoding.go) followed by the hash type as a little endian uint32
+//  5. the digest is sha256(sha256(preimage))
+func (t *Transaction) SigHash(inputIndex int, prevPubKeyHash []byte, hashType SigHashType) ([]byte, error) {
+	if !hashType.valid() {
+		return nil, ErrInvalidSigHash
+	}
+	if inputIndex < 0 || inputIndex >= len(t.Inputs) {
+		return nil, ErrBadRequest
+	}
+
+	var inputs []*TxInput
+	for i, input := range t.Inputs {
+		if hashType.anyoneCanPay() && i != inputIndex {
+			continue
+		}
+		in := &TxInput{
+			PrevOut: input.PrevOut,
+		}
+		if i == inputIndex {
+			in.PubKey = prevPubKeyHash
+		}
+		inputs = append(inputs, in)
+	}
+
+	var outputs []*TxOutput
+	switch hashType.base() {
+	case SigHashAll:
+		outputs = t.Outputs
+	case SigHashSingle:
+		if inputIndex >= len(t.Outputs) {
+			return nil, ErrInvalidSigHash
+		}
+		for i := 0; i < inputIndex; i++ {
+			outputs = append(outputs, &TxOutput{Value: -1})
+		}
+		outputs = append(outputs, t.Outputs[inputIndex])
+	}
+
+	preimage := new(bytes.Buffer)
+	trimmedTx := &Transaction{
+		Version: t.Version,
+		Inputs:  inputs,
+		Outputs: outputs,
+	}
+	if err := writeTransaction(preimage, trimmedTx); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(preimage, binary.LittleEndian, uint32(hashType)); err != nil {
+		return nil, err
+	}
+
+	first := sha256.Sum256(preimage.Bytes())
+	hash := sha256.Sum256(first[:])
+	return hash[:], nil
+}
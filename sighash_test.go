@@ -0,0 +1,180 @@
+package hoji
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// sigHashFixture is a transaction spending two outputs of two wallets into three outputs, along with a third spendable output it can be changed to spend
+type sigHashFixture struct {
+	tx      *Transaction
+	prevTxs map[string]*Transaction
+	wallets []*Wallet
+	spare   OutPoint
+}
+
+func newSigHashFixture(t *testing.T) *sigHashFixture {
+	t.Helper()
+
+	f := &sigHashFixture{prevTxs: make(map[string]*Transaction)}
+	var prevOuts []OutPoint
+	for i := 0; i < 3; i++ {
+		w, err := NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeyHash, err := hashPubKey(w.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevTx := &Transaction{ID: []byte{byte(i + 1)}, Version: txVersion, Outputs: []*TxOutput{{Value: 10, PubKeyHash: pubKeyHash}}}
+		f.prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+		f.wallets = append(f.wallets, w)
+		prevOuts = append(prevOuts, NewOutPoint(prevTx.ID, 0))
+	}
+	f.spare = prevOuts[2]
+
+	f.tx = &Transaction{Version: txVersion}
+	for i, prevOut := range prevOuts[:2] {
+		f.tx.Inputs = append(f.tx.Inputs, &TxInput{PrevOut: prevOut, PubKey: f.wallets[i].PublicKey})
+	}
+	for i := 0; i < 3; i++ {
+		f.tx.Outputs = append(f.tx.Outputs, &TxOutput{Value: 5 + i, PubKeyHash: []byte{byte(i)}})
+	}
+	return f
+}
+
+// sign signs input i with the wallet owning the output it spends
+func (f *sigHashFixture) sign(t *testing.T, i int, hashType SigHashType) error {
+	t.Helper()
+	return f.tx.SignInput(i, f.wallets[i].PrivateKey, f.prevTxs, hashType)
+}
+
+func TestSigHashTypes(t *testing.T) {
+	mutations := map[string]func(f *sigHashFixture){
+		"output 0 value":     func(f *sigHashFixture) { f.tx.Outputs[0].Value++ },
+		"output 1 recipient": func(f *sigHashFixture) { f.tx.Outputs[1].PubKeyHash = []byte("thief") },
+		"output 2 value":     func(f *sigHashFixture) { f.tx.Outputs[2].Value-- },
+		"added output":       func(f *sigHashFixture) { f.tx.Outputs = append(f.tx.Outputs, &TxOutput{Value: 1}) },
+		"input 1 prevout":    func(f *sigHashFixture) { f.tx.Inputs[1].PrevOut = f.spare },
+		"input 1 signature":  func(f *sigHashFixture) { f.tx.Inputs[1].Signature = []byte("forged") },
+		"added input": func(f *sigHashFixture) {
+			f.tx.Inputs = append(f.tx.Inputs, &TxInput{PrevOut: f.spare, PubKey: f.wallets[2].PublicKey})
+		},
+		"version": func(f *sigHashFixture) { f.tx.Version++ },
+	}
+
+	for _, test := range []struct {
+		name     string
+		input    int
+		hashType SigHashType
+		// uncommitted lists the mutations the signature still verifies after, it fails after every other one
+		uncommitted []string
+	}{
+		{"all", 0, SigHashAll, []string{"input 1 signature"}},
+		{"none", 0, SigHashNone, []string{"output 0 value", "output 1 recipient", "output 2 value", "added output", "input 1 signature"}},
+		{"single", 0, SigHashSingle, []string{"output 1 recipient", "output 2 value", "added output", "input 1 signature"}},
+		// the outputs before the signed one are blanked, only their count is committed
+		{"single blanks earlier outputs", 1, SigHashSingle, []string{"output 0 value", "output 2 value", "added output"}},
+		{"all anyonecanpay", 0, SigHashAll | SigHashAnyoneCanPay, []string{"input 1 prevout", "input 1 signature", "added input"}},
+		{"none anyonecanpay", 0, SigHashNone | SigHashAnyoneCanPay, []string{"output 0 value", "output 1 recipient", "output 2 value", "added output", "input 1 prevout", "input 1 signature", "added input"}},
+		{"single anyonecanpay", 0, SigHashSingle | SigHashAnyoneCanPay, []string{"output 1 recipient", "output 2 value", "added output", "input 1 prevout", "input 1 signature", "added input"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			uncommitted := make(map[string]bool)
+			for _, name := range test.uncommitted {
+				uncommitted[name] = true
+			}
+
+			for name, mutate := range mutations {
+				f := newSigHashFixture(t)
+				if err := f.sign(t, test.input, test.hashType); err != nil {
+					t.Fatal(err)
+				}
+				if ok, err := f.tx.verifyInput(test.input, f.prevTxs); err != nil || !ok {
+					t.Fatalf("signature doesn't verify before the transaction is changed: %v", err)
+				}
+				if got := f.tx.Inputs[test.input].Signature[sigLen]; SigHashType(got) != test.hashType {
+					t.Fatalf("signature ends with hash type %#x", got)
+				}
+
+				mutate(f)
+				ok, err := f.tx.verifyInput(test.input, f.prevTxs)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if ok != uncommitted[name] {
+					t.Errorf("%s: signature verifies %v, want %v", name, ok, uncommitted[name])
+				}
+			}
+		})
+	}
+}
+
+func TestSigHashSingleOutOfRange(t *testing.T) {
+	f := newSigHashFixture(t)
+	f.tx.Outputs = f.tx.Outputs[:1]
+	if err := f.sign(t, 1, SigHashSingle); err != ErrInvalidSigHash {
+		t.Fatalf("signing input 1 with a single output got %v, want ErrInvalidSigHash", err)
+	}
+
+	// a signature whose output was removed no longer verifies
+	f = newSigHashFixture(t)
+	if err := f.sign(t, 1, SigHashSingle); err != nil {
+		t.Fatal(err)
+	}
+	f.tx.Outputs = f.tx.Outputs[:1]
+	if ok, err := f.tx.verifyInput(1, f.prevTxs); err != nil || ok {
+		t.Errorf("signature of a missing output verifies %v (%v)", ok, err)
+	}
+}
+
+func TestSigHashInvalidType(t *testing.T) {
+	f := newSigHashFixture(t)
+	for _, hashType := range []SigHashType{0, 0x04, SigHashAnyoneCanPay, SigHashAll | 0x40} {
+		if _, err := f.tx.SigHash(0, nil, hashType); err != ErrInvalidSigHash {
+			t.Errorf("hash type %#x got %v, want ErrInvalidSigHash", hashType, err)
+		}
+	}
+
+	// a valid signature relabeled with another hash type fails
+	if err := f.sign(t, 0, SigHashAll); err != nil {
+		t.Fatal(err)
+	}
+	f.tx.Inputs[0].Signature[sigLen] = byte(SigHashNone)
+	if ok, err := f.tx.verifyInput(0, f.prevTxs); err != nil || ok {
+		t.Errorf("relabeled signature verifies %v (%v)", ok, err)
+	}
+}
+
+func TestSignAllInputs(t *testing.T) {
+	f := newSigHashFixture(t)
+	for i := range f.tx.Inputs {
+		if err := f.sign(t, i, SigHashAll); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := f.tx.Verify(f.prevTxs); err != nil || !ok {
+		t.Fatalf("transaction doesn't verify: %v", err)
+	}
+	f.tx.Outputs[0].Value++
+	if ok, err := f.tx.Verify(f.prevTxs); err != nil || ok {
+		t.Errorf("changed transaction verifies %v (%v)", ok, err)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	f := newSigHashFixture(t)
+	if err := f.sign(t, 0, SigHashAll); err != nil {
+		t.Fatal(err)
+	}
+	trimmed := f.tx.Trim()
+	for i, input := range trimmed.Inputs {
+		if input.Signature != nil || input.PubKey != nil || input.PrevOut.String() != f.tx.Inputs[i].PrevOut.String() {
+			t.Errorf("input %d wasn't trimmed", i)
+		}
+	}
+	if len(trimmed.Outputs) != len(f.tx.Outputs) || f.tx.Inputs[0].Signature == nil {
+		t.Error("trimming changed the transaction")
+	}
+}
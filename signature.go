@@ -0,0 +1,179 @@
+package hoji
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+)
+
+// coordLen is the fixed width of a P-256 scalar or coordinate. Signatures are encoded as r || s and compressed public keys as prefix || X, each value left padded to coordLen bytes so they can always be split back apart.
+const coordLen = 32
+
+// sigLen is the length of an encoded signature
+const sigLen = 2 * coordLen
+
+// compressedPubKeyLen is the length of a compressed public key: a 0x02/0x03 parity prefix followed by X
+const compressedPubKeyLen = 1 + coordLen
+
+// signHash signs hash with a deterministic RFC 6979 nonce and returns the fixed width r || s encoding. s is always normalized to the lower half of the curve order so every signature has exactly one valid encoding.
+func signHash(privKey *ecdsa.PrivateKey, hash []byte) []byte {
+	curve := privKey.Curve
+	n := curve.Params().N
+	halfN := new(big.Int).Rsh(n, 1)
+	e := hashToInt(hash, n)
+	nextNonce := newRFC6979Nonce(privKey.D, hash, n)
+
+	for {
+		k := nextNonce()
+
+		x, _ := curve.ScalarBaseMult(padScalar(k))
+		r := new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		s := new(big.Int).Mul(r, privKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+		if s.Cmp(halfN) > 0 {
+			s.Sub(n, s)
+		}
+
+		return append(padScalar(r), padScalar(s)...)
+	}
+}
+
+// parseSignature splits an encoded signature into r and s. Only the canonical low-S form is accepted.
+func parseSignature(curve elliptic.Curve, sig []byte) (*big.Int, *big.Int, bool) {
+	if len(sig) != sigLen {
+		return nil, nil, false
+	}
+	n := curve.Params().N
+	r := new(big.Int).SetBytes(sig[:coordLen])
+	s := new(big.Int).SetBytes(sig[coordLen:])
+
+	if r.Sign() == 0 || r.Cmp(n) >= 0 || s.Sign() == 0 || s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return nil, nil, false
+	}
+
+	return r, s, true
+}
+
+// compressPubKey encodes a public key in its 33 byte compressed form
+func compressPubKey(pubKey *ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(pubKey.Curve, pubKey.X, pubKey.Y)
+}
+
+// parsePubKey decodes a public key. Besides the compressed form it accepts the legacy X.Bytes() || Y.Bytes() encoding used by wallets created before keys were compressed, where either coordinate may have lost its leading zero bytes.
+func parsePubKey(curve elliptic.Curve, pubKey []byte) (*ecdsa.PublicKey, bool) {
+	if len(pubKey) == compressedPubKeyLen {
+		x, y := elliptic.UnmarshalCompressed(curve, pubKey)
+		if x == nil {
+			return nil, false
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+	}
+	if len(pubKey) == 0 || len(pubKey) > 2*coordLen {
+		return nil, false
+	}
+
+	// the halves of an even encoding first, then a short X followed by a full Y and a full X followed by a short Y
+	var splits []int
+	if len(pubKey)%2 == 0 {
+		splits = append(splits, len(pubKey)/2)
+	}
+	splits = append(splits, len(pubKey)-coordLen, coordLen)
+	for _, split := range splits {
+		if split <= 0 || split >= len(pubKey) || split > coordLen || len(pubKey)-split > coordLen {
+			continue
+		}
+		x := new(big.Int).SetBytes(pubKey[:split])
+		y := new(big.Int).SetBytes(pubKey[split:])
+		if curve.IsOnCurve(x, y) {
+			return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+		}
+	}
+	return nil, false
+}
+
+// newRFC6979Nonce returns a generator of the candidate nonces described in RFC 6979 section 3.2 using HMAC-SHA256. Each call returns the next candidate in [1, n-1].
+func newRFC6979Nonce(d *big.Int, hash []byte, n *big.Int) func() *big.Int {
+	qLen := (n.BitLen() + 7) / 8
+	bx := append(padTo(d.Bytes(), qLen), padTo(new(big.Int).Mod(hashToInt(hash, n), n).Bytes(), qLen)...)
+
+	v := make([]byte, sha256.Size)
+	for i := range v {
+		v[i] = 0x01
+	}
+	k := make([]byte, sha256.Size)
+
+	k = hmacSHA256(k, v, []byte{0x00}, bx)
+	v = hmacSHA256(k, v)
+	k = hmacSHA256(k, v, []byte{0x01}, bx)
+	v = hmacSHA256(k, v)
+
+	first := true
+	return func() *big.Int {
+		for {
+			if !first {
+				k = hmacSHA256(k, v, []byte{0x00})
+				v = hmacSHA256(k, v)
+			}
+			first = false
+
+			var t []byte
+			for len(t) < qLen {
+				v = hmacSHA256(k, v)
+				t = append(t, v...)
+			}
+
+			nonce := hashToInt(t, n)
+			if nonce.Sign() > 0 && nonce.Cmp(n) < 0 {
+				return nonce
+			}
+		}
+	}
+}
+
+// hashToInt is RFC 6979's bits2int: it takes the leftmost bits of hash as an integer no longer than n
+func hashToInt(hash []byte, n *big.Int) *big.Int {
+	orderBits := n.BitLen()
+	orderBytes := (orderBits + 7) / 8
+	if len(hash) > orderBytes {
+		hash = hash[:orderBytes]
+	}
+
+	ret := new(big.Int).SetBytes(hash)
+	if excess := len(hash)*8 - orderBits; excess > 0 {
+		ret.Rsh(ret, uint(excess))
+	}
+
+	return ret
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func padScalar(x *big.Int) []byte {
+	return padTo(x.Bytes(), coordLen)
+}
+
+func padTo(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
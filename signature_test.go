@@ -0,0 +1,172 @@
+package hoji
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// rfc6979Key is the P-256 private key of RFC 6979 appendix A.2.5
+func rfc6979Key(t *testing.T) *ecdsa.PrivateKey {
+	d, ok := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	if !ok {
+		t.Fatal("bad test key")
+	}
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.Curve = elliptic.P256()
+	priv.X, priv.Y = priv.Curve.ScalarBaseMult(d.Bytes())
+	return priv
+}
+
+func TestSignHashRFC6979(t *testing.T) {
+	priv := rfc6979Key(t)
+	n := priv.Curve.Params().N
+
+	// RFC 6979 A.2.5, P-256 with SHA-256
+	tests := []struct {
+		message string
+		k, r, s string
+	}{
+		{
+			message: "sample",
+			k:       "A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60",
+			r:       "EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			s:       "F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+		},
+		{
+			message: "test",
+			k:       "D16B6AE827F17175E040871A1C7EC3500192C4C92677336EC2537ACAEE0008E0",
+			r:       "F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367",
+			s:       "019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083",
+		},
+	}
+
+	for _, test := range tests {
+		hash := sha256.Sum256([]byte(test.message))
+
+		k := newRFC6979Nonce(priv.D, hash[:], n)()
+		if got := hex.EncodeToString(padScalar(k)); !equalHex(got, test.k) {
+			t.Errorf("%s: k = %s, want %s", test.message, got, test.k)
+		}
+
+		sig := signHash(priv, hash[:])
+		if got := hex.EncodeToString(sig[:coordLen]); !equalHex(got, test.r) {
+			t.Errorf("%s: r = %s, want %s", test.message, got, test.r)
+		}
+
+		// the RFC's s is normalized to the lower half of the curve order
+		wantS, _ := new(big.Int).SetString(test.s, 16)
+		if wantS.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+			wantS.Sub(n, wantS)
+		}
+		if got := new(big.Int).SetBytes(sig[coordLen:]); got.Cmp(wantS) != 0 {
+			t.Errorf("%s: s = %x, want %x", test.message, got, wantS)
+		}
+
+		if !bytes.Equal(sig, signHash(priv, hash[:])) {
+			t.Errorf("%s: signing twice gave different signatures", test.message)
+		}
+	}
+}
+
+func TestSignHashLowS(t *testing.T) {
+	priv := rfc6979Key(t)
+	n := priv.Curve.Params().N
+	halfN := new(big.Int).Rsh(n, 1)
+
+	for i := 0; i < 200; i++ {
+		hash := sha256.Sum256(IntToByte(int64(i)))
+		sig := signHash(priv, hash[:])
+		if len(sig) != sigLen {
+			t.Fatalf("signature %d is %d bytes long", i, len(sig))
+		}
+
+		r, s, ok := parseSignature(priv.Curve, sig)
+		if !ok {
+			t.Fatalf("signature %d doesn't parse", i)
+		}
+		if s.Cmp(halfN) > 0 {
+			t.Fatalf("signature %d has a high s", i)
+		}
+		if !ecdsa.Verify(&priv.PublicKey, hash[:], r, s) {
+			t.Fatalf("signature %d doesn't verify", i)
+		}
+
+		// the high-S twin verifies with ecdsa but is not a valid encoding
+		highS := append(padScalar(r), padScalar(new(big.Int).Sub(n, s))...)
+		if _, _, ok := parseSignature(priv.Curve, highS); ok {
+			t.Fatalf("high-S form of signature %d was accepted", i)
+		}
+	}
+}
+
+func equalHex(a, b string) bool {
+	return bytes.EqualFold([]byte(a), []byte(b))
+}
+
+// shortCoordKey returns the first private key whose X, or Y when shortY is set, has a leading zero byte while the other coordinate doesn't
+func shortCoordKey(t *testing.T, shortY bool) *ecdsa.PrivateKey {
+	t.Helper()
+
+	curve := elliptic.P256()
+	for d := int64(1); d < 1<<16; d++ {
+		priv := &ecdsa.PrivateKey{D: big.NewInt(d)}
+		priv.Curve = curve
+		priv.X, priv.Y = curve.ScalarBaseMult(padScalar(priv.D))
+		short, full := priv.X, priv.Y
+		if shortY {
+			short, full = full, short
+		}
+		if len(short.Bytes()) < coordLen && len(full.Bytes()) == coordLen {
+			return priv
+		}
+	}
+	t.Fatal("no key with a short coordinate")
+	return nil
+}
+
+func TestParseLegacyPubKey(t *testing.T) {
+	for _, shortY := range []bool{false, true} {
+		priv := shortCoordKey(t, shortY)
+		legacy := append(priv.X.Bytes(), priv.Y.Bytes()...)
+		if len(legacy) != 2*coordLen-1 {
+			t.Fatalf("legacy key is %d bytes long", len(legacy))
+		}
+
+		pub, ok := parsePubKey(priv.Curve, legacy)
+		if !ok {
+			t.Fatalf("short Y %v: legacy key %x was refused", shortY, legacy)
+		}
+		if pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+			t.Fatalf("short Y %v: parsed another point", shortY)
+		}
+		hash := sha256.Sum256(legacy)
+		r, s, _ := parseSignature(priv.Curve, signHash(priv, hash[:]))
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			t.Errorf("short Y %v: signature doesn't verify with the parsed key", shortY)
+		}
+	}
+
+	priv := rfc6979Key(t)
+	for name, pubKey := range map[string][]byte{
+		"full legacy": append(padScalar(priv.X), padScalar(priv.Y)...),
+		"compressed":  compressPubKey(&priv.PublicKey),
+	} {
+		if pub, ok := parsePubKey(priv.Curve, pubKey); !ok || pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+			t.Errorf("%s key wasn't parsed", name)
+		}
+	}
+	for name, pubKey := range map[string][]byte{
+		"empty":     nil,
+		"truncated": append(padScalar(priv.X), padScalar(priv.Y)...)[:2*coordLen-1],
+		"too long":  append(append(padScalar(priv.X), padScalar(priv.Y)...), 0),
+	} {
+		if _, ok := parsePubKey(priv.Curve, pubKey); ok {
+			t.Errorf("%s key was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// heightsBucket maps block hashes to their height
+	heightsBucket = "heights"
+	// blockHeadersBucket maps block hashes to their header, headers are kept when a pruned node deletes the block
+	blockHeadersBucket = "blockheaders"
+	// addrIndexBucket indexes the chainstate by pubkey hash, see addrIndexKey
+	addrIndexBucket = "addrindex"
+	// mainChainBucket maps the heights of the blocks leading to the tip to their hash, it follows the tip as SetTip moves it
+	mainChainBucket = "mainchain"
+)
+
+// Store is the storage backend of the blockchain. Every read happens inside View and every write inside Update; the writes of an Update are committed atomically when fn returns nil and discarded when it returns an error.
+type Store interface {
+	View(fn func(tx StoreTx) error) error
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// StoreTx is a transaction on a Store. Besides the typed accessors for blocks, the tip and the UTXO set it gives raw access to named buckets for auxiliary data such as block filters. Values returned by Get or passed to ForEach are only valid until the transaction ends and must be copied to be kept.
+type StoreTx interface {
+	// Block returns the block with the given hash, ErrBlockPruned if only its header is left or ErrNotFound
+	Block(hash []byte) (*Block, error)
+	// Header returns the header of the block with the given hash or ErrNotFound
+	Header(hash []byte) (*BlockHeader, error)
+	// PutBlock stores a block, its header and its height, its parent has to be stored already
+	PutBlock(b *Block) error
+	// PutHeader stores a header and sets its height without the block's transactions, as if the block was pruned. Its parent has to be stored already.
+	PutHeader(h *BlockHeader) error
+	// PruneBlock deletes the transactions of a block, its header and height are kept
+	PruneBlock(hash []byte) error
+	// Tip returns the hash of the last block, nil for an empty store
+	Tip() []byte
+	// SetTip makes hash the last block and indexes the chain leading to it by height, the headers of that chain have to be stored
+	SetTip(hash []byte) error
+	// MainChainHash returns the hash of the block at height on the chain leading to the tip or ErrNotFound
+	MainChainHash(height int64) ([]byte, error)
+
+	// UTXO returns the unspent outputs of a transaction or ErrNotFound
+	UTXO(txID []byte) (*TxOutputs, error)
+	PutUTXO(txID []byte, outs *TxOutputs) error
+	DeleteUTXO(txID []byte) error
+	// ForEachUTXO calls fn for every transaction with unspent outputs, in txID order
+	ForEachUTXO(fn func(txID []byte, outs *TxOutputs) error) error
+	// ForEachAddressUTXO calls fn for every unspent output locked with pubKeyHash, using the address index
+	ForEachAddressUTXO(pubKeyHash []byte, fn func(txID []byte, index int, out *TxOutput) error) error
+	// ResetUTXO empties the UTXO set
+	ResetUTXO() error
+
+	kvTx
+}
+
+// kvTx is the raw key/value transaction a backend has to provide. Buckets are created on the first Put, reads from a missing bucket find nothing.
+type kvTx interface {
+	Get(bucket, key []byte) []byte
+	Put(bucket, key, value []byte) error
+	Delete(bucket, key []byte) error
+	// ForEach calls fn for every key of bucket in byte order. The bucket must not be modified by fn.
+	ForEach(bucket []byte, fn func(k, v []byte) error) error
+	// ForEachPrefix is ForEach limited to the keys starting with prefix
+	ForEachPrefix(bucket, prefix []byte, fn func(k, v []byte) error) error
+	DeleteBucket(bucket []byte) error
+}
+
+// storeTx implements the typed part of StoreTx on top of a backend's raw transaction
+type storeTx struct {
+	kvTx
+}
+
+func (tx *storeTx) Block(hash []byte) (*Block, error) {
+	v := tx.Get([]byte(blocksBucket), hash)
+	if v == nil {
+		if tx.Get([]byte(blockHeadersBucket), hash) != nil {
+			return nil, ErrBlockPruned
+		}
+		return nil, ErrNotFound
+	}
+	b, err := BytesToBlock(v)
+	if err != nil {
+		return nil, err
+	}
+	b.Height = tx.height(hash)
+	return b, nil
+}
+
+func (tx *storeTx) Header(hash []byte) (*BlockHeader, error) {
+	v := tx.Get([]byte(blockHeadersBucket), hash)
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	h, err := BytesToBlockHeader(v)
+	if err != nil {
+		return nil, err
+	}
+	h.Height = tx.height(hash)
+	return h, nil
+}
+
+func (tx *storeTx) height(hash []byte) int64 {
+	if height := tx.Get([]byte(heightsBucket), hash); len(height) == 8 {
+		return int64(binary.BigEndian.Uint64(height))
+	}
+	return 0
+}
+
+func (tx *storeTx) PutBlock(b *Block) error {
+	header, err := b.Header()
+	if err != nil {
+		return err
+	}
+	if err := tx.PutHeader(header); err != nil {
+		return err
+	}
+	b.Height = header.Height
+
+	blockBytes, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	return tx.Put([]byte(blocksBucket), b.Hash, blockBytes)
+}
+
+func (tx *storeTx) PutHeader(h *BlockHeader) error {
+	h.Height = 0
+	if len(h.PrevBlockHash) > 0 {
+		prevHeight := tx.Get([]byte(heightsBucket), h.PrevBlockHash)
+		if len(prevHeight) != 8 {
+			return ErrNotFound
+		}
+		h.Height = int64(binary.BigEndian.Uint64(prevHeight)) + 1
+	}
+
+	headerBytes, err := h.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := tx.Put([]byte(blockHeadersBucket), h.Hash, headerBytes); err != nil {
+		return err
+	}
+	return tx.Put([]byte(heightsBucket), h.Hash, IntToByte(h.Height))
+}
+
+func (tx *storeTx) PruneBlock(hash []byte) error {
+	if tx.Get([]byte(blockHeadersBucket), hash) == nil {
+		return ErrNotFound
+	}
+	return tx.Delete([]byte(blocksBucket), hash)
+}
+
+func (tx *storeTx) Tip() []byte {
+	tip := tx.Get([]byte(blocksBucket), []byte(lastHashKey))
+	if len(tip) == 0 {
+		return nil
+	}
+	return append([]byte{}, tip...)
+}
+
+// SetTip rewrites the height index from the new tip back to the first height where it already holds the new chain, and drops the heights past the new tip
+func (tx *storeTx) SetTip(hash []byte) error {
+	header, err := tx.Header(hash)
+	if err != nil {
+		return err
+	}
+	for height := header.Height + 1; tx.Get([]byte(mainChainBucket), IntToByte(height)) != nil; height++ {
+		if err := tx.Delete([]byte(mainChainBucket), IntToByte(height)); err != nil {
+			return err
+		}
+	}
+	for h := header; !bytes.Equal(tx.Get([]byte(mainChainBucket), IntToByte(h.Height)), h.Hash); {
+		if err := tx.Put([]byte(mainChainBucket), IntToByte(h.Height), h.Hash); err != nil {
+			return err
+		}
+		if len(h.PrevBlockHash) == 0 {
+			break
+		}
+		if h, err = tx.Header(h.PrevBlockHash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Put([]byte(blocksBucket), []byte(lastHashKey), hash)
+}
+
+func (tx *storeTx) MainChainHash(height int64) ([]byte, error) {
+	hash := tx.Get([]byte(mainChainBucket), IntToByte(height))
+	if hash == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, hash...), nil
+}
+
+func (tx *storeTx) UTXO(txID []byte) (*TxOutputs, error) {
+	outs := NewTxOutputs()
+	if err := tx.ForEachPrefix([]byte(utxoBucket), txID, func(k, v []byte) error {
+		if len(k) != len(txID)+4 {
+			return nil
+		}
+		out, err := BytesToTxOutput(v)
+		if err != nil {
+			return err
+		}
+		outs.Outputs[int(binary.BigEndian.Uint32(k[len(txID):]))] = out
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(outs.Outputs) == 0 {
+		return nil, ErrNotFound
+	}
+	return outs, nil
+}
+
+// PutUTXO replaces the unspent outputs of a transaction, the outputs missing from outs are deleted along with their address index entries
+func (tx *storeTx) PutUTXO(txID []byte, outs *TxOutputs) error {
+	stored, err := tx.UTXO(txID)
+	if err == ErrNotFound {
+		stored = NewTxOutputs()
+	} else if err != nil {
+		return err
+	}
+
+	for i, out := range stored.Outputs {
+		if sameOutput(out, outs.Outputs[i]) {
+			continue
+		}
+		if err := tx.deleteOutput(txID, i, out); err != nil {
+			return err
+		}
+	}
+	for i, out := range outs.Outputs {
+		if sameOutput(out, stored.Outputs[i]) {
+			continue
+		}
+		outBytes, err := out.Bytes()
+		if err != nil {
+			return err
+		}
+		if err := tx.Put([]byte(utxoBucket), outPointKey(txID, i), outBytes); err != nil {
+			return err
+		}
+		if err := tx.Put([]byte(addrIndexBucket), addrIndexKey(out.PubKeyHash, txID, i), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *storeTx) DeleteUTXO(txID []byte) error {
+	stored, err := tx.UTXO(txID)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for i, out := range stored.Outputs {
+		if err := tx.deleteOutput(txID, i, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *storeTx) deleteOutput(txID []byte, index int, out *TxOutput) error {
+	if err := tx.Delete([]byte(utxoBucket), outPointKey(txID, index)); err != nil {
+		return err
+	}
+	return tx.Delete([]byte(addrIndexBucket), addrIndexKey(out.PubKeyHash, txID, index))
+}
+
+// ForEachUTXO reads the outputs in key order, the outputs of a transaction are next to each other
+func (tx *storeTx) ForEachUTXO(fn func(txID []byte, outs *TxOutputs) error) error {
+	var txID []byte
+	var outs *TxOutputs
+	if err := tx.ForEach([]byte(utxoBucket), func(k, v []byte) error {
+		if len(k) < 4 {
+			return ErrMalformedEncoding
+		}
+		id := k[:len(k)-4]
+		if outs != nil && !bytes.Equal(id, txID) {
+			if err := fn(txID, outs); err != nil {
+				return err
+			}
+			outs = nil
+		}
+		if outs == nil {
+			txID, outs = append([]byte{}, id...), NewTxOutputs()
+		}
+
+		out, err := BytesToTxOutput(v)
+		if err != nil {
+			return err
+		}
+		outs.Outputs[int(binary.BigEndian.Uint32(k[len(id):]))] = out
+		return nil
+	}); err != nil {
+		return err
+	}
+	if outs == nil {
+		return nil
+	}
+	return fn(txID, outs)
+}
+
+func (tx *storeTx) ForEachAddressUTXO(pubKeyHash []byte, fn func(txID []byte, index int, out *TxOutput) error) error {
+	prefix := addrIndexKey(pubKeyHash, nil, 0)
+	prefix = prefix[:len(prefix)-4]
+	return tx.ForEachPrefix([]byte(addrIndexBucket), prefix, func(k, v []byte) error {
+		outPoint := k[len(prefix):]
+		if len(outPoint) < 4 {
+			return ErrMalformedEncoding
+		}
+		outBytes := tx.Get([]byte(utxoBucket), outPoint)
+		if outBytes == nil {
+			return ErrNotFound
+		}
+		out, err := BytesToTxOutput(outBytes)
+		if err != nil {
+			return err
+		}
+		txID := append([]byte{}, outPoint[:len(outPoint)-4]...)
+		return fn(txID, int(binary.BigEndian.Uint32(outPoint[len(txID):])), out)
+	})
+}
+
+func (tx *storeTx) ResetUTXO() error {
+	if err := tx.DeleteBucket([]byte(utxoBucket)); err != nil {
+		return err
+	}
+	return tx.DeleteBucket([]byte(addrIndexBucket))
+}
+
+func sameOutput(a, b *TxOutput) bool {
+	return a != nil && b != nil && a.Value == b.Value && bytes.Equal(a.PubKeyHash, b.PubKeyHash)
+}
+
+// outPointKey is the chainstate key of an output: the transaction ID followed by the big endian output index, so the outputs of a transaction are stored next to each other
+func outPointKey(txID []byte, index int) []byte {
+	key := make([]byte, len(txID)+4)
+	copy(key, txID)
+	binary.BigEndian.PutUint32(key[len(txID):], uint32(index))
+	return key
+}
+
+// addrIndexKey is the address index key of an output: the length of the pubkey hash, the pubkey hash and the output's chainstate key. The length keeps a pubkey hash from matching the prefix of a longer one.
+func addrIndexKey(pubKeyHash, txID []byte, index int) []byte {
+	key := append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+	return append(key, outPointKey(txID, index)...)
+}
@@ -0,0 +1,99 @@
+package hoji
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is the default Store, backed by a bolt database file
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the bolt database at path. A bolt database can only be opened by one process at a time: if another one holds it for longer than timeout NewBoltStore fails with ErrDatabaseLocked. A timeout of 0 waits forever.
+func NewBoltStore(path string, timeout time.Duration) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseLocked, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&storeTx{boltTx{tx}})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&storeTx{boltTx{tx}})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Get(bucket, key []byte) []byte {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return b.Get(key)
+}
+
+func (t boltTx) Put(bucket, key, value []byte) error {
+	b, err := t.tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+func (t boltTx) Delete(bucket, key []byte) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete(key)
+}
+
+func (t boltTx) ForEach(bucket []byte, fn func(k, v []byte) error) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(fn)
+}
+
+func (t boltTx) ForEachPrefix(bucket, prefix []byte, fn func(k, v []byte) error) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t boltTx) DeleteBucket(bucket []byte) error {
+	if err := t.tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package hoji
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryStore is a Store kept in memory, mostly useful for tests. Updates copy the buckets they write to and swap them in on commit so a failed Update leaves the store untouched.
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemoryStore returns an empty in-memory Store
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return fn(&storeTx{&memoryTx{buckets: s.buckets}})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+	tx := &memoryTx{
+		buckets:  s.buckets,
+		dirty:    make(map[string]map[string][]byte),
+		writable: true,
+	}
+	if err := fn(&storeTx{tx}); err != nil {
+		return err
+	}
+
+	for name, b := range tx.dirty {
+		if b == nil {
+			delete(s.buckets, name)
+		} else {
+			s.buckets[name] = b
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+type memoryTx struct {
+	buckets map[string]map[string][]byte
+	// dirty holds the copies of the buckets written by the transaction, a nil bucket is a deleted one
+	dirty    map[string]map[string][]byte
+	writable bool
+}
+
+// bucket returns the bucket to read from, or with write set a private copy to modify
+func (t *memoryTx) bucket(name []byte, write bool) map[string][]byte {
+	if b, ok := t.dirty[string(name)]; ok {
+		if b == nil && write {
+			b = make(map[string][]byte)
+			t.dirty[string(name)] = b
+		}
+		return b
+	}
+	if !write {
+		return t.buckets[string(name)]
+	}
+
+	b := make(map[string][]byte, len(t.buckets[string(name)]))
+	for k, v := range t.buckets[string(name)] {
+		b[k] = v
+	}
+	t.dirty[string(name)] = b
+	return b
+}
+
+// Get returns a copy of the value, a caller modifying it must not change the store
+func (t *memoryTx) Get(bucket, key []byte) []byte {
+	v, ok := t.bucket(bucket, false)[string(key)]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, v...)
+}
+
+func (t *memoryTx) Put(bucket, key, value []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	t.bucket(bucket, true)[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTx) Delete(bucket, key []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	delete(t.bucket(bucket, true), string(key))
+	return nil
+}
+
+func (t *memoryTx) ForEach(bucket []byte, fn func(k, v []byte) error) error {
+	return t.ForEachPrefix(bucket, nil, fn)
+}
+
+func (t *memoryTx) ForEachPrefix(bucket, prefix []byte, fn func(k, v []byte) error) error {
+	b := t.bucket(bucket, false)
+
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := fn([]byte(k), append([]byte{}, b[k]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *memoryTx) DeleteBucket(bucket []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	t.dirty[string(bucket)] = nil
+	return nil
+}
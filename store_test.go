@@ -0,0 +1,329 @@
+package hoji
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStores returns a new store of every backend, they are closed at the end of the test
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{"bolt": bolt, "memory": NewMemoryStore()}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	})
+	return stores
+}
+
+func TestStoreKeyValue(t *testing.T) {
+	bucket := []byte("bucket")
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Update(func(tx StoreTx) error {
+				for _, k := range []string{"b2", "a", "b1", "c"} {
+					if err := tx.Put(bucket, []byte(k), []byte("value "+k)); err != nil {
+						return err
+					}
+				}
+				if err := tx.Put(bucket, []byte("empty"), []byte{}); err != nil {
+					return err
+				}
+				return tx.Delete(bucket, []byte("c"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.View(func(tx StoreTx) error {
+				if got := tx.Get(bucket, []byte("a")); string(got) != "value a" {
+					t.Errorf("a = %q", got)
+				}
+				if got := tx.Get(bucket, []byte("c")); got != nil {
+					t.Errorf("deleted key c = %q", got)
+				}
+				if got := tx.Get(bucket, []byte("empty")); got == nil || len(got) != 0 {
+					t.Errorf("empty value = %#v, want an empty non-nil slice", got)
+				}
+				if got := tx.Get([]byte("missing"), []byte("a")); got != nil {
+					t.Errorf("key of a missing bucket = %q", got)
+				}
+
+				var keys []string
+				if err := tx.ForEachPrefix(bucket, []byte("b"), func(k, v []byte) error {
+					keys = append(keys, string(k))
+					return nil
+				}); err != nil {
+					return err
+				}
+				if len(keys) != 2 || keys[0] != "b1" || keys[1] != "b2" {
+					t.Errorf("keys with prefix b = %v, want [b1 b2]", keys)
+				}
+
+				if err := tx.Put(bucket, []byte("a"), []byte("x")); err == nil {
+					t.Error("Put succeeded in a View")
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStoreUpdateRollback(t *testing.T) {
+	bucket := []byte("bucket")
+	failed := errors.New("failed")
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Update(func(tx StoreTx) error {
+				return tx.Put(bucket, []byte("kept"), []byte("1"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := store.Update(func(tx StoreTx) error {
+				if err := tx.Put(bucket, []byte("kept"), []byte("2")); err != nil {
+					return err
+				}
+				if err := tx.Put(bucket, []byte("new"), []byte("1")); err != nil {
+					return err
+				}
+				if err := tx.DeleteBucket([]byte("other")); err != nil {
+					return err
+				}
+				return failed
+			})
+			if err != failed {
+				t.Fatalf("got %v, want the error of fn", err)
+			}
+
+			if err := store.View(func(tx StoreTx) error {
+				if got := tx.Get(bucket, []byte("kept")); string(got) != "1" {
+					t.Errorf("kept = %q after a failed update, want 1", got)
+				}
+				if got := tx.Get(bucket, []byte("new")); got != nil {
+					t.Errorf("new = %q after a failed update", got)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReturnsCopies(t *testing.T) {
+	store := NewMemoryStore()
+	bucket, key := []byte("bucket"), []byte("key")
+	if err := store.Update(func(tx StoreTx) error {
+		return tx.Put(bucket, key, []byte("value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.View(func(tx StoreTx) error {
+		tx.Get(bucket, key)[0] = 'X'
+		return tx.ForEach(bucket, func(k, v []byte) error {
+			v[0] = 'Y'
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.View(func(tx StoreTx) error {
+		if got := tx.Get(bucket, key); string(got) != "value" {
+			t.Errorf("value = %q after the caller modified what it read", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreClosed(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.View(func(tx StoreTx) error { return nil }); err == nil {
+				t.Error("View succeeded on a closed store")
+			}
+			if err := store.Update(func(tx StoreTx) error { return nil }); err == nil {
+				t.Error("Update succeeded on a closed store")
+			}
+		})
+	}
+}
+
+func TestStoreBlocksAndUTXO(t *testing.T) {
+	genesis, err := RegTestParams.GenesisBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	pubKeyHash := coinbase.Outputs[0].PubKeyHash
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Update(func(tx StoreTx) error {
+				if err := tx.PutBlock(genesis); err != nil {
+					return err
+				}
+				if err := tx.SetTip(genesis.Hash); err != nil {
+					return err
+				}
+				return updateUTXO(tx, genesis)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.View(func(tx StoreTx) error {
+				if !bytes.Equal(tx.Tip(), genesis.Hash) {
+					t.Errorf("tip is %x", tx.Tip())
+				}
+				block, err := tx.Block(genesis.Hash)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(block.Hash, genesis.Hash) || block.Height != 0 {
+					t.Errorf("read block %x at height %d", block.Hash, block.Height)
+				}
+				if _, err := tx.Block([]byte("missing")); err != ErrNotFound {
+					t.Errorf("missing block: got %v, want ErrNotFound", err)
+				}
+
+				outs, err := tx.UTXO(coinbase.ID)
+				if err != nil {
+					return err
+				}
+				if got := outs.Indexes(); len(got) != 1 || got[0] != 0 {
+					t.Errorf("unspent outputs of the coinbase: %v", got)
+				}
+				found := 0
+				if err := tx.ForEachAddressUTXO(pubKeyHash, func(txID []byte, index int, out *TxOutput) error {
+					found++
+					if !bytes.Equal(txID, coinbase.ID) || index != 0 {
+						t.Errorf("address index returned %x:%d", txID, index)
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				if found != 1 {
+					t.Errorf("address index returned %d outputs, want 1", found)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Update(func(tx StoreTx) error {
+				if err := tx.DeleteUTXO(coinbase.ID); err != nil {
+					return err
+				}
+				return tx.PruneBlock(genesis.Hash)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.View(func(tx StoreTx) error {
+				if _, err := tx.UTXO(coinbase.ID); err != ErrNotFound {
+					t.Errorf("deleted UTXO: got %v, want ErrNotFound", err)
+				}
+				if err := tx.ForEachAddressUTXO(pubKeyHash, func(txID []byte, index int, out *TxOutput) error {
+					t.Errorf("address index still has %x:%d", txID, index)
+					return nil
+				}); err != nil {
+					return err
+				}
+				if _, err := tx.Block(genesis.Hash); err != ErrBlockPruned {
+					t.Errorf("pruned block: got %v, want ErrBlockPruned", err)
+				}
+				if _, err := tx.Header(genesis.Hash); err != nil {
+					t.Errorf("header of a pruned block: %v", err)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// holdDatabaseEnv names the database TestHoldDatabase holds open when the test binary runs as a helper process
+const holdDatabaseEnv = "HOJI_HOLD_DATABASE"
+
+// TestHoldDatabase is the helper process of TestBoltStoreLocked: it opens the database, reports it and keeps it open until its stdin is closed
+func TestHoldDatabase(t *testing.T) {
+	path := os.Getenv(holdDatabaseEnv)
+	if path == "" {
+		t.Skip("only runs as a helper process")
+	}
+
+	store, err := NewBoltStore(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	os.Stdout.WriteString("open\n")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+}
+
+func TestBoltStoreLocked(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dataDir, "regtest"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dataDir, "regtest", dbFile)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHoldDatabase$")
+	cmd.Env = append(os.Environ(), holdDatabaseEnv+"="+path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "open\n" {
+		t.Fatalf("helper process didn't open the database: %q %v", line, err)
+	}
+
+	if _, err := NewBoltStore(path, 100*time.Millisecond); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("got %v, want ErrDatabaseLocked", err)
+	}
+	_, err = NewBlockchain(WithNetwork("regtest"), WithDataDir(dataDir), WithOpenTimeout(100*time.Millisecond))
+	if !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("opening the blockchain got %v, want ErrDatabaseLocked", err)
+	}
+
+	// the database can be opened once the other process is gone
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewBoltStore(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+}
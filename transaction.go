@@ -6,25 +6,21 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"errors"
-	"log"
-	"math/big"
+	"math"
 )
 
 // Transaction represents a Hoji transaction. Maybe split transaction into 2 separe structs transaction and coinbase transaction
 type Transaction struct {
 	ID      []byte
+	Version uint32
 	Inputs  []*TxInput
 	Outputs []*TxOutput
 }
 
-// subsidy is the reward "coins" given for a miner
-const subsidy = 10
-
-// NewCoinbaseTx a coinbase transaction is a transaction that does not require inputs to generate outputs. The gensis block is a coinbase transaction and when miners mine new blocks their reward is a coinbase transaction.
-func NewCoinbaseTx(to, data []byte) (*Transaction, error) {
+// NewCoinbaseTx a coinbase transaction is a transaction that does not require inputs to generate outputs. The gensis block is a coinbase transaction and when miners mine new blocks their reward is a coinbase transaction. value is the reward, see ChainParams.Subsidy.
+func NewCoinbaseTx(to, data []byte, value int) (*Transaction, error) {
 	if data == nil {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -35,14 +31,14 @@ func NewCoinbaseTx(to, data []byte) (*Transaction, error) {
 		data = randData
 	}
 	txIn := &TxInput{
-		TxID:      []byte{},
+		PrevOut:   NewOutPoint([]byte{}, -1),
 		PubKey:    data,
 		Signature: nil,
-		outIndex:  -1,
 	}
-	txOut := NewTxOutput(subsidy, to)
+	txOut := NewTxOutput(value, to)
 
 	tx := &Transaction{
+		Version: txVersion,
 		Inputs:  []*TxInput{txIn},
 		Outputs: []*TxOutput{txOut},
 	}
@@ -59,7 +55,7 @@ func (bc *Blockchain) NewTx(from, to []byte, amount int) (*Transaction, error) {
 	var inputs []*TxInput
 	var outputs []*TxOutput
 
-	wallets, err := NewWallets()
+	wallets, err := NewWallets(bc.opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -76,9 +72,8 @@ func (bc *Blockchain) NewTx(from, to []byte, amount int) (*Transaction, error) {
 	for _, so := range spendableOutputs {
 		accumulated += so.Value
 		in := &TxInput{
-			TxID:     so.TxID,
-			outIndex: so.index,
-			PubKey:   wallet.PublicKey,
+			PrevOut: so.OutPoint,
+			PubKey:  wallet.PublicKey,
 		}
 		inputs = append(inputs, in)
 	}
@@ -90,166 +85,243 @@ func (bc *Blockchain) NewTx(from, to []byte, amount int) (*Transaction, error) {
 	}
 
 	tx := &Transaction{
+		Version: txVersion,
 		Outputs: outputs,
 		Inputs:  inputs,
 	}
-	txID, err := tx.hashTransaction()
-	if err != nil {
+
+	if err := bc.SignTx(tx, wallet.PrivateKey); err != nil {
 		return nil, err
 	}
-	tx.ID = txID
 
-	if err := bc.SignTx(tx, wallet.PrivateKey); err != nil {
+	// the id covers the signatures so it can only be computed once the inputs are signed
+	txID, err := tx.hashTransaction()
+	if err != nil {
 		return nil, err
 	}
+	tx.ID = txID
 
 	return tx, nil
 }
 
-//Sign is
+//Sign signs every input of the transaction with SigHashAll
 func (t *Transaction) Sign(privateKey *ecdsa.PrivateKey, prevTxs map[string]*Transaction) error {
 	if t.IsCoinbase() {
 		return nil
 	}
 
-	for _, input := range t.Inputs {
-		if prevTxs[hex.EncodeToString(input.TxID)].ID == nil {
-			return errors.New("ERROR: Previous transaction is not correct")
+	for inputIndex := range t.Inputs {
+		if err := t.SignInput(inputIndex, privateKey, prevTxs, SigHashAll); err != nil {
+			return err
 		}
 	}
 
-	trimmedTx := t.Trim()
+	return nil
+}
 
-	for inputIndex, input := range trimmedTx.Inputs {
-		prevTx := prevTxs[hex.EncodeToString(input.TxID)]
-		trimmedTx.Inputs[inputIndex].Signature = nil
-		trimmedTx.Inputs[inputIndex].PubKey = prevTx.Outputs[input.outIndex].PubKeyHash
-		x := new(big.Int)
-		y := new(big.Int)
-		keyLen := len(input.PubKey)
-		x.SetBytes(trimmedTx.Inputs[inputIndex].PubKey[:(keyLen / 2)])
-		y.SetBytes(trimmedTx.Inputs[inputIndex].PubKey[(keyLen / 2):])
+//SignInput signs a single input with the given hash type. The signature is the fixed width r || s followed by the hash type byte.
+func (t *Transaction) SignInput(inputIndex int, privateKey *ecdsa.PrivateKey, prevTxs map[string]*Transaction, hashType SigHashType) error {
+	if inputIndex < 0 || inputIndex >= len(t.Inputs) {
+		return ErrBadRequest
+	}
+	prevOut, err := spentOutput(t.Inputs[inputIndex], prevTxs)
+	if err != nil {
+		return err
+	}
 
-		txID, err := trimmedTx.hashTransaction()
-		if err != nil {
-			return err
-		}
-		trimmedTx.ID = txID
-		trimmedTx.Inputs[inputIndex].PubKey = nil
+	hash, err := t.SigHash(inputIndex, prevOut.PubKeyHash, hashType)
+	if err != nil {
+		return err
+	}
 
-		r, s, err := ecdsa.Sign(rand.Reader, privateKey, trimmedTx.ID)
-		if err != nil {
-			log.Panic(err)
-		}
-		signature := append(r.Bytes(), s.Bytes()...)
+	t.Inputs[inputIndex].Signature = append(signHash(privateKey, hash), byte(hashType))
 
-		t.Inputs[inputIndex].Signature = signature
+	return nil
+}
+
+//Trim returns a copy of the transaction without the signatures and public keys of its inputs.
+//
+// Deprecated: signatures no longer commit to a trimmed copy, use SigHash to get the digest an input signs.
+func (t *Transaction) Trim() *Transaction {
+	var inputs []*TxInput
+	var outputs []*TxOutput
 
+	for _, input := range t.Inputs {
+		inputs = append(inputs, &TxInput{PrevOut: input.PrevOut})
+	}
+	for _, output := range t.Outputs {
+		outputs = append(outputs, &TxOutput{Value: output.Value, PubKeyHash: output.PubKeyHash})
 	}
 
-	return nil
+	return &Transaction{ID: t.ID, Version: t.Version, Inputs: inputs, Outputs: outputs}
 }
 
-//Verify is
+//Verify checks that every input is signed by the owner of the output it spends
 func (t *Transaction) Verify(prevTxs map[string]*Transaction) (bool, error) {
 	if t.IsCoinbase() {
 		return true, nil
 	}
 
-	for _, input := range t.Inputs {
-		if prevTxs[hex.EncodeToString(input.TxID)].ID == nil {
-			return false, errors.New("ERROR: Previous transaction is not correct")
+	for inputIndex := range t.Inputs {
+		ok, err := t.verifyInput(inputIndex, prevTxs)
+		if err != nil || !ok {
+			return false, err
 		}
 	}
 
-	trimmedTx := t.Trim()
+	return true, nil
+}
+
+// verifyInput checks the signature of a single input against the parts of the transaction its hash type commits to
+func (t *Transaction) verifyInput(inputIndex int, prevTxs map[string]*Transaction) (bool, error) {
 	curve := elliptic.P256()
+	input := t.Inputs[inputIndex]
 
-	for inputIndex, input := range t.Inputs {
-		prevTx := prevTxs[hex.EncodeToString(input.TxID)]
-		trimmedTx.Inputs[inputIndex].Signature = nil
-		trimmedTx.Inputs[inputIndex].PubKey = prevTx.Outputs[input.outIndex].PubKeyHash
-		txID, err := trimmedTx.hashTransaction()
-		if err != nil {
-			return false, err
-		}
-		trimmedTx.ID = txID
-		trimmedTx.Inputs[inputIndex].PubKey = nil
-
-		r := new(big.Int)
-		s := new(big.Int)
-		sigLen := len(input.Signature)
-		r.SetBytes(input.Signature[:(sigLen / 2)])
-		s.SetBytes(input.Signature[(sigLen / 2):])
-
-		x := new(big.Int)
-		y := new(big.Int)
-		keyLen := len(input.PubKey)
-		x.SetBytes(input.PubKey[:(keyLen / 2)])
-		y.SetBytes(input.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{
-			Curve: curve,
-			X:     x,
-			Y:     y,
-		}
-		if ecdsa.Verify(&rawPubKey, trimmedTx.ID, r, s) == false {
-			return false, nil
-		}
+	prevOut, err := spentOutput(input, prevTxs)
+	if err != nil {
+		return false, err
 	}
 
-	return true, nil
+	if len(input.Signature) != sigLen+1 {
+		return false, nil
+	}
+	hashType := SigHashType(input.Signature[sigLen])
+	if !hashType.valid() {
+		return false, nil
+	}
+	r, s, ok := parseSignature(curve, input.Signature[:sigLen])
+	if !ok {
+		return false, nil
+	}
+	pubKey, ok := parsePubKey(curve, input.PubKey)
+	if !ok {
+		return false, nil
+	}
+	usesKey, err := input.UsesKey(prevOut.PubKeyHash)
+	if err != nil {
+		return false, err
+	}
+	if !usesKey {
+		return false, nil
+	}
+
+	hash, err := t.SigHash(inputIndex, prevOut.PubKeyHash, hashType)
+	if err == ErrInvalidSigHash {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return ecdsa.Verify(pubKey, hash, r, s), nil
 }
 
-//Trim is
-func (t *Transaction) Trim() *Transaction {
-	var inputs []*TxInput
-	var outputs []*TxOutput
+// spentOutput looks up the output an input spends in the previous transactions
+func spentOutput(input *TxInput, prevTxs map[string]*Transaction) (*TxOutput, error) {
+	prevTx := prevTxs[hex.EncodeToString(input.PrevOut.TxID)]
+	if prevTx == nil || prevTx.ID == nil {
+		return nil, errors.New("ERROR: Previous transaction is not correct")
+	}
+	if input.PrevOut.Index < 0 || input.PrevOut.Index >= len(prevTx.Outputs) || prevTx.Outputs[input.PrevOut.Index] == nil {
+		return nil, errors.New("ERROR: Previous output index is not correct")
+	}
 
-	for _, input := range t.Inputs {
-		in := &TxInput{
-			TxID:     input.TxID,
-			outIndex: input.outIndex,
+	return prevTx.Outputs[input.PrevOut.Index], nil
+}
+
+// checkTxValues makes sure a transaction doesn't create value: every output pays something, the sums don't overflow and a transaction that isn't a coinbase pays out no more than the outputs it spends, found in prevTxs like for Verify
+func checkTxValues(t *Transaction, prevTxs map[string]*Transaction) error {
+	coinbase := t.IsCoinbase()
+
+	outputs := 0
+	for _, out := range t.Outputs {
+		// a coinbase pays nothing once the subsidy has run out
+		if out == nil || out.Value < 0 || (out.Value == 0 && !coinbase) {
+			return ErrInvalidValue
 		}
-		inputs = append(inputs, in)
+		if outputs > math.MaxInt-out.Value {
+			return ErrInvalidValue
+		}
+		outputs += out.Value
+	}
+	if coinbase {
+		return nil
 	}
 
-	for _, ouput := range outputs {
-		out := &TxOutput{
-			Value:      ouput.Value,
-			PubKeyHash: ouput.PubKeyHash,
+	inputs := 0
+	for _, input := range t.Inputs {
+		prevOut, err := spentOutput(input, prevTxs)
+		if err != nil {
+			return err
+		}
+		if prevOut.Value < 0 || inputs > math.MaxInt-prevOut.Value {
+			return ErrInvalidValue
 		}
-		outputs = append(outputs, out)
+		inputs += prevOut.Value
 	}
-
-	return &Transaction{
-		ID:      t.ID,
-		Inputs:  inputs,
-		Outputs: outputs,
+	if outputs > inputs {
+		return ErrValueExceedsInputs
 	}
+
+	return nil
 }
 
-//hashTransaction will hash all the transactions contents using sha256. hashTransaction will transform the transaction struct pointer into a byte array then sha256 hash it returing the hash.
+//hashTransaction will hash all the transactions contents using sha256. hashTransaction will transform the transaction struct pointer into its wire format (which doesn't include the ID) then sha256 hash it returing the hash.
 func (t *Transaction) hashTransaction() ([]byte, error) {
-	var txBytes bytes.Buffer
-	if err := gob.NewEncoder(&txBytes).Encode(t); err != nil {
+	txBytes, err := t.Bytes()
+	if err != nil {
 		return nil, err
 	}
 
-	hash := sha256.Sum256(txBytes.Bytes())
+	hash := sha256.Sum256(txBytes)
 	return hash[:], nil
 }
 
-//Bytes is
+//Bytes transforms a Transaction into its wire format
 func (t *Transaction) Bytes() ([]byte, error) {
 	var encoded bytes.Buffer
-	if err := gob.NewEncoder(&encoded).Encode(t); err != nil {
+	if err := writeTransaction(&encoded, t); err != nil {
 		return nil, err
 	}
 	return encoded.Bytes(), nil
 }
 
+//BytesToTransaction decodes a Transaction from its wire format and computes its ID
+func BytesToTransaction(v []byte) (*Transaction, error) {
+	var t *Transaction
+	if err := decodeAll(v, func(r *bytes.Reader) error {
+		var err error
+		t, err = readTransaction(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// touches reports whether the transaction pays to pubKeyHash or spends with a key hashing to it
+func (t *Transaction) touches(pubKeyHash []byte) (bool, error) {
+	for _, out := range t.Outputs {
+		if out.IsLockedWithKey(pubKeyHash) {
+			return true, nil
+		}
+	}
+	if t.IsCoinbase() {
+		return false, nil
+	}
+	for _, in := range t.Inputs {
+		ok, err := in.UsesKey(pubKeyHash)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // IsCoinbase checks whether the transaction is a coinbase tx
 func (t *Transaction) IsCoinbase() bool {
-	return len(t.Inputs) == 1 && len(t.Inputs[0].TxID) == 0 && t.Inputs[0].outIndex == -1
+	return len(t.Inputs) == 1 && t.Inputs[0].PrevOut.IsNull()
 }
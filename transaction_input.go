@@ -2,10 +2,9 @@ package hoji
 
 import "bytes"
 
-// TxInput references a previous output: Txid stores the ID of such transaction, and Vout stores an index of the  output it refrences in the transaction. ScriptSig is a script which provides data to be used in an output’s ScriptPubKey
+// TxInput references a previous output: PrevOut stores the ID of such transaction and the index of the output it refrences in the transaction. Signature and PubKey prove the input is allowed to spend that output's PubKeyHash
 type TxInput struct {
-	TxID      []byte // the output tx it refrences
-	outIndex  int
+	PrevOut   OutPoint // the output it spends
 	Signature []byte
 	PubKey    []byte
 }
@@ -19,3 +18,28 @@ func (in *TxInput) UsesKey(pubKeyHash []byte) (bool, error) {
 	ok := bytes.Compare(lockingHash, pubKeyHash) == 0
 	return ok, nil
 }
+
+//Bytes transforms an input into its wire format
+func (in *TxInput) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeTxInput(&buff, in); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToTxInput deserializes a TxInput
+func BytesToTxInput(data []byte) (*TxInput, error) {
+	var input *TxInput
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		input, err = readTxInput(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return input, nil
+}
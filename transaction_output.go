@@ -2,7 +2,8 @@ package hoji
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/binary"
+	"sort"
 
 	"gitlab.com/rodzzlessa24/hoji/base58"
 )
@@ -34,26 +35,93 @@ func (o *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 	return bytes.Compare(o.PubKeyHash, pubKeyHash) == 0
 }
 
-//TxOutputs is
+//TxOutputs holds the unspent outputs of a transaction keyed by their index in the transaction's Outputs, so spending one doesn't shift the others
 type TxOutputs struct {
-	Outputs []*TxOutput
+	Outputs map[int]*TxOutput
+}
+
+//NewTxOutputs creates an empty TxOutputs
+func NewTxOutputs() *TxOutputs {
+	return &TxOutputs{
+		Outputs: make(map[int]*TxOutput),
+	}
+}
+
+//Indexes returns the indexes of the outputs in ascending order
+func (o *TxOutputs) Indexes() []int {
+	indexes := make([]int, 0, len(o.Outputs))
+	for i := range o.Outputs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+//Bytes transforms an output into its wire format
+func (o *TxOutput) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeTxOutput(&buff, o); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToTxOutput deserializes a TxOutput
+func BytesToTxOutput(data []byte) (*TxOutput, error) {
+	var output *TxOutput
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		output, err = readTxOutput(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return output, nil
 }
 
 //Bytes transforms outputs into a byte array
 func (o *TxOutputs) Bytes() ([]byte, error) {
 	var buff bytes.Buffer
 
-	if err := gob.NewEncoder(&buff).Encode(o); err != nil {
+	if err := writeVarInt(&buff, uint64(len(o.Outputs))); err != nil {
 		return nil, err
 	}
+	for _, i := range o.Indexes() {
+		if err := binary.Write(&buff, binary.LittleEndian, uint32(i)); err != nil {
+			return nil, err
+		}
+		if err := writeTxOutput(&buff, o.Outputs[i]); err != nil {
+			return nil, err
+		}
+	}
 
 	return buff.Bytes(), nil
 }
 
 // BytesToOutputs deserializes TxOutputs
 func BytesToOutputs(data []byte) (*TxOutputs, error) {
-	var outputs *TxOutputs
-	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&outputs); err != nil {
+	outputs := NewTxOutputs()
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		count, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			var index uint32
+			if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+				return err
+			}
+			out, err := readTxOutput(r)
+			if err != nil {
+				return err
+			}
+			outputs.Outputs[int(index)] = out
+		}
+		return nil
+	}); err != nil {
 		return nil, err
 	}
 
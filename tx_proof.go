@@ -0,0 +1,77 @@
+package hoji
+
+import (
+	"bytes"
+	"io"
+)
+
+// TxProof proves that a transaction is included in a block. It carries the block header, the transaction and its merkle proof so it can be checked offline without the rest of the block.
+type TxProof struct {
+	Header *BlockHeader
+	Tx     *Transaction
+	Proof  *MerkleProof
+}
+
+// Verify checks the header's proof of work and that the transaction is a leaf of the header's merkle root
+func (p *TxProof) Verify() (bool, error) {
+	if !ValidateHeader(p.Header) {
+		return false, nil
+	}
+
+	leaf, err := p.Tx.Bytes()
+	if err != nil {
+		return false, err
+	}
+
+	return VerifyMerkleProof(leaf, p.Proof, p.Header.MerkleRoot), nil
+}
+
+// Bytes transforms a TxProof into its wire format
+func (p *TxProof) Bytes() ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := writeTxProof(&buff, p); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// BytesToTxProof decodes a TxProof
+func BytesToTxProof(data []byte) (*TxProof, error) {
+	var p *TxProof
+	if err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		p, err = readTxProof(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func writeTxProof(w io.Writer, p *TxProof) error {
+	if err := writeBlockHeader(w, p.Header); err != nil {
+		return err
+	}
+	if err := writeTransaction(w, p.Tx); err != nil {
+		return err
+	}
+	return writeMerkleProof(w, p.Proof)
+}
+
+func readTxProof(r *bytes.Reader) (*TxProof, error) {
+	p := new(TxProof)
+	var err error
+	if p.Header, err = readBlockHeader(r); err != nil {
+		return nil, err
+	}
+	if p.Tx, err = readTransaction(r); err != nil {
+		return nil, err
+	}
+	if p.Proof, err = readMerkleProof(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,91 @@
+package hoji
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTxProof(t *testing.T) {
+	c := newTestChain(t, nil)
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	spend := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(1, payee), NewTxOutput(reward.Value-1, c.miner))
+	block := c.mine(t, spend)
+
+	proof, err := c.TxProof(spend.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proof.Header.Hash, block.Hash) || !bytes.Equal(proof.Tx.ID, spend.ID) {
+		t.Fatalf("proof is for transaction %x in block %x", proof.Tx.ID, proof.Header.Hash)
+	}
+	if ok, err := proof.Verify(); err != nil || !ok {
+		t.Fatalf("proof doesn't verify: %v", err)
+	}
+
+	// the proof survives its wire format
+	b, err := proof.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := BytesToTxProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := decoded.Verify(); err != nil || !ok {
+		t.Fatalf("decoded proof doesn't verify: %v", err)
+	}
+	if _, err := BytesToTxProof(b[:len(b)-1]); err == nil {
+		t.Error("truncated proof was decoded")
+	}
+
+	// a proof for a changed transaction or in a header without proof of work fails
+	decoded.Tx.Outputs[0].Value++
+	if ok, err := decoded.Verify(); err != nil || ok {
+		t.Errorf("proof of a changed transaction verifies %v (%v)", ok, err)
+	}
+	forged := *proof
+	header := *proof.Header
+	header.Nonce++
+	forged.Header = &header
+	if ok, err := forged.Verify(); err != nil || ok {
+		t.Errorf("proof in a forged header verifies %v (%v)", ok, err)
+	}
+
+	if _, err := c.TxProof([]byte("unknown transaction")); err == nil {
+		t.Error("built a proof for an unknown transaction")
+	}
+}
+
+func TestTxProofs(t *testing.T) {
+	c := newTestChain(t, nil)
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	spend := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(reward.Value, payee))
+	c.mine(t, spend)
+
+	payeeHash := ExtractPubKeyHash(payee)
+	proofs, err := c.TxProofs(payeeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proofs) != 1 || !bytes.Equal(proofs[0].Tx.ID, spend.ID) {
+		t.Fatalf("got %d proofs for the payee, want the spend", len(proofs))
+	}
+
+	// the miner received the rewards of every block and signed the spend
+	proofs, err = c.TxProofs(ExtractPubKeyHash(c.miner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, proof := range proofs {
+		if ok, err := proof.Verify(); err != nil || !ok {
+			t.Errorf("proof of %x doesn't verify: %v", proof.Tx.ID, err)
+		}
+		found = found || bytes.Equal(proof.Tx.ID, spend.ID)
+	}
+	if !found || len(proofs) < 2 {
+		t.Errorf("got %d proofs for the miner, want its rewards and the spend", len(proofs))
+	}
+}
@@ -0,0 +1,185 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// undoBucket maps block hashes to the outputs the block's transactions spent. Once a block is connected those outputs are gone from the UTXO set, its undo record is what is left to verify the block's transactions and to disconnect it. Pruned nodes delete it along with the block's transactions.
+const undoBucket = "undo"
+
+// putBlockUndo stores the undo record of a block, it has to be written before the block is applied to the UTXO set
+func putBlockUndo(tx StoreTx, block *Block) error {
+	var spent []*TxOutput
+	created := make(map[string]*Transaction)
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, input := range t.Inputs {
+				out, err := findSpentOutput(tx, input.PrevOut, created)
+				if err != nil {
+					return err
+				}
+				spent = append(spent, out)
+			}
+		}
+		created[hex.EncodeToString(t.ID)] = t
+	}
+
+	var buff bytes.Buffer
+	if err := writeVarInt(&buff, uint64(len(spent))); err != nil {
+		return err
+	}
+	for _, out := range spent {
+		if err := writeTxOutput(&buff, out); err != nil {
+			return err
+		}
+	}
+	return tx.Put([]byte(undoBucket), block.Hash, buff.Bytes())
+}
+
+// findSpentOutput looks up the output an input of a block spends, among the transactions created earlier in the block first and then in the UTXO set
+func findSpentOutput(tx StoreTx, prevOut OutPoint, created map[string]*Transaction) (*TxOutput, error) {
+	if t := created[hex.EncodeToString(prevOut.TxID)]; t != nil {
+		if prevOut.Index < 0 || prevOut.Index >= len(t.Outputs) {
+			return nil, ErrNotFound
+		}
+		return t.Outputs[prevOut.Index], nil
+	}
+
+	outs, err := tx.UTXO(prevOut.TxID)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := outs.Outputs[prevOut.Index]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+// blockUndo returns the outputs spent by the block's inputs, in the order of the inputs. It fails with ErrNotFound when the block has no undo record: it was pruned, restored from a block file or connected by a version of hoji that didn't write them.
+func blockUndo(tx StoreTx, block *Block) ([]*TxOutput, error) {
+	inputs := 0
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			inputs += len(t.Inputs)
+		}
+	}
+	if inputs == 0 {
+		return nil, nil
+	}
+
+	v := tx.Get([]byte(undoBucket), block.Hash)
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	var spent []*TxOutput
+	if err := decodeAll(v, func(r *bytes.Reader) error {
+		count, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			out, err := readTxOutput(r)
+			if err != nil {
+				return err
+			}
+			spent = append(spent, out)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(spent) != inputs {
+		return nil, ErrMalformedEncoding
+	}
+
+	return spent, nil
+}
+
+// blockPrevTxs returns the outputs the block's transactions spend, keyed by transaction ID like the prevTxs of Verify. They come from the block's undo record, and from the chain for blocks without one.
+func (bc *Blockchain) blockPrevTxs(block *Block) (map[string]*Transaction, error) {
+	var spent []*TxOutput
+	err := bc.view(func(tx StoreTx) error {
+		var err error
+		spent, err = blockUndo(tx, block)
+		return err
+	})
+	if err == ErrNotFound {
+		prevTxs := make(map[string]*Transaction)
+		for _, t := range block.Transactions {
+			if t.IsCoinbase() {
+				continue
+			}
+			txPrevTxs, err := bc.prevTxs(t)
+			if err != nil {
+				return nil, err
+			}
+			for id, prevTx := range txPrevTxs {
+				prevTxs[id] = prevTx
+			}
+		}
+		return prevTxs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prevTxs := make(map[string]*Transaction)
+	for _, t := range block.Transactions {
+		if t.IsCoinbase() {
+			continue
+		}
+		for _, input := range t.Inputs {
+			id := hex.EncodeToString(input.PrevOut.TxID)
+			prevTx := prevTxs[id]
+			if prevTx == nil {
+				prevTx = &Transaction{ID: input.PrevOut.TxID}
+				prevTxs[id] = prevTx
+			}
+			if input.PrevOut.Index < 0 {
+				return nil, ErrMalformedEncoding
+			}
+			for len(prevTx.Outputs) <= input.PrevOut.Index {
+				prevTx.Outputs = append(prevTx.Outputs, nil)
+			}
+			prevTx.Outputs[input.PrevOut.Index], spent = spent[0], spent[1:]
+		}
+	}
+	return prevTxs, nil
+}
+
+// disconnectBlock takes the tip block off the UTXO set: the outputs it created are removed and the ones it spent put back from its undo record. Transactions are undone last first, so an output created and spent within the block ends up removed.
+func disconnectBlock(tx StoreTx, block *Block) error {
+	spent, err := blockUndo(tx, block)
+	if err != nil {
+		return err
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		t := block.Transactions[i]
+		if err := tx.DeleteUTXO(t.ID); err != nil {
+			return err
+		}
+		if t.IsCoinbase() {
+			continue
+		}
+
+		first := len(spent) - len(t.Inputs)
+		for j, input := range t.Inputs {
+			outs, err := tx.UTXO(input.PrevOut.TxID)
+			if err == ErrNotFound {
+				outs = NewTxOutputs()
+			} else if err != nil {
+				return err
+			}
+			outs.Outputs[input.PrevOut.Index] = spent[first+j]
+			if err := tx.PutUTXO(input.PrevOut.TxID, outs); err != nil {
+				return err
+			}
+		}
+		spent = spent[:first]
+	}
+
+	return nil
+}
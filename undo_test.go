@@ -0,0 +1,152 @@
+package hoji
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPrunedNodeVerifiesWithUndo(t *testing.T) {
+	c := newTestChain(t, nil, WithPrune(MinPruneDepth))
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	for i := 0; i < 10; i++ {
+		c.mine(t)
+	}
+	// the block creating the spent output is pruned, the block spending it is kept
+	spending := c.mine(t, c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(reward.Value, payee)))
+	for i := 0; i < MinPruneDepth-5; i++ {
+		c.mine(t)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if height, err := c.PruneHeight(); err != nil || height <= 1 || height >= spending.Height {
+		t.Fatalf("prune height %d (%v), want between 1 and %d", height, err, spending.Height)
+	}
+
+	if _, err := c.VerifyChain(VerifySignatures, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the spending block is taken off the UTXO set with its undo record
+	if err := c.InvalidateBlock(spending.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Tip(), spending.PrevBlockHash) {
+		t.Fatal("tip not rewound")
+	}
+	if outs := c.spendable(t, payee); len(outs) != 0 {
+		t.Fatalf("payee still has %d outputs", len(outs))
+	}
+	found := false
+	for _, out := range c.spendable(t, c.miner) {
+		found = found || out.OutPoint.Equal(reward.OutPoint)
+	}
+	if !found {
+		t.Fatal("spent output not restored")
+	}
+
+	// blocks older than the prune depth can't be disconnected
+	var old []byte
+	if err := c.view(func(tx StoreTx) error {
+		header, err := tx.Header(tx.Tip())
+		for err == nil && header.Height > 2 {
+			header, err = tx.Header(header.PrevBlockHash)
+		}
+		old = header.Hash
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InvalidateBlock(old); !errors.Is(err, ErrBlockPruned) {
+		t.Fatalf("got %v, want ErrBlockPruned", err)
+	}
+}
+
+func TestInvalidateBlockWithUndo(t *testing.T) {
+	c := newTestChain(t, nil, WithAddressIndex(true))
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+
+	first := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(4, payee), NewTxOutput(reward.Value-4, c.miner))
+	invalid := c.mine(t, first)
+	second := c.spend(t, payee, []OutPoint{{TxID: first.ID, Index: 0}}, NewTxOutput(4, c.miner))
+	c.mine(t, second)
+
+	if err := c.InvalidateBlock(invalid.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyChain(VerifyUTXO, 0); err != nil {
+		t.Fatal(err)
+	}
+	if outs := c.spendable(t, payee); len(outs) != 0 {
+		t.Fatalf("payee still has %d outputs", len(outs))
+	}
+}
+
+func TestPrunedNodeRecoversInvalidation(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		invalidate func(c *testChain, hash, prevHash []byte) error
+	}{
+		{"invalidated", func(c *testChain, hash, prevHash []byte) error {
+			return c.InvalidateBlock(hash)
+		}},
+		// the tip moved back while the stored UTXO set stayed at the old one, as invalidations left it before they were written through
+		{"stored UTXO set ahead", func(c *testChain, hash, prevHash []byte) error {
+			return c.store.Update(func(tx StoreTx) error {
+				return tx.SetTip(prevHash)
+			})
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChain(t, nil, WithPrune(MinPruneDepth), WithUTXOCache(1<<30, 0))
+			payee := c.newAddress(t)
+			reward := c.spendable(t, c.miner)[0]
+			for i := 0; i < MinPruneDepth+5; i++ {
+				c.mine(t)
+			}
+			before := c.spendable(t, c.miner)
+			spending := c.mine(t, c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(reward.Value, payee)))
+			c.mine(t)
+
+			// the stored UTXO set is at the tip, ahead of the block invalidated next
+			if err := c.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if height, err := c.PruneHeight(); err != nil || height <= 1 {
+				t.Fatalf("prune height %d (%v), want the first blocks pruned", height, err)
+			}
+			if err := test.invalidate(c, spending.Hash, spending.PrevBlockHash); err != nil {
+				t.Fatal(err)
+			}
+
+			// the node is killed before the cache is written and has to be opened without rebuilding the UTXO set from pruned blocks
+			c.crash(t)
+			c.open(t)
+			if !bytes.Equal(c.Tip(), spending.PrevBlockHash) {
+				t.Fatal("tip isn't the invalidated block's parent")
+			}
+			if outs := c.spendable(t, payee); len(outs) != 0 {
+				t.Fatalf("payee still has %d outputs", len(outs))
+			}
+			after := c.spendable(t, c.miner)
+			if len(after) != len(before) {
+				t.Fatalf("miner has %d outputs, want the %d it had before the invalidated blocks", len(after), len(before))
+			}
+			values := make(map[string]int)
+			for _, out := range before {
+				values[out.OutPoint.String()] = out.Value
+			}
+			for _, out := range after {
+				if value, ok := values[out.OutPoint.String()]; !ok || value != out.Value {
+					t.Fatalf("miner has output %v of value %d it didn't have before the invalidated blocks", out.OutPoint, out.Value)
+				}
+			}
+			if _, err := c.VerifyChain(VerifySignatures, 0); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,26 +1,30 @@
 package hoji
 
 import (
-	"bytes"
 	"encoding/binary"
-	"log"
 
 	"gitlab.com/rodzzlessa24/hoji/base58"
 )
 
 // IntToByte converts an int64 to a byte array
 func IntToByte(num int64) []byte {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panic(err)
-	}
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, uint64(num))
 
-	return buff.Bytes()
+	return buff
 }
 
-//ExtractPubKeyHash is
+//ExtractPubKeyHash returns the pubkey hash of an address without checking its checksum, nil when the address is too short to hold a version byte and a checksum
 func ExtractPubKeyHash(address []byte) []byte {
+	pubKeyHash, _ := addressPubKeyHash(address)
+	return pubKeyHash
+}
+
+// addressPubKeyHash is ExtractPubKeyHash for addresses coming from callers, it fails with ErrBadRequest for an address too short to hold a version byte and a checksum
+func addressPubKeyHash(address []byte) ([]byte, error) {
 	decodeAddr := base58.Decode(address)
-	return decodeAddr[1 : len(decodeAddr)-4]
+	if len(decodeAddr) < 1+addressChecksumLen {
+		return nil, ErrBadRequest
+	}
+	return decodeAddr[1 : len(decodeAddr)-addressChecksumLen], nil
 }
@@ -0,0 +1,567 @@
+package hoji
+
+import (
+	"bytes"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// utxoTipKey stores, inside the meta bucket, the hash of the block the stored UTXO set is up to date with. The UTXO cache lets it lag behind the tip, the blocks after it are applied again when the blockchain is opened.
+const utxoTipKey = "u"
+
+const (
+	// DefaultUTXOCacheSize is the default memory budget of the UTXO cache in bytes
+	DefaultUTXOCacheSize = 32 << 20
+	// DefaultUTXOFlushInterval is how often the UTXO cache is written to the store by default
+	DefaultUTXOFlushInterval = time.Minute
+)
+
+// utxoCache is a write-back cache in front of the chainstate bucket. The UTXO changes of committed updates are kept in memory and written to the store in batches, together with the hash of the block they bring the UTXO set to, so the stored UTXO set always matches a block of the chain.
+type utxoCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// byAddress indexes the cached transactions by the pubkey hashes of their outputs
+	byAddress map[string]map[string]bool
+	size      int
+	maxSize   int
+	// best is the block the cached UTXO set is up to date with
+	best []byte
+}
+
+type cacheEntry struct {
+	// outs is nil for a transaction whose outputs are all spent
+	outs  *TxOutputs
+	dirty bool
+}
+
+func newUTXOCache(maxSize int) *utxoCache {
+	return &utxoCache{
+		entries:   make(map[string]*cacheEntry),
+		byAddress: make(map[string]map[string]bool),
+		maxSize:   maxSize,
+	}
+}
+
+// wrap returns a transaction reading and writing the UTXO set through the cache
+func (c *utxoCache) wrap(tx StoreTx) *cachedTx {
+	return &cachedTx{
+		StoreTx: tx,
+		cache:   c,
+		pending: make(map[string]*TxOutputs),
+	}
+}
+
+func (c *utxoCache) get(txID string) (*TxOutputs, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[txID]
+	if !ok {
+		return nil, false
+	}
+	return e.outs, true
+}
+
+// addressTxIDs returns the cached transactions with unspent outputs locked with pubKeyHash
+func (c *utxoCache) addressTxIDs(pubKeyHash []byte) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	txIDs := make([]string, 0, len(c.byAddress[string(pubKeyHash)]))
+	for txID := range c.byAddress[string(pubKeyHash)] {
+		txIDs = append(txIDs, txID)
+	}
+	return txIDs
+}
+
+// index adds the outputs of a cached transaction to byAddress, or removes them when add is false
+func (c *utxoCache) index(txID string, outs *TxOutputs, add bool) {
+	if outs == nil {
+		return
+	}
+	for _, out := range outs.Outputs {
+		key := string(out.PubKeyHash)
+		if add {
+			if c.byAddress[key] == nil {
+				c.byAddress[key] = make(map[string]bool)
+			}
+			c.byAddress[key][txID] = true
+			continue
+		}
+		delete(c.byAddress[key], txID)
+		if len(c.byAddress[key]) == 0 {
+			delete(c.byAddress, key)
+		}
+	}
+}
+
+// overlay returns the cached entries, nil values are spent transactions
+func (c *utxoCache) overlay() map[string]*TxOutputs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	overlay := make(map[string]*TxOutputs, len(c.entries))
+	for txID, e := range c.entries {
+		overlay[txID] = e.outs
+	}
+	return overlay
+}
+
+// commit adds the writes of a committed transaction to the cache. A write-through transaction rewrote the whole stored UTXO set so the cache starts over.
+func (c *utxoCache) commit(tx *cachedTx, tip []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tx.writeThrough {
+		c.entries = make(map[string]*cacheEntry)
+		c.byAddress = make(map[string]map[string]bool)
+		c.size = 0
+		c.best = tip
+		return
+	}
+	if len(tx.pending) == 0 {
+		return
+	}
+
+	for txID, outs := range tx.pending {
+		if e, ok := c.entries[txID]; ok {
+			c.size -= entrySize(txID, e.outs)
+			c.index(txID, e.outs, false)
+		}
+		c.entries[txID] = &cacheEntry{outs: outs, dirty: true}
+		c.size += entrySize(txID, outs)
+		c.index(txID, outs, true)
+	}
+	c.best = tip
+}
+
+func (c *utxoCache) full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size > c.maxSize
+}
+
+// write writes the dirty entries and the block they bring the UTXO set to in tx, it returns the number of entries written
+func (c *utxoCache) write(tx StoreTx) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.best) == 0 {
+		return 0, nil
+	}
+	written := 0
+	for txID, e := range c.entries {
+		if !e.dirty {
+			continue
+		}
+		written++
+		if e.outs == nil {
+			if err := tx.DeleteUTXO([]byte(txID)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := tx.PutUTXO([]byte(txID), e.outs); err != nil {
+			return 0, err
+		}
+	}
+	return written, tx.Put([]byte(metaBucket), []byte(utxoTipKey), c.best)
+}
+
+// written marks the entries as flushed, and drops them all if they are over budget
+func (c *utxoCache) written() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size > c.maxSize {
+		c.entries = make(map[string]*cacheEntry)
+		c.byAddress = make(map[string]map[string]bool)
+		c.size = 0
+		return
+	}
+	for txID, e := range c.entries {
+		if e.outs == nil {
+			c.size -= entrySize(txID, nil)
+			delete(c.entries, txID)
+			continue
+		}
+		e.dirty = false
+	}
+}
+
+// entrySize estimates the memory used by a cache entry
+func entrySize(txID string, outs *TxOutputs) int {
+	size := len(txID) + 64
+	if outs != nil {
+		for _, out := range outs.Outputs {
+			size += 48 + len(out.PubKeyHash)
+		}
+	}
+	return size
+}
+
+// cachedTx is a store transaction whose UTXO accessors go through the cache. Writes are kept in pending until the store transaction commits. ResetUTXO makes the transaction write-through: the UTXO set is rewritten in the store directly and the cache starts over.
+type cachedTx struct {
+	StoreTx
+	cache        *utxoCache
+	pending      map[string]*TxOutputs
+	writeThrough bool
+}
+
+func (tx *cachedTx) UTXO(txID []byte) (*TxOutputs, error) {
+	if tx.writeThrough {
+		return tx.StoreTx.UTXO(txID)
+	}
+
+	outs, ok := tx.pending[string(txID)]
+	if !ok {
+		outs, ok = tx.cache.get(string(txID))
+	}
+	if !ok {
+		return tx.StoreTx.UTXO(txID)
+	}
+	if outs == nil {
+		return nil, ErrNotFound
+	}
+	// callers modify the outputs they get, the cached ones have to stay as they are
+	return outs.copy(), nil
+}
+
+func (tx *cachedTx) PutUTXO(txID []byte, outs *TxOutputs) error {
+	if tx.writeThrough {
+		return tx.StoreTx.PutUTXO(txID, outs)
+	}
+	tx.pending[string(txID)] = outs.copy()
+	return nil
+}
+
+func (tx *cachedTx) DeleteUTXO(txID []byte) error {
+	if tx.writeThrough {
+		return tx.StoreTx.DeleteUTXO(txID)
+	}
+	tx.pending[string(txID)] = nil
+	return nil
+}
+
+// bypassCache makes the transaction write-through after writing the cached changes and its own pending ones to the store, so its UTXO changes are committed along with its other writes and the cache starts over
+func (tx *cachedTx) bypassCache() error {
+	if tx.writeThrough {
+		return nil
+	}
+	if _, err := tx.cache.write(tx.StoreTx); err != nil {
+		return err
+	}
+	for txID, outs := range tx.pending {
+		var err error
+		if outs == nil {
+			err = tx.StoreTx.DeleteUTXO([]byte(txID))
+		} else {
+			err = tx.StoreTx.PutUTXO([]byte(txID), outs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tx.writeThrough = true
+	tx.pending = nil
+	return nil
+}
+
+func (tx *cachedTx) ResetUTXO() error {
+	tx.writeThrough = true
+	tx.pending = nil
+	return tx.StoreTx.ResetUTXO()
+}
+
+// ForEachUTXO merges the cached entries into the stored ones, keeping the txID order
+func (tx *cachedTx) ForEachUTXO(fn func(txID []byte, outs *TxOutputs) error) error {
+	if tx.writeThrough {
+		return tx.StoreTx.ForEachUTXO(fn)
+	}
+
+	overlay := tx.cache.overlay()
+	for txID, outs := range tx.pending {
+		overlay[txID] = outs
+	}
+	txIDs := make([]string, 0, len(overlay))
+	for txID := range overlay {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+
+	next := 0
+	emit := func(until []byte) error {
+		for ; next < len(txIDs) && (until == nil || txIDs[next] < string(until)); next++ {
+			if outs := overlay[txIDs[next]]; outs != nil {
+				if err := fn([]byte(txIDs[next]), outs); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := tx.StoreTx.ForEachUTXO(func(txID []byte, outs *TxOutputs) error {
+		if err := emit(txID); err != nil {
+			return err
+		}
+		if _, ok := overlay[string(txID)]; ok {
+			return nil
+		}
+		return fn(txID, outs)
+	}); err != nil {
+		return err
+	}
+	return emit(nil)
+}
+
+// ForEachAddressUTXO reads the stored outputs through the address index, skipping the transactions the cache holds, then the cached transactions with outputs locked with pubKeyHash through the cache's own index. Only the transactions of the current store transaction are scanned.
+func (tx *cachedTx) ForEachAddressUTXO(pubKeyHash []byte, fn func(txID []byte, index int, out *TxOutput) error) error {
+	if tx.writeThrough {
+		return tx.StoreTx.ForEachAddressUTXO(pubKeyHash, fn)
+	}
+
+	cached := func(txID string) (*TxOutputs, bool) {
+		if outs, ok := tx.pending[txID]; ok {
+			return outs, true
+		}
+		return tx.cache.get(txID)
+	}
+	if err := tx.StoreTx.ForEachAddressUTXO(pubKeyHash, func(txID []byte, index int, out *TxOutput) error {
+		if _, ok := cached(string(txID)); ok {
+			return nil
+		}
+		return fn(txID, index, out)
+	}); err != nil {
+		return err
+	}
+
+	txIDs := tx.cache.addressTxIDs(pubKeyHash)
+	for txID, outs := range tx.pending {
+		if outs != nil {
+			txIDs = append(txIDs, txID)
+		}
+	}
+	sort.Strings(txIDs)
+	for n, txID := range txIDs {
+		if n > 0 && txID == txIDs[n-1] {
+			continue
+		}
+		outs, _ := cached(txID)
+		if outs == nil {
+			continue
+		}
+		for _, i := range outs.Indexes() {
+			if !outs.Outputs[i].IsLockedWithKey(pubKeyHash) {
+				continue
+			}
+			if err := fn([]byte(txID), i, outs.Outputs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copy returns a TxOutputs holding the same outputs
+func (o *TxOutputs) copy() *TxOutputs {
+	c := NewTxOutputs()
+	for i, out := range o.Outputs {
+		c.Outputs[i] = out
+	}
+	return c
+}
+
+// view runs fn in a store view reading the UTXO set through the cache. Views hold bc.mu for reading so the cache can't change under them.
+func (bc *Blockchain) view(fn func(tx StoreTx) error) error {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.store.View(func(tx StoreTx) error {
+		return fn(bc.cache.wrap(tx))
+	})
+}
+
+// update runs fn in a store update, its UTXO changes are committed to the cache and flushed when the cache is over budget. Updates hold bc.mu for writing so views see the store and the cache change together.
+func (bc *Blockchain) update(fn func(tx StoreTx) error) error {
+	return bc.updateUTXO(false, fn)
+}
+
+// updateWriteThrough is update for changes that move the tip back. The cached changes are written first and fn changes the stored UTXO set directly, so it moves back along with the tip: a UTXO set left ahead of the tip by a crash can't be brought back to it by applying blocks.
+func (bc *Blockchain) updateWriteThrough(fn func(tx StoreTx) error) error {
+	return bc.updateUTXO(true, fn)
+}
+
+func (bc *Blockchain) updateUTXO(writeThrough bool, fn func(tx StoreTx) error) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	var ctx *cachedTx
+	var tip []byte
+	if err := bc.store.Update(func(tx StoreTx) error {
+		ctx = bc.cache.wrap(tx)
+		if writeThrough {
+			if err := ctx.bypassCache(); err != nil {
+				return err
+			}
+		}
+		if err := fn(ctx); err != nil {
+			return err
+		}
+
+		tip = tx.Tip()
+		if ctx.writeThrough {
+			return tx.Put([]byte(metaBucket), []byte(utxoTipKey), tip)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	bc.cache.commit(ctx, tip)
+	bc.tip = tip
+
+	if bc.cache.full() {
+		return bc.flush()
+	}
+	return nil
+}
+
+// Flush writes the UTXO changes kept in the cache to the store
+func (bc *Blockchain) Flush() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.flush()
+}
+
+// flush writes the cache and, on a pruned node, deletes the blocks the stored UTXO set no longer needs
+func (bc *Blockchain) flush() error {
+	var written int
+	var pruned, prunedBefore int64
+	if err := bc.store.Update(func(tx StoreTx) error {
+		var err error
+		if written, err = bc.cache.write(tx); err != nil {
+			return err
+		}
+		if bc.prune > 0 {
+			prunedBefore = pruneHeight(tx)
+			if err := pruneBlocks(tx, bc.prune); err != nil {
+				return err
+			}
+			pruned = pruneHeight(tx)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	bc.cache.written()
+
+	if written > 0 {
+		bc.log.utxo.Debug("UTXO cache written", "entries", written)
+	}
+	if pruned > prunedBefore {
+		bc.log.chain.Info("blocks pruned", "height", pruned)
+	}
+	return nil
+}
+
+// flushLoop flushes the cache every interval until stop is closed. A failed flush is retried on the next tick, Close reports the error of the last one.
+func (bc *Blockchain) flushLoop(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := bc.Flush(); err != nil {
+				bc.log.utxo.Warn("UTXO cache flush failed", "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// recoverUTXO brings the stored UTXO set up to the tip by applying the blocks whose changes were still in the cache when the node stopped. A UTXO set left on blocks the chain moved away from is first taken back to the chain with their undo records. rebuild reports such a UTXO set whose blocks or undo records are gone, it has to be rebuilt from scratch. Databases written before the cache existed are always up to date.
+func recoverUTXO(store Store, log *slog.Logger) (rebuild bool, err error) {
+	var rewound, replayed int
+	err = store.Update(func(tx StoreTx) error {
+		tip := tx.Tip()
+		utxoTip := tx.Get([]byte(metaBucket), []byte(utxoTipKey))
+		if utxoTip == nil {
+			return tx.Put([]byte(metaBucket), []byte(utxoTipKey), tip)
+		}
+		utxoTip = append([]byte{}, utxoTip...)
+
+		// every undo record is checked before the first block is disconnected so a rebuild starts from an untouched UTXO set
+		var undone []*Block
+		for {
+			header, err := tx.Header(utxoTip)
+			if err != nil {
+				return err
+			}
+			hash, err := tx.MainChainHash(header.Height)
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+			if bytes.Equal(hash, utxoTip) {
+				break
+			}
+			block, err := tx.Block(utxoTip)
+			if err == nil {
+				_, err = blockUndo(tx, block)
+			}
+			if err == ErrBlockPruned || err == ErrNotFound {
+				rebuild = true
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			undone = append(undone, block)
+			utxoTip = block.PrevBlockHash
+		}
+		for _, block := range undone {
+			if err := disconnectBlock(tx, block); err != nil {
+				return err
+			}
+		}
+		rewound = len(undone)
+
+		var blocks []*Block
+		for hash := tip; !bytes.Equal(hash, utxoTip); {
+			block, err := tx.Block(hash)
+			if err != nil {
+				return err
+			}
+			if len(block.PrevBlockHash) == 0 {
+				rebuild = true
+				return nil
+			}
+			blocks = append(blocks, block)
+			hash = block.PrevBlockHash
+		}
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if err := updateUTXO(tx, blocks[i]); err != nil {
+				return err
+			}
+		}
+		replayed = len(blocks)
+		return tx.Put([]byte(metaBucket), []byte(utxoTipKey), tip)
+	})
+	if err == nil && rewound > 0 {
+		log.Info("UTXO set taken back to the chain after an unclean shutdown", "blocks", rewound)
+	}
+	if err == nil && replayed > 0 {
+		log.Info("UTXO set recovered after an unclean shutdown", "blocks", replayed)
+	}
+	if err == nil && rebuild {
+		log.Warn("UTXO set is ahead of the chain and can't be taken back, rebuilding it")
+	}
+	return rebuild, err
+}
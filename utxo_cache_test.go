@@ -0,0 +1,50 @@
+package hoji
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCacheAddressIndex(t *testing.T) {
+	c := newTestChain(t, nil)
+	payee := c.newAddress(t)
+	pubKeyHash := ExtractPubKeyHash(payee)
+	reward := c.spendable(t, c.miner)[0]
+
+	payment := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(3, payee), NewTxOutput(reward.Value-3, c.miner))
+	c.mine(t, payment)
+	if txIDs := c.cache.addressTxIDs(pubKeyHash); len(txIDs) != 1 || !bytes.Equal([]byte(txIDs[0]), payment.ID) {
+		t.Fatalf("cache indexes %x for the payee, want the payment", txIDs)
+	}
+	if outs := c.spendable(t, payee); len(outs) != 1 || outs[0].Value != 3 {
+		t.Fatalf("payee has %v before the cache is written", outs)
+	}
+
+	// the payment stays cached with its change, its output to the payee leaves the index
+	c.mine(t, c.spend(t, payee, []OutPoint{{TxID: payment.ID, Index: 0}}, NewTxOutput(3, c.miner)))
+	if txIDs := c.cache.addressTxIDs(pubKeyHash); len(txIDs) != 0 {
+		t.Fatalf("cache still indexes %x for the payee", txIDs)
+	}
+	if outs := c.spendable(t, payee); len(outs) != 0 {
+		t.Fatalf("payee has %v after spending", outs)
+	}
+
+	cached := c.spendable(t, c.miner)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	c.reopen(t)
+	stored := c.spendable(t, c.miner)
+	if len(cached) != len(stored) {
+		t.Fatalf("%d outputs through the cache, %d from the store", len(cached), len(stored))
+	}
+	values := make(map[string]int)
+	for _, out := range cached {
+		values[out.OutPoint.String()] = out.Value
+	}
+	for _, out := range stored {
+		if value, ok := values[out.OutPoint.String()]; !ok || value != out.Value {
+			t.Fatalf("stored output %v of value %d isn't found through the cache", out.OutPoint, out.Value)
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package hoji
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
-
-	"github.com/boltdb/bolt"
 )
 
+// utxoBucket holds the unspent outputs, one key per output, see outPointKey
 const utxoBucket = "chainstate"
 
 //UTXOSet is
@@ -14,54 +14,50 @@ type UTXOSet struct {
 	Bc *Blockchain
 }
 
-//SpendableOutput is
+//SpendableOutput is an unspent output that can be used as a transaction input
 type SpendableOutput struct {
-	TxID  []byte
-	Value int
-	index int
+	OutPoint OutPoint
+	Value    int
 }
 
-//CreateUTXOSet is
+//CreateUTXOSet rebuilds the UTXO set from the blocks of the chain. It fails with ErrStaleTip when a block is added while the blocks are read.
 func CreateUTXOSet(bc *Blockchain) error {
-	if err := bc.DB.Update(func(tx *bolt.Tx) error {
-		if err := tx.DeleteBucket([]byte(utxoBucket)); err != nil && err != bolt.ErrBucketNotFound {
-			return fmt.Errorf("error deleting bucket %v", err)
-		}
-		if _, err := tx.CreateBucket([]byte(utxoBucket)); err != nil {
-			return fmt.Errorf("error creating bucket %v", err)
-		}
-		return nil
-	}); err != nil {
+	tip := bc.Tip()
+	utxo, err := bc.listUTXO(tip)
+	if err != nil {
 		return err
 	}
 
-	utxo, err := bc.ListUTXO()
-	if err != nil {
+	if err := bc.update(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Tip(), tip) {
+			return ErrStaleTip
+		}
+		return putUTXOSet(tx, utxo)
+	}); err != nil {
 		return err
 	}
+	bc.log.utxo.Info("UTXO set rebuilt", hashAttr("tip", tip), "txs", len(utxo))
+	return nil
+}
 
-	return bc.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-
-		for txID, outputs := range utxo {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				return err
-			}
+// putUTXOSet replaces the UTXO set with utxo
+func putUTXOSet(tx StoreTx, utxo map[string]*TxOutputs) error {
+	if err := tx.ResetUTXO(); err != nil {
+		return fmt.Errorf("error deleting bucket %v", err)
+	}
 
-			serialized, err := outputs.Bytes()
-			if err != nil {
-				return err
-			}
+	for txID, outputs := range utxo {
+		key, err := hex.DecodeString(txID)
+		if err != nil {
+			return err
+		}
 
-			err = b.Put(key, serialized)
-			if err != nil {
-				return err
-			}
+		if err := tx.PutUTXO(key, outputs); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
 
 //Reindex is
@@ -69,35 +65,24 @@ func (u *UTXOSet) Reindex() error {
 	return CreateUTXOSet(u.Bc)
 }
 
-//FindSpendableOutputs is
+//FindSpendableOutputs returns the unspent outputs locked with the address, read through the address index
 func (u *UTXOSet) FindSpendableOutputs(address []byte) ([]*SpendableOutput, error) {
 	var spendableOutput []*SpendableOutput
 
-	pubKeyHash := ExtractPubKeyHash(address)
-
-	if err := u.Bc.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs, err := BytesToOutputs(v)
-			if err != nil {
-				return err
-			}
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
 
-			for i, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					so := &SpendableOutput{
-						TxID:  k,
-						Value: out.Value,
-						index: i,
-					}
-					spendableOutput = append(spendableOutput, so)
-				}
+	if err := u.Bc.view(func(tx StoreTx) error {
+		return tx.ForEachAddressUTXO(pubKeyHash, func(txID []byte, index int, out *TxOutput) error {
+			so := &SpendableOutput{
+				OutPoint: NewOutPoint(txID, index),
+				Value:    out.Value,
 			}
-		}
-
-		return nil
+			spendableOutput = append(spendableOutput, so)
+			return nil
+		})
 	}); err != nil {
 		return nil, err
 	}
@@ -109,27 +94,16 @@ func (u *UTXOSet) FindSpendableOutputs(address []byte) ([]*SpendableOutput, erro
 func (u UTXOSet) FindUTXO(address []byte) ([]*TxOutput, error) {
 	var UTXOs []*TxOutput
 
-	pubKeyHash := ExtractPubKeyHash(address)
-
-	if err := u.Bc.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs, err := BytesToOutputs(v)
-			if err != nil {
-				return err
-			}
-
-			for _, out := range outs.Outputs {
-				fmt.Println("hash", hex.EncodeToString(out.PubKeyHash))
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
-		}
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
+	if err := u.Bc.view(func(tx StoreTx) error {
+		return tx.ForEachAddressUTXO(pubKeyHash, func(txID []byte, index int, out *TxOutput) error {
+			UTXOs = append(UTXOs, out)
+			return nil
+		})
 	}); err != nil {
 		return nil, err
 	}
@@ -141,15 +115,11 @@ func (u UTXOSet) FindUTXO(address []byte) ([]*TxOutput, error) {
 func (u UTXOSet) CountTransactions() (int, error) {
 	counter := 0
 
-	if err := u.Bc.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+	if err := u.Bc.view(func(tx StoreTx) error {
+		return tx.ForEachUTXO(func(txID []byte, outs *TxOutputs) error {
 			counter++
-		}
-
-		return nil
+			return nil
+		})
 	}); err != nil {
 		return 0, err
 	}
@@ -157,57 +127,47 @@ func (u UTXOSet) CountTransactions() (int, error) {
 	return counter, nil
 }
 
-//Update is
+//Update applies a block to the UTXO set: the outputs it spends are removed and the ones it creates are added
 func (u *UTXOSet) Update(block *Block) error {
-	return u.Bc.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, input := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					outsBytes := b.Get(input.TxID)
-					outs, err := BytesToOutputs(outsBytes)
-					if err != nil {
-						return err
-					}
+	return u.Bc.update(func(tx StoreTx) error {
+		return updateUTXO(tx, block)
+	})
+}
 
-					for outIndx, out := range outs.Outputs {
-						if outIndx != input.outIndex {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-
-						if len(updatedOuts.Outputs) == 0 {
-							if err := b.Delete(input.TxID); err != nil {
-								return err
-							}
-						} else {
-							s, err := updatedOuts.Bytes()
-							if err != nil {
-								return err
-							}
-							if err := b.Put(input.TxID, s); err != nil {
-								return err
-							}
-						}
+func updateUTXO(tx StoreTx, block *Block) error {
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, input := range t.Inputs {
+				outs, err := tx.UTXO(input.PrevOut.TxID)
+				if err != nil {
+					return err
+				}
+				if _, ok := outs.Outputs[input.PrevOut.Index]; !ok {
+					return ErrNotFound
+				}
+				delete(outs.Outputs, input.PrevOut.Index)
+
+				if len(outs.Outputs) == 0 {
+					if err := tx.DeleteUTXO(input.PrevOut.TxID); err != nil {
+						return err
 					}
+					continue
+				}
+				if err := tx.PutUTXO(input.PrevOut.TxID, outs); err != nil {
+					return err
 				}
 			}
+		}
 
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
+		newOutputs := NewTxOutputs()
+		for i, out := range t.Outputs {
+			newOutputs.Outputs[i] = out
+		}
 
-			s, err := newOutputs.Bytes()
-			if err != nil {
-				return err
-			}
-			if err := b.Put(tx.ID, s); err != nil {
-				return err
-			}
+		if err := tx.PutUTXO(t.ID, newOutputs); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
@@ -0,0 +1,63 @@
+package hoji
+
+import (
+	"testing"
+)
+
+func TestSpendOutputIndexAfterReopen(t *testing.T) {
+	stores := map[string]func() Store{
+		"bolt":   func() Store { return nil },
+		"memory": NewMemoryStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			c := newTestChain(t, store())
+			payee := c.newAddress(t)
+
+			reward := c.spendable(t, c.miner)
+			if len(reward) != 1 {
+				t.Fatalf("miner has %d outputs, want 1", len(reward))
+			}
+			value := reward[0].Value
+			fanOut := c.spend(t, c.miner, []OutPoint{reward[0].OutPoint},
+				NewTxOutput(1, c.miner), NewTxOutput(2, c.miner), NewTxOutput(value-3, c.miner))
+			c.mine(t, fanOut)
+
+			spend := c.spend(t, c.miner, []OutPoint{NewOutPoint(fanOut.ID, 2)}, NewTxOutput(value-3, payee))
+			c.mine(t, spend)
+
+			c.reopen(t)
+
+			var outs *TxOutputs
+			if err := c.view(func(tx StoreTx) error {
+				var err error
+				outs, err = tx.UTXO(fanOut.ID)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if got := outs.Indexes(); len(got) != 2 || got[0] != 0 || got[1] != 1 {
+				t.Fatalf("unspent outputs of the fan out transaction are %v, want [0 1]", got)
+			}
+
+			paid := c.spendable(t, payee)
+			if len(paid) != 1 || paid[0].Value != value-3 || !paid[0].OutPoint.Equal(NewOutPoint(spend.ID, 0)) {
+				t.Fatalf("payee outputs are %v", paid)
+			}
+
+			stored, err := c.FindTx(spend.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Inputs[0].PrevOut.Index != 2 {
+				t.Fatalf("stored input spends output %d, want 2", stored.Inputs[0].PrevOut.Index)
+			}
+			if ok, err := c.VerifyTransaction(stored); err != nil || !ok {
+				t.Fatalf("stored spend doesn't verify: %v %v", ok, err)
+			}
+			if _, err := c.VerifyChain(VerifyUTXO, 0); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
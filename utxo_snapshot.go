@@ -0,0 +1,539 @@
+package hoji
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// utxoSnapshotVersion is the version of the UTXO snapshot format, see UTXOSnapshot in encoding.go
+const utxoSnapshotVersion = 1
+
+// snapshotBaseKey stores, inside the meta bucket, the hash of the block a loaded UTXO snapshot was taken at. It is removed once the snapshot was checked against the blocks up to it.
+const snapshotBaseKey = "s"
+
+// UTXOCommitment is a UTXO set known to be valid: UTXOHash is the hash of the UTXO set right after the block BlockHash at Height, as computed by DumpUTXOSet
+type UTXOCommitment struct {
+	Height    int64
+	BlockHash []byte
+	UTXOHash  []byte
+}
+
+// String returns the commitment as HEIGHT:BLOCKHASH:UTXOHASH with hex encoded hashes, the form ParseUTXOCommitment reads
+func (c UTXOCommitment) String() string {
+	return fmt.Sprintf("%d:%x:%x", c.Height, c.BlockHash, c.UTXOHash)
+}
+
+// ParseUTXOCommitment reads a commitment written as HEIGHT:BLOCKHASH:UTXOHASH, see UTXOCommitment.String
+func ParseUTXOCommitment(s string) (UTXOCommitment, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return UTXOCommitment{}, fmt.Errorf("%w: %q", ErrInvalidCommitment, s)
+	}
+	height, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || height < 0 {
+		return UTXOCommitment{}, fmt.Errorf("%w: height %q", ErrInvalidCommitment, parts[0])
+	}
+	blockHash, err := hex.DecodeString(parts[1])
+	if err != nil || len(blockHash) != sha256.Size {
+		return UTXOCommitment{}, fmt.Errorf("%w: block hash %q", ErrInvalidCommitment, parts[1])
+	}
+	utxoHash, err := hex.DecodeString(parts[2])
+	if err != nil || len(utxoHash) != sha256.Size {
+		return UTXOCommitment{}, fmt.Errorf("%w: UTXO hash %q", ErrInvalidCommitment, parts[2])
+	}
+	return UTXOCommitment{Height: height, BlockHash: blockHash, UTXOHash: utxoHash}, nil
+}
+
+// UTXOSnapshot describes a dumped or loaded UTXO set snapshot
+type UTXOSnapshot struct {
+	BlockHash    []byte
+	Height       int64
+	UTXOHash     []byte
+	Transactions int
+}
+
+// Commitment returns the commitment to the snapshot's UTXO set, another node can load the snapshot once it is added to its network with WithAssumeUTXO
+func (s *UTXOSnapshot) Commitment() UTXOCommitment {
+	return UTXOCommitment{Height: s.Height, BlockHash: s.BlockHash, UTXOHash: s.UTXOHash}
+}
+
+// DumpUTXOSet writes the UTXO set right after the block blockHash of the chain to w, at the tip when blockHash is empty. Besides the UTXO set the snapshot holds the headers from the genesis block to that block and its filter header, which is what a new node needs to continue the chain from it. The snapshot ends with a checksum of its content. The UTXO set at a block below the tip is rebuilt from the blocks up to it, a pruned node fails with ErrBlockPruned when they are gone.
+func (bc *Blockchain) DumpUTXOSet(w io.Writer, blockHash []byte) (*UTXOSnapshot, error) {
+	tip := bc.Tip()
+	if len(blockHash) == 0 {
+		blockHash = tip
+	}
+	var utxo map[string]*TxOutputs
+	if !bytes.Equal(blockHash, tip) {
+		if err := bc.view(func(tx StoreTx) error {
+			return onChain(tx, blockHash)
+		}); err != nil {
+			return nil, err
+		}
+		var err error
+		if utxo, err = bc.listUTXO(blockHash); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshot := new(UTXOSnapshot)
+	if err := bc.view(func(tx StoreTx) error {
+		forEachUTXO := tx.ForEachUTXO
+		if utxo != nil {
+			forEachUTXO = func(fn func(txID []byte, outs *TxOutputs) error) error {
+				return forEachSorted(utxo, fn)
+			}
+		} else if !bytes.Equal(tx.Tip(), tip) {
+			return ErrStaleTip
+		}
+
+		base, err := tx.Header(blockHash)
+		if err != nil {
+			return err
+		}
+		snapshot.BlockHash = base.Hash
+		snapshot.Height = base.Height
+
+		var headers []*BlockHeader
+		for header := base; len(header.PrevBlockHash) > 0; {
+			headers = append(headers, header)
+			if header, err = tx.Header(header.PrevBlockHash); err != nil {
+				return err
+			}
+		}
+		if err := forEachUTXO(func(txID []byte, outs *TxOutputs) error {
+			snapshot.Transactions++
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		checksum := sha256.New()
+		mw := io.MultiWriter(w, checksum)
+		if _, err := mw.Write(bc.params.Magic[:]); err != nil {
+			return err
+		}
+		if err := binary.Write(mw, binary.LittleEndian, uint32(utxoSnapshotVersion)); err != nil {
+			return err
+		}
+		if err := writeVarBytes(mw, base.Hash); err != nil {
+			return err
+		}
+		if err := writeVarInt(mw, uint64(len(headers))); err != nil {
+			return err
+		}
+		for i := len(headers) - 1; i >= 0; i-- {
+			if err := writeBlockHeader(mw, headers[i]); err != nil {
+				return err
+			}
+		}
+		if err := writeVarBytes(mw, tx.Get([]byte(cfheadersBucket), base.Hash)); err != nil {
+			return err
+		}
+
+		if err := writeVarInt(mw, uint64(snapshot.Transactions)); err != nil {
+			return err
+		}
+		utxoHash := sha256.New()
+		if err := forEachUTXO(func(txID []byte, outs *TxOutputs) error {
+			outsBytes, err := outs.Bytes()
+			if err != nil {
+				return err
+			}
+			if err := writeUTXOEntry(mw, txID, outsBytes); err != nil {
+				return err
+			}
+			return writeUTXOEntry(utxoHash, txID, outsBytes)
+		}); err != nil {
+			return err
+		}
+		snapshot.UTXOHash = sumUTXOHash(utxoHash)
+
+		_, err = w.Write(checksum.Sum(nil))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// LoadUTXOSnapshot bootstraps a chain that only has its genesis block from a snapshot written by DumpUTXOSet. The snapshot has to match one of the network's AssumeUTXO commitments and its headers have to form a valid chain. The blocks up to the snapshot are treated like pruned blocks until they are imported, ValidateSnapshot then checks the snapshot against them.
+func (bc *Blockchain) LoadUTXOSnapshot(r io.Reader) (*UTXOSnapshot, error) {
+	checksum := sha256.New()
+	tr := io.TeeReader(r, checksum)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(tr, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != bc.params.Magic {
+		return nil, ErrInvalidSnapshot
+	}
+	var version uint32
+	if err := binary.Read(tr, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != utxoSnapshotVersion {
+		return nil, ErrUnknownVersion
+	}
+
+	base, err := readVarBytes(tr)
+	if err != nil {
+		return nil, err
+	}
+	commitment := bc.params.utxoCommitment(base)
+	if commitment == nil {
+		return nil, ErrSnapshotMismatch
+	}
+
+	count, err := readVarInt(tr)
+	if err != nil {
+		return nil, err
+	}
+	if count != uint64(commitment.Height) {
+		return nil, ErrSnapshotMismatch
+	}
+	var headers []*BlockHeader
+	for i := uint64(0); i < count; i++ {
+		header, err := readBlockHeader(tr)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+	filterHeader, err := readVarBytes(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	if count, err = readVarInt(tr); err != nil {
+		return nil, err
+	}
+	utxoHash := sha256.New()
+	utxo := make(map[string]*TxOutputs)
+	for i := uint64(0); i < count; i++ {
+		txID, err := readVarBytes(tr)
+		if err != nil {
+			return nil, err
+		}
+		outsBytes, err := readVarBytes(tr)
+		if err != nil {
+			return nil, err
+		}
+		if utxo[hex.EncodeToString(txID)], err = BytesToOutputs(outsBytes); err != nil {
+			return nil, err
+		}
+		if err := writeUTXOEntry(utxoHash, txID, outsBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, checksum.Sum(nil)) {
+		return nil, ErrSnapshotChecksum
+	}
+	snapshot := &UTXOSnapshot{
+		BlockHash:    base,
+		Height:       commitment.Height,
+		UTXOHash:     sumUTXOHash(utxoHash),
+		Transactions: len(utxo),
+	}
+	if !bytes.Equal(snapshot.UTXOHash, commitment.UTXOHash) {
+		return nil, ErrSnapshotMismatch
+	}
+
+	if err := bc.update(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Tip(), bc.params.GenesisHash) {
+			return ErrBlockchainExists
+		}
+
+		for _, header := range headers {
+			prev, err := tx.Header(header.PrevBlockHash)
+			if err != nil {
+				return ErrHeaderNotConnected
+			}
+			bits, err := bc.nextBits(tx, prev)
+			if err != nil {
+				return err
+			}
+			if header.Bits != bits || !ValidateHeader(header) {
+				return ErrInvalidHeader
+			}
+			if err := tx.PutHeader(header); err != nil {
+				return err
+			}
+		}
+		last := bc.params.GenesisHash
+		if len(headers) > 0 {
+			last = headers[len(headers)-1].Hash
+		}
+		if !bytes.Equal(last, base) {
+			return ErrSnapshotMismatch
+		}
+
+		if err := tx.ResetUTXO(); err != nil {
+			return err
+		}
+		for txID, outs := range utxo {
+			key, err := hex.DecodeString(txID)
+			if err != nil {
+				return err
+			}
+			if err := tx.PutUTXO(key, outs); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Put([]byte(cfheadersBucket), base, filterHeader); err != nil {
+			return err
+		}
+		if err := tx.Put([]byte(metaBucket), []byte(pruneHeightKey), IntToByte(commitment.Height)); err != nil {
+			return err
+		}
+		if err := tx.Put([]byte(metaBucket), []byte(snapshotBaseKey), base); err != nil {
+			return err
+		}
+		return tx.SetTip(base)
+	}); err != nil {
+		return nil, err
+	}
+	bc.log.utxo.Info("UTXO snapshot loaded", "height", snapshot.Height, hashAttr("hash", snapshot.BlockHash), "txs", snapshot.Transactions)
+	return snapshot, nil
+}
+
+// ValidateSnapshot checks a loaded UTXO snapshot against the full history: the blocks up to the snapshot are validated like imported blocks, their coinbases, signatures and values, while the UTXO set is rebuilt from them, and the result is compared with the snapshot's commitment. It fails with ErrBlockPruned while those blocks haven't been imported, with a *BadBlockError for an invalid block, and does nothing when no snapshot is waiting to be validated.
+func (bc *Blockchain) ValidateSnapshot() error {
+	var base []byte
+	if err := bc.view(func(tx StoreTx) error {
+		base = append(base, tx.Get([]byte(metaBucket), []byte(snapshotBaseKey))...)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(base) == 0 {
+		return nil
+	}
+	commitment := bc.params.utxoCommitment(base)
+	if commitment == nil {
+		return ErrSnapshotMismatch
+	}
+
+	utxo, err := bc.replayBlocks(base)
+	if err != nil {
+		return err
+	}
+
+	utxoHash := sha256.New()
+	if err := forEachSorted(utxo, func(txID []byte, outs *TxOutputs) error {
+		outsBytes, err := outs.Bytes()
+		if err != nil {
+			return err
+		}
+		return writeUTXOEntry(utxoHash, txID, outsBytes)
+	}); err != nil {
+		return err
+	}
+	if !bytes.Equal(sumUTXOHash(utxoHash), commitment.UTXOHash) {
+		return ErrSnapshotMismatch
+	}
+
+	if err := bc.update(func(tx StoreTx) error {
+		return tx.Delete([]byte(metaBucket), []byte(snapshotBaseKey))
+	}); err != nil {
+		return err
+	}
+	bc.log.utxo.Info("UTXO snapshot validated", "height", commitment.Height, hashAttr("hash", base))
+	return nil
+}
+
+// replayBlocks validates the blocks from the genesis block to base and returns the UTXO set they leave. Every block is checked against the UTXO set left by the ones before it, so an output that doesn't exist or was already spent fails with ErrNotFound.
+func (bc *Blockchain) replayBlocks(base []byte) (map[string]*TxOutputs, error) {
+	var hashes [][]byte
+	if err := bc.view(func(tx StoreTx) error {
+		for hash := base; len(hash) > 0; {
+			header, err := tx.Header(hash)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, header.Hash)
+			hash = header.PrevBlockHash
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	utxo := make(map[string]*TxOutputs)
+	for i := len(hashes) - 1; i >= 0; i-- {
+		var block *Block
+		if err := bc.view(func(tx StoreTx) error {
+			var err error
+			block, err = tx.Block(hashes[i])
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		if i < len(hashes)-1 {
+			if err := bc.replayBlock(utxo, block); err != nil {
+				return nil, &BadBlockError{Hash: block.Hash, Height: block.Height, Err: err}
+			}
+			continue
+		}
+		// the genesis block is hard-coded, its coinbase is the first entry of the UTXO set
+		if err := applyBlock(utxo, block); err != nil {
+			return nil, err
+		}
+	}
+	return utxo, nil
+}
+
+// replayBlock checks the block's transactions against utxo and applies the block to it
+func (bc *Blockchain) replayBlock(utxo map[string]*TxOutputs, block *Block) error {
+	if err := bc.checkTransactions(block); err != nil {
+		return err
+	}
+	for _, t := range block.Transactions {
+		prevTxs := make(map[string]*Transaction)
+		if !t.IsCoinbase() {
+			for _, input := range t.Inputs {
+				id := hex.EncodeToString(input.PrevOut.TxID)
+				outs := utxo[id]
+				if outs == nil {
+					return ErrNotFound
+				}
+				prevTx := &Transaction{ID: input.PrevOut.TxID}
+				for index, out := range outs.Outputs {
+					for len(prevTx.Outputs) <= index {
+						prevTx.Outputs = append(prevTx.Outputs, nil)
+					}
+					prevTx.Outputs[index] = out
+				}
+				prevTxs[id] = prevTx
+			}
+			ok, err := t.Verify(prevTxs)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrInvalidSignature
+			}
+		}
+		if err := checkTxValues(t, prevTxs); err != nil {
+			return err
+		}
+	}
+	return applyBlock(utxo, block)
+}
+
+// applyBlock applies a block to utxo like updateUTXO applies it to the stored UTXO set
+func applyBlock(utxo map[string]*TxOutputs, block *Block) error {
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, input := range t.Inputs {
+				id := hex.EncodeToString(input.PrevOut.TxID)
+				outs := utxo[id]
+				if outs == nil {
+					return ErrNotFound
+				}
+				if _, ok := outs.Outputs[input.PrevOut.Index]; !ok {
+					return ErrNotFound
+				}
+				delete(outs.Outputs, input.PrevOut.Index)
+				if len(outs.Outputs) == 0 {
+					delete(utxo, id)
+				}
+			}
+		}
+
+		outs := NewTxOutputs()
+		for i, out := range t.Outputs {
+			outs.Outputs[i] = out
+		}
+		utxo[hex.EncodeToString(t.ID)] = outs
+	}
+	return nil
+}
+
+// ValidateSnapshotInBackground runs ValidateSnapshot in its own goroutine, the returned channel receives its result
+func (bc *Blockchain) ValidateSnapshotInBackground() <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- bc.ValidateSnapshot()
+	}()
+	return done
+}
+
+// utxoCommitment returns the network's commitment to the UTXO set at the block hash, nil if there is none
+func (p *ChainParams) utxoCommitment(blockHash []byte) *UTXOCommitment {
+	for i := range p.AssumeUTXO {
+		if bytes.Equal(p.AssumeUTXO[i].BlockHash, blockHash) {
+			return &p.AssumeUTXO[i]
+		}
+	}
+	return nil
+}
+
+// forEachSorted calls fn for every transaction of utxo in txID order, like ForEachUTXO
+func forEachSorted(utxo map[string]*TxOutputs, fn func(txID []byte, outs *TxOutputs) error) error {
+	txIDs := make([]string, 0, len(utxo))
+	for txID := range utxo {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+
+	for _, txID := range txIDs {
+		key, err := hex.DecodeString(txID)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, utxo[txID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onChain fails with ErrNotFound unless the block hash is part of the chain ending at the tip
+func onChain(tx StoreTx, hash []byte) error {
+	block, err := tx.Header(hash)
+	if err != nil {
+		return err
+	}
+	header, err := tx.Header(tx.Tip())
+	for err == nil && header.Height > block.Height {
+		header, err = tx.Header(header.PrevBlockHash)
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header.Hash, hash) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// writeUTXOEntry writes one transaction of the UTXO set, in txID order these entries make up both the snapshot and the data its hash is computed over
+func writeUTXOEntry(w io.Writer, txID, outs []byte) error {
+	if err := writeVarBytes(w, txID); err != nil {
+		return err
+	}
+	return writeVarBytes(w, outs)
+}
+
+// sumUTXOHash finishes the UTXO set hash, the double sha256 of its entries
+func sumUTXOHash(h hash.Hash) []byte {
+	sum := sha256.Sum256(h.Sum(nil))
+	return sum[:]
+}
@@ -0,0 +1,181 @@
+package hoji
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newNode opens a regtest blockchain that only has its genesis block, it is closed at the end of the test
+func newNode(t *testing.T, opts ...Option) *Blockchain {
+	t.Helper()
+
+	bc, err := NewBlockchain(append([]Option{WithNetwork("regtest"), WithDataDir(t.TempDir())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+// exportBlocks returns the block file of the source chain up to height
+func exportBlocks(t *testing.T, source *Blockchain, height int64) *bytes.Buffer {
+	t.Helper()
+
+	var file bytes.Buffer
+	if _, err := source.ExportBlocks(&file, 0, height, nil); err != nil {
+		t.Fatal(err)
+	}
+	return &file
+}
+
+func TestAssumeUTXOGenesis(t *testing.T) {
+	for _, params := range networks {
+		genesis, err := params.GenesisBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitment := params.utxoCommitment(genesis.Hash)
+		if commitment == nil || commitment.Height != 0 {
+			t.Fatalf("%s: no commitment to the genesis block", params.Name)
+		}
+	}
+
+	c := newTestChain(t, nil)
+	c.mine(t)
+	var file bytes.Buffer
+	snapshot, err := c.DumpUTXOSet(&file, RegTestParams.GenesisHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(snapshot.UTXOHash, RegTestParams.utxoCommitment(RegTestParams.GenesisHash).UTXOHash) {
+		t.Fatalf("genesis UTXO set hash %x doesn't match the commitment", snapshot.UTXOHash)
+	}
+
+	node := newNode(t)
+	if _, err := node.LoadUTXOSnapshot(&file); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.ValidateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUTXOSnapshot(t *testing.T) {
+	c := newTestChain(t, nil)
+	payee := c.newAddress(t)
+	reward := c.spendable(t, c.miner)[0]
+	base := c.mine(t, c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(3, payee), NewTxOutput(reward.Value-3, c.miner)))
+	c.mine(t)
+
+	var file bytes.Buffer
+	snapshot, err := c.DumpUTXOSet(&file, base.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(snapshot.BlockHash, base.Hash) || snapshot.Height != base.Height || snapshot.Transactions != 3 {
+		t.Fatalf("snapshot of %d transactions at %x height %d", snapshot.Transactions, snapshot.BlockHash, snapshot.Height)
+	}
+	var tipFile bytes.Buffer
+	tipSnapshot, err := c.DumpUTXOSet(&tipFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tipSnapshot.BlockHash, c.Tip()) || bytes.Equal(tipSnapshot.UTXOHash, snapshot.UTXOHash) {
+		t.Fatal("the snapshot at the tip is the one at the base block")
+	}
+
+	if _, err := newNode(t).LoadUTXOSnapshot(bytes.NewReader(file.Bytes())); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("got %v, want ErrSnapshotMismatch without a commitment", err)
+	}
+	node := newNode(t, WithAssumeUTXO(snapshot.Commitment()))
+	if _, err := node.LoadUTXOSnapshot(&file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(node.Tip(), base.Hash) {
+		t.Fatal("tip isn't the snapshot's block")
+	}
+	if err := node.ValidateSnapshot(); !errors.Is(err, ErrBlockPruned) {
+		t.Fatalf("got %v, want ErrBlockPruned before the blocks are imported", err)
+	}
+
+	if _, err := node.ImportBlocks(exportBlocks(t, c.Blockchain, base.Height), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.ValidateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.VerifyChain(VerifyUTXO, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUTXOSnapshotInvalidHistory(t *testing.T) {
+	c := newTestChain(t, nil)
+	reward := c.spendable(t, c.miner)[0]
+	tx := c.spend(t, c.miner, []OutPoint{reward.OutPoint}, NewTxOutput(reward.Value*10, c.newAddress(t)))
+
+	// an inflating block in the history of a snapshot its commitment vouches for
+	block := c.newBlock(t, tx)
+	if err := c.update(func(tx StoreTx) error {
+		return c.connectBlock(tx, block)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	snapshot, err := c.DumpUTXOSet(&file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := newNode(t, WithAssumeUTXO(snapshot.Commitment()))
+	if _, err := node.LoadUTXOSnapshot(&file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.ImportBlocks(exportBlocks(t, c.Blockchain, block.Height), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var bad *BadBlockError
+	err = node.ValidateSnapshot()
+	if !errors.As(err, &bad) || !errors.Is(err, ErrValueExceedsInputs) || bad.Height != block.Height {
+		t.Fatalf("got %v, want block %d to exceed its inputs", err, block.Height)
+	}
+}
+
+func TestUTXOCommitmentConfig(t *testing.T) {
+	commitment := UTXOCommitment{Height: 7, BlockHash: bytes.Repeat([]byte{0xab}, 32), UTXOHash: bytes.Repeat([]byte{0x01}, 32)}
+	parsed, err := ParseUTXOCommitment(commitment.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Height != commitment.Height || !bytes.Equal(parsed.BlockHash, commitment.BlockHash) || !bytes.Equal(parsed.UTXOHash, commitment.UTXOHash) {
+		t.Fatalf("parsed %s, want %s", parsed, commitment)
+	}
+	for _, s := range []string{"", "7", "7:ab", "-1:" + strings.Repeat("ab", 32) + ":" + strings.Repeat("01", 32), "7:abab:" + strings.Repeat("01", 32), "x:" + strings.Repeat("ab", 32) + ":" + strings.Repeat("01", 32)} {
+		if _, err := ParseUTXOCommitment(s); !errors.Is(err, ErrInvalidCommitment) {
+			t.Errorf("%q: got %v, want ErrInvalidCommitment", s, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	if err := os.WriteFile(path, []byte("network = regtest\nassumeutxo = "+commitment.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := ResolveParams(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.utxoCommitment(commitment.BlockHash) == nil || params.utxoCommitment(RegTestParams.GenesisHash) == nil {
+		t.Error("the configured commitment wasn't added to the network's")
+	}
+	if RegTestParams.utxoCommitment(commitment.BlockHash) != nil {
+		t.Error("the commitment was added to the shared regtest parameters")
+	}
+}
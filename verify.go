@@ -0,0 +1,338 @@
+package hoji
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// Verification levels of VerifyChain, each one includes the checks of the levels below it
+const (
+	// VerifyLinks checks that every block is stored under its hash, links to its parent and has the right height
+	VerifyLinks = iota
+	// VerifyPoW checks the proof of work and the difficulty of every block
+	VerifyPoW
+	// VerifyContents checks the transactions of every block against its merkle root, its coinbase, the values its transactions spend and pay out, and its compact filter
+	VerifyContents
+	// VerifySignatures checks the signatures of every transaction
+	VerifySignatures
+	// VerifyUTXO recomputes the UTXO set from the whole chain and compares it with the stored one
+	VerifyUTXO
+)
+
+// BadBlockError reports the first block that failed verification
+type BadBlockError struct {
+	Hash   []byte
+	Height int64
+	Err    error
+}
+
+func (e *BadBlockError) Error() string {
+	return fmt.Sprintf("block %x at height %d: %v", e.Hash, e.Height, e.Err)
+}
+
+func (e *BadBlockError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyChain re-validates the last depth blocks, or every block if depth is 0, up to the given level. Blocks are checked oldest first and the first failure is returned as a *BadBlockError; a UTXO set that doesn't match the chain returns an error wrapping ErrUTXOMismatch. It returns the number of blocks checked. A pruned node only checks the blocks it still has, and can't check its UTXO set.
+func (bc *Blockchain) VerifyChain(level, depth int) (int, error) {
+	var blocks []*Block
+	if err := bc.view(func(tx StoreTx) error {
+		hash := tx.Tip()
+		height := int64(-1)
+		for len(hash) > 0 && (depth <= 0 || len(blocks) < depth) {
+			block, err := tx.Block(hash)
+			if err == ErrBlockPruned {
+				break
+			}
+			if err != nil {
+				return &BadBlockError{Hash: hash, Height: height, Err: err}
+			}
+			if !bytes.Equal(block.Hash, hash) {
+				return &BadBlockError{Hash: hash, Height: block.Height, Err: ErrBadBlockHash}
+			}
+			blocks = append(blocks, block)
+			hash = block.PrevBlockHash
+			height = block.Height - 1
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		if err := bc.verifyBlock(block, level); err != nil {
+			return len(blocks) - 1 - i, &BadBlockError{Hash: block.Hash, Height: block.Height, Err: err}
+		}
+	}
+
+	if level >= VerifyUTXO {
+		if err := bc.verifyUTXO(); err != nil {
+			return len(blocks), err
+		}
+	}
+
+	return len(blocks), nil
+}
+
+func (bc *Blockchain) verifyBlock(block *Block, level int) error {
+	if err := bc.view(func(tx StoreTx) error {
+		var prev *BlockHeader
+		if len(block.PrevBlockHash) == 0 {
+			if !bytes.Equal(block.Hash, bc.params.GenesisHash) || block.Height != 0 {
+				return ErrGenesisMismatch
+			}
+		} else {
+			var err error
+			if prev, err = tx.Header(block.PrevBlockHash); err != nil {
+				return ErrHeaderNotConnected
+			}
+			if block.Height != prev.Height+1 {
+				return ErrHeaderNotConnected
+			}
+		}
+
+		if level >= VerifyPoW {
+			bits := bc.params.GenesisBits
+			if prev != nil {
+				var err error
+				if bits, err = bc.nextBits(tx, prev); err != nil {
+					return err
+				}
+			}
+			if block.Bits != bits || !NewPOW(block).Validate() {
+				return ErrInvalidHeader
+			}
+		}
+
+		if level >= VerifyContents {
+			return bc.verifyContents(tx, block)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if level >= VerifyContents {
+		return bc.verifyTransactions(block, level)
+	}
+	return nil
+}
+
+// verifyTransactions checks that the block's transactions don't create value, against the outputs they spent when the block was connected, and at VerifySignatures that they are signed by the owners of the outputs they spend
+func (bc *Blockchain) verifyTransactions(block *Block, level int) error {
+	prevTxs, err := bc.blockPrevTxs(block)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range block.Transactions {
+		if err := checkTxValues(t, prevTxs); err != nil {
+			return err
+		}
+
+		if level >= VerifySignatures {
+			ok, err := t.Verify(prevTxs)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrInvalidSignature
+			}
+		}
+	}
+	return nil
+}
+
+// verifyContents checks the block's transactions and its filter. The merkle root itself is covered by the block hash, which commits to the transactions.
+func (bc *Blockchain) verifyContents(tx StoreTx, block *Block) error {
+	if err := bc.checkTransactions(block); err != nil {
+		return err
+	}
+
+	filter, err := NewBlockFilter(block)
+	if err != nil {
+		return err
+	}
+	filterBytes, err := filter.Bytes()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tx.Get([]byte(cfiltersBucket), block.Hash), filterBytes) {
+		return ErrFilterMismatch
+	}
+	prevHeader := make([]byte, len(bc.params.GenesisHash))
+	if len(block.PrevBlockHash) > 0 {
+		prevHeader = tx.Get([]byte(cfheadersBucket), block.PrevBlockHash)
+	}
+	if !bytes.Equal(tx.Get([]byte(cfheadersBucket), block.Hash), NextFilterHeader(filterBytes, prevHeader)) {
+		return ErrFilterMismatch
+	}
+
+	return nil
+}
+
+// verifyUTXO compares the stored UTXO set with the one computed from the chain. The cache is flushed first and bypassed so the comparison covers what is on disk.
+func (bc *Blockchain) verifyUTXO() error {
+	if err := bc.Flush(); err != nil {
+		return err
+	}
+	expected, err := bc.ListUTXO()
+	if err != nil {
+		return err
+	}
+
+	var missing, unexpected, different int
+	if err := bc.store.View(func(tx StoreTx) error {
+		seen := make(map[string]bool)
+		if err := tx.ForEachUTXO(func(txID []byte, outs *TxOutputs) error {
+			id := hex.EncodeToString(txID)
+			seen[id] = true
+
+			want, ok := expected[id]
+			if !ok {
+				unexpected++
+				return nil
+			}
+			wantBytes, err := want.Bytes()
+			if err != nil {
+				return err
+			}
+			gotBytes, err := outs.Bytes()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(wantBytes, gotBytes) {
+				different++
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for id := range expected {
+			if !seen[id] {
+				missing++
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if missing+unexpected+different > 0 {
+		return fmt.Errorf("%w: %d missing, %d unexpected and %d different transactions", ErrUTXOMismatch, missing, unexpected, different)
+	}
+	return nil
+}
+
+// InvalidateBlock rewinds the chain to the parent of the block with the given hash, the blocks are taken off the UTXO set with their undo records, which is written to the store together with the new tip rather than left in the UTXO cache. The block and its descendants stay in the store but are no longer part of the chain, their transactions are removed from the address history index. Blocks connected before undo records were written make the UTXO set be rebuilt from the chain instead, which pruned nodes can't do. Pruned nodes fail with ErrBlockPruned for blocks they no longer keep.
+func (bc *Blockchain) InvalidateBlock(hash []byte) error {
+	var prevHash, tip []byte
+	undone := true
+	if err := bc.view(func(tx StoreTx) error {
+		block, err := tx.Block(hash)
+		if err != nil {
+			return err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return ErrGenesisMismatch
+		}
+
+		// the block has to be on the chain, not on a branch that was already left
+		tip = tx.Tip()
+		for h := tip; ; {
+			b, err := tx.Block(h)
+			if err != nil {
+				return err
+			}
+			if b.Height < block.Height {
+				return ErrNotFound
+			}
+			if _, err := blockUndo(tx, b); err == ErrNotFound {
+				undone = false
+			} else if err != nil {
+				return err
+			}
+			if bytes.Equal(h, hash) {
+				break
+			}
+			h = b.PrevBlockHash
+		}
+		if !undone && pruneHeight(tx) > 0 {
+			return ErrBlockPruned
+		}
+
+		prevHash = block.PrevBlockHash
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if undone {
+		return bc.disconnectBlocks(hash, tip, prevHash)
+	}
+
+	utxo, err := bc.listUTXO(prevHash)
+	if err != nil {
+		return err
+	}
+
+	// the tip, the UTXO set and the address history index are rewound together
+	if err := bc.update(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Tip(), tip) {
+			return ErrStaleTip
+		}
+		if bc.addrIndex {
+			for h := tip; !bytes.Equal(h, prevHash); {
+				b, err := tx.Block(h)
+				if err != nil {
+					return err
+				}
+				if err := disconnectHistory(tx, b); err != nil {
+					return err
+				}
+				h = b.PrevBlockHash
+			}
+		}
+		if err := tx.SetTip(prevHash); err != nil {
+			return err
+		}
+		return putUTXOSet(tx, utxo)
+	}); err != nil {
+		return err
+	}
+	bc.log.chain.Warn("block invalidated", hashAttr("hash", hash), hashAttr("tip", prevHash))
+	return nil
+}
+
+// disconnectBlocks rewinds the chain from tip to prevHash, the parent of the invalidated block hash, undoing the blocks one by one
+func (bc *Blockchain) disconnectBlocks(hash, tip, prevHash []byte) error {
+	if err := bc.updateWriteThrough(func(tx StoreTx) error {
+		if !bytes.Equal(tx.Tip(), tip) {
+			return ErrStaleTip
+		}
+		for h := tip; !bytes.Equal(h, prevHash); {
+			b, err := tx.Block(h)
+			if err != nil {
+				return err
+			}
+			if err := disconnectBlock(tx, b); err != nil {
+				return err
+			}
+			if bc.addrIndex {
+				if err := disconnectHistory(tx, b); err != nil {
+					return err
+				}
+			}
+			h = b.PrevBlockHash
+		}
+		return tx.SetTip(prevHash)
+	}); err != nil {
+		return err
+	}
+	bc.log.chain.Warn("block invalidated", hashAttr("hash", hash), hashAttr("tip", prevHash))
+	return nil
+}
@@ -0,0 +1,34 @@
+package hoji
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyChainValues(t *testing.T) {
+	c := newTestChain(t, nil)
+	spent := c.spendable(t, c.miner)[0]
+	tx := c.spend(t, c.miner, []OutPoint{spent.OutPoint}, NewTxOutput(spent.Value*2, c.newAddress(t)))
+
+	// an inflating block that got into the store around the checks
+	block := c.newBlock(t, tx)
+	if err := c.update(func(tx StoreTx) error {
+		return c.connectBlock(tx, block)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.VerifyChain(VerifyPoW, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []int{VerifyContents, VerifySignatures} {
+		var bad *BadBlockError
+		_, err := c.VerifyChain(level, 0)
+		if !errors.As(err, &bad) || !errors.Is(err, ErrValueExceedsInputs) {
+			t.Fatalf("level %d: got %v, want ErrValueExceedsInputs", level, err)
+		}
+		if bad.Height != block.Height {
+			t.Fatalf("level %d: block %d reported, want %d", level, bad.Height, block.Height)
+		}
+	}
+}
@@ -6,41 +6,106 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"math/big"
 
 	"gitlab.com/rodzzlessa24/hoji/base58"
 	"golang.org/x/crypto/ripemd160"
 )
 
-const version = byte(0x00)
 const walletFile = "wallet.dat"
 const addressChecksumLen = 4
+const privKeyLen = 32
+
+// wifCompressed is appended to a WIF key whose wallet uses a compressed public key
+const wifCompressed = byte(0x01)
 
 //Wallet is
 type Wallet struct {
 	PublicKey  []byte
 	PrivateKey *ecdsa.PrivateKey
+	// params select the version bytes of the wallet's address and WIF key, they aren't saved in the wallet file
+	params *ChainParams
 }
 
-//NewWallet creates a new private public key pair
+//NewWallet creates a new private public key pair for mainnet, Wallets.AddWallet creates one for the wallets' network
 func NewWallet() (*Wallet, error) {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		return nil, err
 	}
+	pubKey := compressPubKey(&private.PublicKey)
+
+	wallet := Wallet{
+		PrivateKey: private,
+		PublicKey:  pubKey,
+		params:     &MainNetParams,
+	}
+
+	return &wallet, nil
+}
+
+// NewWalletFromWIF rebuilds a wallet from a private key in wallet import format. Keys without the compression flag restore the legacy uncompressed public key so that older addresses keep working. The wallet belongs to the first network using the key's version byte, Wallets.ImportWallet moves it to the wallets' network when they share the version byte.
+func NewWalletFromWIF(wif []byte) (*Wallet, error) {
+	decoded := base58.Decode(wif)
+	if len(decoded) < addressChecksumLen {
+		return nil, ErrInvalidWIF
+	}
+	payload := decoded[:len(decoded)-addressChecksumLen]
+	if len(payload) == 0 || !bytes.Equal(decoded[len(payload):], checksum(payload)) {
+		return nil, ErrInvalidWIF
+	}
+	var params *ChainParams
+	for _, p := range networks {
+		if p.WIFVersion == payload[0] {
+			params = p
+			break
+		}
+	}
+	if params == nil {
+		return nil, ErrInvalidWIF
+	}
+
+	compressed := false
+	switch {
+	case len(payload) == 1+privKeyLen:
+	case len(payload) == 2+privKeyLen && payload[len(payload)-1] == wifCompressed:
+		compressed = true
+	default:
+		return nil, ErrInvalidWIF
+	}
+	key := payload[1 : 1+privKeyLen]
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(key)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidWIF
+	}
+
+	private := &ecdsa.PrivateKey{D: d}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(key)
+
 	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	if compressed {
+		pubKey = compressPubKey(&private.PublicKey)
+	}
 
 	wallet := Wallet{
 		PrivateKey: private,
 		PublicKey:  pubKey,
+		params:     params,
 	}
 
 	return &wallet, nil
 }
 
-// ValidateAddress check if address if valid
+// ValidateAddress check if address if valid, whatever its network. Use ChainParams.ValidateAddress to also check the network.
 func ValidateAddress(address string) bool {
 	pubKeyHash := base58.Decode([]byte(address))
+	if len(pubKeyHash) <= addressChecksumLen {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
@@ -55,7 +120,7 @@ func (w *Wallet) GetAddress() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	versionedPayload := append([]byte{version}, hashedPubKey...)
+	versionedPayload := append([]byte{w.chainParams().AddressVersion}, hashedPubKey...)
 	checksum := checksum(versionedPayload)
 	fullPayload := append(versionedPayload, checksum...)
 
@@ -64,6 +129,28 @@ func (w *Wallet) GetAddress() ([]byte, error) {
 	return address, nil
 }
 
+// WIF exports the private key in wallet import format: base58check(network WIF version || 32 byte key [|| 0x01 if the public key is compressed])
+func (w *Wallet) WIF() []byte {
+	payload := append([]byte{w.chainParams().WIFVersion}, padScalar(w.PrivateKey.D)...)
+	if len(w.PublicKey) == compressedPubKeyLen {
+		payload = append(payload, wifCompressed)
+	}
+
+	return base58.Encode(append(payload, checksum(payload)...))
+}
+
+func (w *Wallet) chainParams() *ChainParams {
+	if w.params == nil {
+		return &MainNetParams
+	}
+	return w.params
+}
+
+// addressVersion returns the version byte of an address with a valid checksum
+func addressVersion(address string) byte {
+	return base58.Decode([]byte(address))[0]
+}
+
 func hashPubKey(pubKey []byte) ([]byte, error) {
 	publicSHA256 := sha256.Sum256(pubKey)
 
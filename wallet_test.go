@@ -0,0 +1,76 @@
+package hoji
+
+import (
+	"bytes"
+	"testing"
+
+	"gitlab.com/rodzzlessa24/hoji/base58"
+)
+
+func TestWIFRoundTrip(t *testing.T) {
+	for _, params := range networks {
+		for _, compressed := range []bool{true, false} {
+			w, err := NewWallet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.params = params
+			if !compressed {
+				w.PublicKey = append(w.PrivateKey.PublicKey.X.Bytes(), w.PrivateKey.PublicKey.Y.Bytes()...)
+			}
+
+			wif := w.WIF()
+			decoded := base58.Decode(wif)
+			if decoded[0] != params.WIFVersion {
+				t.Errorf("%s: WIF version byte %#x, want %#x", params.Name, decoded[0], params.WIFVersion)
+			}
+
+			imported, err := NewWalletFromWIF(wif)
+			if err != nil {
+				t.Fatalf("%s compressed %v: %v", params.Name, compressed, err)
+			}
+			if imported.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 {
+				t.Errorf("%s compressed %v: private key changed", params.Name, compressed)
+			}
+			if !bytes.Equal(imported.PublicKey, w.PublicKey) {
+				t.Errorf("%s compressed %v: public key %x, want %x", params.Name, compressed, imported.PublicKey, w.PublicKey)
+			}
+			// testnet and regtest share their version byte, the key is imported for the first of them
+			if imported.chainParams().WIFVersion != params.WIFVersion {
+				t.Errorf("%s: imported for %s", params.Name, imported.chainParams().Name)
+			}
+			if !bytes.Equal(imported.WIF(), wif) {
+				t.Errorf("%s compressed %v: exported %s again, want %s", params.Name, compressed, imported.WIF(), wif)
+			}
+		}
+	}
+}
+
+func TestInvalidWIF(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base58.Decode(w.WIF())
+	payload = payload[:len(payload)-addressChecksumLen]
+
+	badChecksum := append(append([]byte{}, payload...), checksum(payload)...)
+	badChecksum[len(badChecksum)-1] ^= 1
+
+	unknownVersion := append([]byte{0x42}, payload[1:]...)
+	unknownVersion = append(unknownVersion, checksum(unknownVersion)...)
+
+	badFlag := append(append([]byte{}, payload[:len(payload)-1]...), 0x02)
+	badFlag = append(badFlag, checksum(badFlag)...)
+
+	for name, wif := range map[string][]byte{
+		"bad checksum":    base58.Encode(badChecksum),
+		"unknown version": base58.Encode(unknownVersion),
+		"bad compression": base58.Encode(badFlag),
+		"empty":           nil,
+	} {
+		if _, err := NewWalletFromWIF(wif); err != ErrInvalidWIF {
+			t.Errorf("%s: got %v, want ErrInvalidWIF", name, err)
+		}
+	}
+}
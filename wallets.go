@@ -6,20 +6,39 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"path/filepath"
 )
 
 //Wallets is
 type Wallets struct {
 	Wallets map[string]*Wallet
+	// path of the wallet file and network of its wallets, not part of the file itself
+	path   string
+	params *ChainParams
+	log    *slog.Logger
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
-func NewWallets() (*Wallets, error) {
-	wallets := Wallets{}
+// NewWallets creates Wallets and fills it from the network's wallet file if it exists
+func NewWallets(opts ...Option) (*Wallets, error) {
+	o := newOptions(opts)
+	params, err := o.chainParams()
+	if err != nil {
+		return nil, err
+	}
+	path, err := o.path(walletFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkLegacyFile(walletFile, path); err != nil {
+		return nil, err
+	}
+
+	wallets := Wallets{path: path, params: params, log: o.loggers().wallet}
 	wallets.Wallets = make(map[string]*Wallet)
 
-	if err := wallets.LoadFromFile(); err != nil {
+	if err := wallets.LoadFromFile(); err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
@@ -32,6 +51,17 @@ func (ws *Wallets) AddWallet() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	wallet.params = ws.chainParams()
+
+	return ws.ImportWallet(wallet)
+}
+
+// ImportWallet adds an existing Wallet, e.g. one restored from a WIF key, to Wallets. The wallet has to use the same WIF version as the wallets' network.
+func (ws *Wallets) ImportWallet(wallet *Wallet) ([]byte, error) {
+	if wallet.chainParams().WIFVersion != ws.chainParams().WIFVersion {
+		return nil, ErrWrongNetwork
+	}
+	wallet.params = ws.chainParams()
 
 	address, err := wallet.GetAddress()
 	if err != nil {
@@ -41,6 +71,7 @@ func (ws *Wallets) AddWallet() ([]byte, error) {
 	strAddr := fmt.Sprintf("%s", address)
 
 	ws.Wallets[string(strAddr)] = wallet
+	ws.logger().Info("wallet added", "address", strAddr)
 
 	return address, nil
 }
@@ -63,11 +94,11 @@ func (ws *Wallets) GetWallet(address string) *Wallet {
 
 // LoadFromFile loads wallets from the file
 func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(ws.file()); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(ws.file())
 	if err != nil {
 		return err
 	}
@@ -82,12 +113,16 @@ func (ws *Wallets) LoadFromFile() error {
 		return err
 	}
 
+	for _, wallet := range wallets.Wallets {
+		wallet.params = ws.chainParams()
+	}
 	ws.Wallets = wallets.Wallets
+	ws.logger().Debug("wallet file loaded", "path", ws.file(), "wallets", len(ws.Wallets))
 
 	return nil
 }
 
-// SaveToFile saves wallets to a file
+// SaveToFile saves wallets to a file only the user can read, it holds the private keys
 func (ws *Wallets) SaveToFile() error {
 	var content bytes.Buffer
 
@@ -96,5 +131,47 @@ func (ws *Wallets) SaveToFile() error {
 		return err
 	}
 
-	return ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	// the keys go to a new 0600 file renamed over the old one, which older versions created readable by everyone, so they are never readable by others nor half written
+	tmp, err := os.CreateTemp(filepath.Dir(ws.file()), filepath.Base(ws.file())+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ws.file()); err != nil {
+		return err
+	}
+	ws.logger().Debug("wallet file saved", "path", ws.file(), "wallets", len(ws.Wallets))
+	return nil
+}
+
+func (ws *Wallets) chainParams() *ChainParams {
+	if ws.params == nil {
+		return &MainNetParams
+	}
+	return ws.params
+}
+
+func (ws *Wallets) logger() *slog.Logger {
+	if ws.log == nil {
+		return slog.New(discardHandler{})
+	}
+	return ws.log
+}
+
+func (ws *Wallets) file() string {
+	if ws.path == "" {
+		return walletFile
+	}
+	return ws.path
 }